@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucket refills a bucket of ARGV[1] tokens evenly over ARGV[2]
+// milliseconds and takes ARGV[3] tokens from it if at least one is left. It
+// replies {1, 0} when a token is available, or {0, ms} with the time until
+// one is. The clock is the Redis server's, so every instance sees the same
+// bucket state.
+var tokenBucket = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated_at')
+local tokens = tonumber(bucket[1]) or capacity
+local updated_at = tonumber(bucket[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - updated_at) * capacity / period)
+
+if tokens < 1 then
+	return {0, math.ceil((1 - tokens) * period / capacity)}
+end
+
+if cost > 0 then
+	redis.call('HSET', KEYS[1], 'tokens', tokens - cost, 'updated_at', now)
+	redis.call('PEXPIRE', KEYS[1], period)
+end
+return {1, 0}
+`)
+
+// RateLimiter enforces token bucket limits shared by every server instance.
+// Buckets are updated atomically by a Lua script, so concurrent takes never
+// overdraw them.
+type RateLimiter struct {
+	client redis.Scripter
+}
+
+func NewRateLimiter(client redis.Scripter) *RateLimiter {
+	return &RateLimiter{
+		client: client,
+	}
+}
+
+// Peek reports whether the bucket at key, allowing limit tokens per period,
+// has a token left without taking it. Otherwise it returns how long until
+// the bucket has one.
+func (l *RateLimiter) Peek(ctx context.Context, key string, limit int, period time.Duration) (bool, time.Duration, error) {
+	return l.run(ctx, key, limit, period, 0)
+}
+
+// Take takes a token from the bucket at key, allowing limit tokens per
+// period. When the bucket is empty nothing is taken and it returns how long
+// until the bucket has a token.
+func (l *RateLimiter) Take(ctx context.Context, key string, limit int, period time.Duration) (bool, time.Duration, error) {
+	return l.run(ctx, key, limit, period, 1)
+}
+
+func (l *RateLimiter) run(ctx context.Context, key string, limit int, period time.Duration, cost int) (bool, time.Duration, error) {
+	reply, err := tokenBucket.Run(ctx, l.client, []string{key}, limit, period.Milliseconds(), cost).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return reply[0] == 1, time.Duration(reply[1]) * time.Millisecond, nil
+}
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// scripterMock records the arguments of script calls and replies with reply
+type scripterMock struct {
+	redis.Scripter
+	keys  []string
+	args  []interface{}
+	reply interface{}
+}
+
+func (m *scripterMock) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd {
+	m.keys = keys
+	m.args = args
+	return redis.NewCmdResult(m.reply, nil)
+}
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name            string
+		take            bool
+		reply           []interface{}
+		expectedCost    int
+		expectedAllowed bool
+		expectedWait    time.Duration
+	}{
+		{
+			name:            "Take allowed",
+			take:            true,
+			reply:           []interface{}{int64(1), int64(0)},
+			expectedCost:    1,
+			expectedAllowed: true,
+		},
+		{
+			name:            "Take denied",
+			take:            true,
+			reply:           []interface{}{int64(0), int64(600)},
+			expectedCost:    1,
+			expectedAllowed: false,
+			expectedWait:    600 * time.Millisecond,
+		},
+		{
+			name:            "Peek",
+			take:            false,
+			reply:           []interface{}{int64(1), int64(0)},
+			expectedCost:    0,
+			expectedAllowed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &scripterMock{reply: tt.reply}
+			limiter := NewRateLimiter(client)
+
+			call := limiter.Peek
+			if tt.take {
+				call = limiter.Take
+			}
+			allowed, wait, err := call(context.Background(), "ratelimit:sms", 100, time.Minute)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedAllowed, allowed)
+			assert.Equal(t, tt.expectedWait, wait)
+			assert.Equal(t, []string{"ratelimit:sms"}, client.keys)
+			assert.Equal(t, []interface{}{100, int64(60000), tt.expectedCost}, client.args)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{name: "Too few fields", expr: "* * * *"},
+		{name: "Too many fields", expr: "* * * * * *"},
+		{name: "Minute out of range", expr: "60 * * * *"},
+		{name: "Hour out of range", expr: "0 24 * * *"},
+		{name: "Day of month zero", expr: "0 0 0 * *"},
+		{name: "Unknown month name", expr: "0 0 1 foo *"},
+		{name: "Reversed range", expr: "0 5-1 * * *"},
+		{name: "Zero step", expr: "*/0 * * * *"},
+		{name: "Unknown macro", expr: "@sometimes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	base := time.Date(2024, time.January, 15, 10, 17, 30, 0, time.UTC) // a Monday
+
+	tests := []struct {
+		name     string
+		expr     string
+		from     time.Time
+		expected time.Time
+	}{
+		{
+			name:     "Every minute",
+			expr:     "* * * * *",
+			from:     base,
+			expected: time.Date(2024, time.January, 15, 10, 18, 0, 0, time.UTC),
+		},
+		{
+			name:     "Strictly after an exact match",
+			expr:     "* * * * *",
+			from:     time.Date(2024, time.January, 15, 10, 18, 0, 0, time.UTC),
+			expected: time.Date(2024, time.January, 15, 10, 19, 0, 0, time.UTC),
+		},
+		{
+			name:     "Every fifteen minutes",
+			expr:     "*/15 * * * *",
+			from:     base,
+			expected: time.Date(2024, time.January, 15, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "Hourly macro",
+			expr:     "@hourly",
+			from:     base,
+			expected: time.Date(2024, time.January, 15, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Nightly at 3am rolls over to the next day",
+			expr:     "0 3 * * *",
+			from:     base,
+			expected: time.Date(2024, time.January, 16, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Weekdays by name",
+			expr:     "30 9 * * sat,sun",
+			from:     base,
+			expected: time.Date(2024, time.January, 20, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "Sunday as 7",
+			expr:     "0 0 * * 7",
+			from:     base,
+			expected: time.Date(2024, time.January, 21, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Day of month or day of week",
+			expr:     "0 0 1 * 5",
+			from:     base,
+			expected: time.Date(2024, time.January, 19, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Month range with step",
+			expr:     "0 0 1 1-12/3 *",
+			from:     base,
+			expected: time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Leap day",
+			expr:     "0 12 29 2 *",
+			from:     time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2028, time.February, 29, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Never matches",
+			expr:     "0 0 30 2 *",
+			from:     base,
+			expected: time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Failed to parse %q: %v", tt.expr, err)
+			}
+			assert.True(t, tt.expected.Equal(s.Next(tt.from)), "got %v, want %v", s.Next(tt.from), tt.expected)
+		})
+	}
+}
+
+func TestSchedule_Next_Location(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("Failed to load location: %v", err)
+	}
+
+	s, err := Parse("0 3 * * *")
+	if err != nil {
+		t.Fatalf("Failed to parse expression: %v", err)
+	}
+
+	// 3am in New York is 8am UTC in winter
+	next := s.Next(time.Date(2024, time.January, 15, 12, 0, 0, 0, time.UTC).In(loc))
+	assert.True(t, time.Date(2024, time.January, 16, 8, 0, 0, 0, time.UTC).Equal(next))
+
+	// The day clocks spring forward from 2am to 3am
+	next = s.Next(time.Date(2024, time.March, 10, 0, 0, 0, 0, loc))
+	assert.Equal(t, 3, next.Hour())
+	assert.Equal(t, 10, next.Day())
+}
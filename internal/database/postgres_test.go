@@ -120,3 +120,16 @@ func TestConnect(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_DSN(t *testing.T) {
+	config := &Config{
+		Host:     "db",
+		Port:     "5433",
+		User:     "queuet",
+		Password: "secret",
+		DBName:   "tasks",
+		SSLMode:  "require",
+	}
+
+	assert.Equal(t, "host=db port=5433 user=queuet password=secret dbname=tasks sslmode=require", config.DSN())
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+)
+
+// CreateBatch creates a batch and its tasks in one transaction. If any task
+// cannot be created nothing is, and the response has a result for every
+// task like an atomic batch create.
+func (h *TaskHandler) CreateBatch(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Tasks) == 0 {
+		http.Error(w, "Batch must contain at least one task", http.StatusBadRequest)
+		return
+	}
+	if len(req.Tasks) > h.config.MaxBatchSize {
+		http.Error(w, fmt.Sprintf("Batch must contain at most %d tasks", h.config.MaxBatchSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(req.Name) > 255 {
+		http.Error(w, "Name must be at most 255 characters", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	if req.CallbackTask != nil {
+		if len(req.CallbackTask.Payload) > h.config.MaxPayloadBytes {
+			http.Error(w, "Callback task payload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if _, err := store.NewTaskFromRequest(*req.CallbackTask, now); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid callback task: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.CallbackURL != "" {
+		if u, err := url.Parse(req.CallbackURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, "Callback URL must be an http or https URL", http.StatusBadRequest)
+			return
+		}
+	}
+
+	plan := h.planBatch(req.Tasks, now)
+	if plan.response.Failed > 0 {
+		writeBatchResponse(w, http.StatusBadRequest, rolledBack(plan.response))
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "Failed to create batch", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	batchID, err := store.InsertBatch(ctx, tx, req, now)
+	if err != nil {
+		http.Error(w, "Failed to create batch", http.StatusInternalServerError)
+		return
+	}
+
+	plan.setBatchID(batchID)
+	response, err := h.insertBatch(ctx, tx, plan, now)
+	if err != nil {
+		http.Error(w, "Failed to create batch", http.StatusInternalServerError)
+		return
+	}
+	if response.Failed > 0 {
+		writeBatchResponse(w, http.StatusBadRequest, rolledBack(response))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create batch", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.CreateBatchResponse{ID: batchID, TaskBatchResponse: response})
+}
+
+// GetBatch returns a batch with the number of its tasks in each status
+func (h *TaskHandler) GetBatch(w http.ResponseWriter, r *http.Request) {
+	batchID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid batch ID", http.StatusBadRequest)
+		return
+	}
+
+	batch, err := store.GetBatch(r.Context(), h.db, batchID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Batch not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to get batch", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batch)
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/queuet/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskHandler_CreateBatch(t *testing.T) {
+	batchQuery := `INSERT INTO batches \(name, callback_task, callback_url, created_at, updated_at\) VALUES \(\$1, \$2, \$3, \$4, \$4\) RETURNING id`
+
+	tests := []struct {
+		name           string
+		payload        string
+		expectedStatus int
+		expectedBody   string
+		mockDB         func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:           "Batch with a callback",
+			payload:        `{"name": "Import", "tasks": [{"title": "First"}, {"title": "Second"}], "callback_task": {"title": "Report"}, "callback_url": "https://example.com/hooks/import"}`,
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"id":4,"succeeded":2,"failed":0,"results":[{"status":201,"id":10},{"status":201,"id":11}]}`,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(batchQuery).
+					WithArgs("Import", sql.NullString{String: `{"title":"Report","description":""}`, Valid: true}, sql.NullString{String: "https://example.com/hooks/import", Valid: true}, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+				mock.ExpectQuery(`SELECT nextval`).
+					WithArgs(2).
+					WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(10).AddRow(11))
+				mock.ExpectExec(`INSERT INTO tasks \(id, (.+), timeout_seconds\)`).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Task with dependencies joins the batch",
+			payload:        `{"tasks": [{"title": "Load", "depends_on": [1]}]}`,
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"id":4,"succeeded":1,"failed":0,"results":[{"status":201,"id":12}]}`,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(batchQuery).
+					WithArgs("", sql.NullString{}, sql.NullString{}, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+				mock.ExpectQuery(`SELECT id, status FROM tasks WHERE id = ANY\(\$1\)`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, "pending"))
+				mock.ExpectQuery(`INSERT INTO tasks \(title`).
+					WithArgs("Load", "", sql.NullString{}, "blocked", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{Int64: 4, Valid: true}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+				mock.ExpectExec(`INSERT INTO task_dependencies`).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Invalid task rejects the batch",
+			payload:        `{"tasks": [{"title": "First"}, {"title": ""}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"succeeded":0,"failed":2,"results":[{"status":424,"error":"Not created because another task in the batch failed"},{"status":400,"error":"Title is required"}]}`,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Missing dependency rolls back the batch",
+			payload:        `{"tasks": [{"title": "Load", "depends_on": [99]}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"succeeded":0,"failed":1,"results":[{"status":400,"error":"Dependency 99 not found"}]}`,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(batchQuery).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+				mock.ExpectQuery(`SELECT id, status FROM tasks WHERE id = ANY\(\$1\)`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "status"}))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Invalid callback task",
+			payload:        `{"tasks": [{"title": "First"}], "callback_task": {"title": "Report", "queue": "bad queue"}}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Invalid callback URL",
+			payload:        `{"tasks": [{"title": "First"}], "callback_url": "ftp://example.com"}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "No tasks",
+			payload:        `{"name": "Empty"}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Too many tasks",
+			payload:        `{"tasks": [{"title": "1"}, {"title": "2"}, {"title": "3"}, {"title": "4"}]}`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Database error",
+			payload:        `{"tasks": [{"title": "First"}]}`,
+			expectedStatus: http.StatusInternalServerError,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(batchQuery).
+					WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, mock := setupTestHandler(t)
+			tt.mockDB(mock)
+
+			req := httptest.NewRequest("POST", "/batches", bytes.NewBufferString(tt.payload))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			handler.CreateBatch(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, rr.Body.String())
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestTaskHandler_GetBatch(t *testing.T) {
+	batchColumns := []string{"id", "name", "callback_task", "callback_url", "callback_task_id", "finished_at", "created_at", "updated_at"}
+	selectQuery := `SELECT ` + regexp.QuoteMeta(store.BatchColumns) + ` FROM batches WHERE id = \$1`
+	countQuery := `SELECT status, COUNT\(\*\) FROM tasks WHERE batch_id = \$1 GROUP BY status`
+
+	tests := []struct {
+		name           string
+		batchID        string
+		expectedStatus int
+		expectedBody   string
+		mockDB         func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:           "Batch in progress",
+			batchID:        "4",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"id":4,"name":"Import","callback_task":{"title":"Report","description":""},"total":4,"counts":{"completed":2,"failed":1,"in_progress":1},"progress":75,"created_at":"2030-01-02T03:00:00Z","updated_at":"2030-01-02T03:00:00Z"}`,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				created := time.Date(2030, 1, 2, 3, 0, 0, 0, time.UTC)
+				mock.ExpectQuery(selectQuery).
+					WithArgs(int64(4)).
+					WillReturnRows(sqlmock.NewRows(batchColumns).AddRow(4, "Import", []byte(`{"title":"Report"}`), nil, nil, nil, created, created))
+				mock.ExpectQuery(countQuery).
+					WithArgs(int64(4)).
+					WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).AddRow("completed", 2).AddRow("failed", 1).AddRow("in_progress", 1))
+			},
+		},
+		{
+			name:           "Batch not found",
+			batchID:        "5",
+			expectedStatus: http.StatusNotFound,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectQuery).
+					WithArgs(int64(5)).
+					WillReturnRows(sqlmock.NewRows(batchColumns))
+			},
+		},
+		{
+			name:           "Invalid batch ID",
+			batchID:        "latest",
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, mock := setupTestHandler(t)
+			tt.mockDB(mock)
+
+			req := httptest.NewRequest("GET", "/batches/"+tt.batchID, nil)
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", tt.batchID)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+			rr := httptest.NewRecorder()
+
+			handler.GetBatch(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, rr.Body.String())
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
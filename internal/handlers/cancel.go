@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+)
+
+// CancelTask stops a task without deleting it. Pending and blocked tasks are
+// cancelled straight away, along with the tasks that depend on them. A task
+// in progress is flagged with cancel_requested_at and the response is 202
+// Accepted: its worker sees the flag on its next heartbeat, and the task is
+// cancelled when the worker reports failure or its lease expires.
+func (h *TaskHandler) CancelTask(w http.ResponseWriter, r *http.Request) {
+	taskID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "Failed to cancel task", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	task, err := store.LockTask(ctx, tx, taskID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to get task", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	status := http.StatusOK
+	var cancelled []int64
+	switch task.Status {
+	case models.TaskStatusPending, models.TaskStatusBlocked:
+		query := `
+			UPDATE tasks
+			SET status = $1,
+				updated_at = $2
+			WHERE id = $3
+			RETURNING ` + store.TaskColumns
+
+		task, err = store.ScanTask(tx.QueryRowContext(ctx, query, models.TaskStatusCancelled, now, taskID))
+		if err != nil {
+			http.Error(w, "Failed to cancel task", http.StatusInternalServerError)
+			return
+		}
+
+		cancelled, err = store.ReleaseDependents(ctx, tx, task, now)
+		if err != nil {
+			http.Error(w, "Failed to cancel task", http.StatusInternalServerError)
+			return
+		}
+	case models.TaskStatusInProgress:
+		status = http.StatusAccepted
+		if task.CancelRequestedAt != nil {
+			break
+		}
+
+		query := `
+			UPDATE tasks
+			SET cancel_requested_at = $1,
+				updated_at = $1
+			WHERE id = $2
+			RETURNING ` + store.TaskColumns
+
+		task, err = store.ScanTask(tx.QueryRowContext(ctx, query, now, taskID))
+		if err != nil {
+			http.Error(w, "Failed to cancel task", http.StatusInternalServerError)
+			return
+		}
+	case models.TaskStatusCancelled:
+		// Cancelling again is harmless
+	default:
+		http.Error(w, "Task has already finished", http.StatusConflict)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to cancel task", http.StatusInternalServerError)
+		return
+	}
+
+	// Update cache
+	cacheKey := fmt.Sprintf("task:%d", taskID)
+	taskJSON, _ := json.Marshal(task)
+	h.cache.Set(ctx, cacheKey, taskJSON, time.Hour)
+	h.uncacheTasks(ctx, cancelled)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(task)
+}
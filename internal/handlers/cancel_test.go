@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+	"github.com/queuet/internal/store"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskHandler_CancelTask(t *testing.T) {
+	lockQuery := `SELECT ` + regexp.QuoteMeta(store.TaskColumns) + ` FROM tasks WHERE id = \$1 FOR UPDATE`
+	cancelQuery := `UPDATE tasks SET status = \$1, updated_at = \$2 WHERE id = \$3 RETURNING ` + regexp.QuoteMeta(store.TaskColumns)
+	requestQuery := `UPDATE tasks SET cancel_requested_at = \$1, updated_at = \$1 WHERE id = \$2 RETURNING ` + regexp.QuoteMeta(store.TaskColumns)
+
+	cancelRequestedRow := func(rows *sqlmock.Rows) *sqlmock.Rows {
+		return rows.AddRow(1, "Test Task", "", "in_progress", "default", 0, nil, nil, "worker-1", time.Now().Add(time.Minute), 0, 3, nil, nil, nil, time.Now(), nil, nil, time.Now(), nil, nil, time.Now(), time.Now())
+	}
+
+	tests := []struct {
+		name            string
+		taskID          string
+		expectedStatus  int
+		expectedDeleted []string
+		mockDB          func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:            "Pending task is cancelled with its dependents",
+			taskID:          "1",
+			expectedStatus:  http.StatusOK,
+			expectedDeleted: []string{"task:2"},
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Test Task", "", "pending"))
+				mock.ExpectQuery(cancelQuery).
+					WithArgs("cancelled", sqlmock.AnyArg(), 1).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Test Task", "", "cancelled"))
+				expectDependents(mock, 1, 2)
+				mock.ExpectQuery(`WITH RECURSIVE downstream AS`).
+					WithArgs(pq.Array([]int64{2}), "cancelled", sqlmock.AnyArg(), "blocked").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Running task is flagged",
+			taskID:         "1",
+			expectedStatus: http.StatusAccepted,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnRows(addLeasedTaskRow(sqlmock.NewRows(taskRowColumns), 1, "worker-1"))
+				mock.ExpectQuery(requestQuery).
+					WithArgs(sqlmock.AnyArg(), 1).
+					WillReturnRows(cancelRequestedRow(sqlmock.NewRows(taskRowColumns)))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Running task already flagged",
+			taskID:         "1",
+			expectedStatus: http.StatusAccepted,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnRows(cancelRequestedRow(sqlmock.NewRows(taskRowColumns)))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Cancelled task",
+			taskID:         "1",
+			expectedStatus: http.StatusOK,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Test Task", "", "cancelled"))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Finished task",
+			taskID:         "1",
+			expectedStatus: http.StatusConflict,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Test Task", "", "completed"))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Task not found",
+			taskID:         "999",
+			expectedStatus: http.StatusNotFound,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(999).
+					WillReturnRows(sqlmock.NewRows(taskRowColumns))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Invalid task ID",
+			taskID:         "invalid",
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Database error",
+			taskID:         "1",
+			expectedStatus: http.StatusInternalServerError,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, mock := setupTestHandler(t)
+			tt.mockDB(mock)
+
+			var deleted []string
+			handler.cache.(*redisMock).delFunc = func(ctx context.Context, keys ...string) *redis.IntCmd {
+				deleted = append(deleted, keys...)
+				return redis.NewIntCmd(ctx)
+			}
+
+			req := httptest.NewRequest("POST", "/tasks/"+tt.taskID+"/cancel", nil)
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", tt.taskID)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+			rr := httptest.NewRecorder()
+
+			handler.CancelTask(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedDeleted, deleted)
+			if tt.expectedStatus == http.StatusAccepted {
+				assert.Contains(t, rr.Body.String(), `"cancel_requested_at"`)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
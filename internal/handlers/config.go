@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/queuet/internal/store"
+)
+
+type Config struct {
+	DefaultLease time.Duration
+	MaxLease     time.Duration
+	Backoff      store.BackoffPolicy
+	// ClaimRetryAfter is suggested to workers when every queue they could
+	// claim from is at its max_in_flight limit
+	ClaimRetryAfter time.Duration
+	// MaxPayloadBytes caps the size of a task's JSON payload
+	MaxPayloadBytes int
+	// IdempotencyRetention is how long an Idempotency-Key is remembered
+	IdempotencyRetention time.Duration
+	// MaxBatchSize caps the number of tasks created or completed by one batch
+	// request
+	MaxBatchSize int
+	// MaxClaimWait caps how long a claim waits for a task, and should stay
+	// below the server's request timeout
+	MaxClaimWait time.Duration
+	// ClaimRecheckInterval is how often a waiting claim looks for tasks
+	// that became due without a notification
+	ClaimRecheckInterval time.Duration
+	// MaxClaimBatch caps the number of tasks leased by one claim
+	MaxClaimBatch int
+}
+
+// NewConfig creates a new handler configuration from environment variables
+func NewConfig() *Config {
+	return &Config{
+		DefaultLease:         getEnvSeconds("TASK_LEASE_SECONDS", 30),
+		MaxLease:             getEnvSeconds("TASK_MAX_LEASE_SECONDS", 3600),
+		Backoff:              store.NewBackoffPolicy(),
+		ClaimRetryAfter:      getEnvSeconds("TASK_CLAIM_RETRY_AFTER_SECONDS", 5),
+		MaxPayloadBytes:      getEnvInt("TASK_MAX_PAYLOAD_BYTES", 65536),
+		IdempotencyRetention: time.Duration(getEnvInt("IDEMPOTENCY_KEY_RETENTION_HOURS", 24)) * time.Hour,
+		MaxBatchSize:         getEnvInt("TASK_BATCH_MAX_SIZE", 10000),
+		MaxClaimWait:         getEnvSeconds("TASK_CLAIM_MAX_WAIT_SECONDS", 30),
+		ClaimRecheckInterval: getEnvSeconds("TASK_CLAIM_RECHECK_SECONDS", 5),
+		MaxClaimBatch:        getEnvInt("TASK_CLAIM_MAX_BATCH", 100),
+	}
+}
+
+// getEnvInt retrieves a positive integer environment variable with a
+// fallback value
+func getEnvInt(key string, fallback int) int {
+	val, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil || val <= 0 {
+		return fallback
+	}
+	return val
+}
+
+// getEnvSeconds retrieves an environment variable as a number of seconds
+func getEnvSeconds(key string, fallback int) time.Duration {
+	return time.Duration(getEnvInt(key, fallback)) * time.Second
+}
+
+// getEnv retrieves an environment variable with a fallback value
+func getEnv(key, fallback string) string {
+	val, exists := os.LookupEnv(key)
+	if !exists || val == "" {
+		return fallback
+	}
+	return val
+}
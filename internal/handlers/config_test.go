@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/queuet/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewConfig(t *testing.T) {
+	// Save original env vars
+	origLease := os.Getenv("TASK_LEASE_SECONDS")
+	origMaxLease := os.Getenv("TASK_MAX_LEASE_SECONDS")
+	origRetryAfter := os.Getenv("TASK_CLAIM_RETRY_AFTER_SECONDS")
+	origMaxPayload := os.Getenv("TASK_MAX_PAYLOAD_BYTES")
+	origRetention := os.Getenv("IDEMPOTENCY_KEY_RETENTION_HOURS")
+	origBatchSize := os.Getenv("TASK_BATCH_MAX_SIZE")
+	origMaxWait := os.Getenv("TASK_CLAIM_MAX_WAIT_SECONDS")
+	origRecheck := os.Getenv("TASK_CLAIM_RECHECK_SECONDS")
+	origClaimBatch := os.Getenv("TASK_CLAIM_MAX_BATCH")
+
+	// Cleanup
+	defer func() {
+		os.Setenv("TASK_LEASE_SECONDS", origLease)
+		os.Setenv("TASK_MAX_LEASE_SECONDS", origMaxLease)
+		os.Setenv("TASK_CLAIM_RETRY_AFTER_SECONDS", origRetryAfter)
+		os.Setenv("TASK_MAX_PAYLOAD_BYTES", origMaxPayload)
+		os.Setenv("IDEMPOTENCY_KEY_RETENTION_HOURS", origRetention)
+		os.Setenv("TASK_BATCH_MAX_SIZE", origBatchSize)
+		os.Setenv("TASK_CLAIM_MAX_WAIT_SECONDS", origMaxWait)
+		os.Setenv("TASK_CLAIM_RECHECK_SECONDS", origRecheck)
+		os.Setenv("TASK_CLAIM_MAX_BATCH", origClaimBatch)
+	}()
+
+	tests := []struct {
+		name     string
+		envVars  map[string]string
+		expected *Config
+	}{
+		{
+			name: "Default values",
+			envVars: map[string]string{
+				"TASK_LEASE_SECONDS":              "",
+				"TASK_MAX_LEASE_SECONDS":          "",
+				"TASK_CLAIM_RETRY_AFTER_SECONDS":  "",
+				"TASK_MAX_PAYLOAD_BYTES":          "",
+				"IDEMPOTENCY_KEY_RETENTION_HOURS": "",
+				"TASK_BATCH_MAX_SIZE":             "",
+				"TASK_CLAIM_MAX_WAIT_SECONDS":     "",
+				"TASK_CLAIM_RECHECK_SECONDS":      "",
+				"TASK_CLAIM_MAX_BATCH":            "",
+			},
+			expected: &Config{
+				DefaultLease:         30 * time.Second,
+				MaxLease:             time.Hour,
+				Backoff:              store.NewBackoffPolicy(),
+				ClaimRetryAfter:      5 * time.Second,
+				MaxPayloadBytes:      65536,
+				IdempotencyRetention: 24 * time.Hour,
+				MaxBatchSize:         10000,
+				MaxClaimWait:         30 * time.Second,
+				ClaimRecheckInterval: 5 * time.Second,
+				MaxClaimBatch:        100,
+			},
+		},
+		{
+			name: "Custom values",
+			envVars: map[string]string{
+				"TASK_LEASE_SECONDS":              "120",
+				"TASK_MAX_LEASE_SECONDS":          "600",
+				"TASK_CLAIM_RETRY_AFTER_SECONDS":  "2",
+				"TASK_MAX_PAYLOAD_BYTES":          "1024",
+				"IDEMPOTENCY_KEY_RETENTION_HOURS": "1",
+				"TASK_BATCH_MAX_SIZE":             "500",
+				"TASK_CLAIM_MAX_WAIT_SECONDS":     "20",
+				"TASK_CLAIM_RECHECK_SECONDS":      "1",
+				"TASK_CLAIM_MAX_BATCH":            "10",
+			},
+			expected: &Config{
+				DefaultLease:         2 * time.Minute,
+				MaxLease:             10 * time.Minute,
+				Backoff:              store.NewBackoffPolicy(),
+				ClaimRetryAfter:      2 * time.Second,
+				MaxPayloadBytes:      1024,
+				IdempotencyRetention: time.Hour,
+				MaxBatchSize:         500,
+				MaxClaimWait:         20 * time.Second,
+				ClaimRecheckInterval: time.Second,
+				MaxClaimBatch:        10,
+			},
+		},
+		{
+			name: "Invalid values",
+			envVars: map[string]string{
+				"TASK_LEASE_SECONDS":              "soon",
+				"TASK_MAX_LEASE_SECONDS":          "-1",
+				"TASK_CLAIM_RETRY_AFTER_SECONDS":  "0",
+				"TASK_MAX_PAYLOAD_BYTES":          "big",
+				"IDEMPOTENCY_KEY_RETENTION_HOURS": "never",
+				"TASK_BATCH_MAX_SIZE":             "-5",
+				"TASK_CLAIM_MAX_WAIT_SECONDS":     "forever",
+				"TASK_CLAIM_RECHECK_SECONDS":      "0",
+				"TASK_CLAIM_MAX_BATCH":            "many",
+			},
+			expected: &Config{
+				DefaultLease:         30 * time.Second,
+				MaxLease:             time.Hour,
+				Backoff:              store.NewBackoffPolicy(),
+				ClaimRetryAfter:      5 * time.Second,
+				MaxPayloadBytes:      65536,
+				IdempotencyRetention: 24 * time.Hour,
+				MaxBatchSize:         10000,
+				MaxClaimWait:         30 * time.Second,
+				ClaimRecheckInterval: 5 * time.Second,
+				MaxClaimBatch:        100,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.envVars {
+				os.Setenv(k, v)
+			}
+
+			config := NewConfig()
+			assert.Equal(t, tt.expected, config)
+		})
+	}
+}
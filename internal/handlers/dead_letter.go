@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+)
+
+// ListDeadLetter lists tasks that exhausted their retries, most recently
+// failed first. Each task keeps its attempt count and last error.
+func (h *TaskHandler) ListDeadLetter(w http.ResponseWriter, r *http.Request) {
+	pageSize, offset := pagination(r)
+
+	query := `
+		SELECT ` + store.TaskColumns + `
+		FROM tasks
+		WHERE status = $1
+		ORDER BY updated_at DESC
+		LIMIT $2 OFFSET $3`
+
+	h.writeTasks(w, query, models.TaskStatusDead, pageSize, offset)
+}
+
+// ReplayDeadLetter returns dead tasks to the queue with a fresh set of
+// attempts. Their attempt history and last error are preserved. A task whose
+// unique key is held by a live task is skipped, and of several selected tasks
+// sharing a key only the newest is replayed.
+func (h *TaskHandler) ReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	var req models.ReplayDeadLetterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if !req.All && len(req.IDs) == 0 {
+		http.Error(w, "Task IDs or all are required", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		WITH selected AS (
+			SELECT id, unique_key
+			FROM tasks
+			WHERE status = $3 AND ($4 OR id = ANY($5))
+			FOR UPDATE
+		), replayable AS (
+			SELECT DISTINCT ON (unique_key, CASE WHEN unique_key IS NULL THEN id END) id
+			FROM selected
+			WHERE unique_key IS NULL OR NOT EXISTS (
+				SELECT 1
+				FROM tasks holder
+				WHERE holder.unique_key = selected.unique_key AND holder.status IN ($6, $7, $8)
+			)
+			ORDER BY unique_key, CASE WHEN unique_key IS NULL THEN id END, id DESC
+		), replayed AS (
+			UPDATE tasks
+			SET status = $1,
+				attempts = 0,
+				run_at = $2,
+				updated_at = $2
+			WHERE id IN (SELECT id FROM replayable)
+			RETURNING id
+		)
+		SELECT selected.id, replayed.id IS NOT NULL
+		FROM selected
+		LEFT JOIN replayed ON replayed.id = selected.id
+		ORDER BY selected.id`
+
+	ctx := r.Context()
+	rows, err := h.db.QueryContext(
+		ctx,
+		query,
+		models.TaskStatusPending,
+		time.Now(),
+		models.TaskStatusDead,
+		req.All,
+		pq.Array(req.IDs),
+		models.TaskStatusPending,
+		models.TaskStatusInProgress,
+		models.TaskStatusBlocked,
+	)
+	if err != nil {
+		http.Error(w, "Failed to replay tasks", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	replayed := []int64{}
+	skipped := []int64{}
+	for rows.Next() {
+		var id int64
+		var ok bool
+		if err := rows.Scan(&id, &ok); err != nil {
+			http.Error(w, "Failed to scan task", http.StatusInternalServerError)
+			return
+		}
+		if ok {
+			replayed = append(replayed, id)
+		} else {
+			skipped = append(skipped, id)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		http.Error(w, "Error iterating tasks", http.StatusInternalServerError)
+		return
+	}
+
+	// Delete from cache
+	if len(replayed) > 0 {
+		keys := make([]string, len(replayed))
+		for i, id := range replayed {
+			keys[i] = fmt.Sprintf("task:%d", id)
+		}
+		h.cache.Del(ctx, keys...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"replayed": len(replayed),
+		"ids":      replayed,
+		"skipped":  skipped,
+	})
+}
+
+// ListAttempts returns the recorded failures of a task, oldest first
+func (h *TaskHandler) ListAttempts(w http.ResponseWriter, r *http.Request) {
+	taskID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		SELECT id, task_id, attempt, worker_id, error, created_at
+		FROM task_attempts
+		WHERE task_id = $1
+		ORDER BY created_at, id`
+
+	rows, err := h.db.QueryContext(r.Context(), query, taskID)
+	if err != nil {
+		http.Error(w, "Failed to list attempts", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	attempts := []models.TaskAttempt{}
+	for rows.Next() {
+		var attempt models.TaskAttempt
+		err := rows.Scan(
+			&attempt.ID,
+			&attempt.TaskID,
+			&attempt.Attempt,
+			&attempt.WorkerID,
+			&attempt.Error,
+			&attempt.CreatedAt,
+		)
+		if err != nil {
+			http.Error(w, "Failed to scan attempt", http.StatusInternalServerError)
+			return
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	if err = rows.Err(); err != nil {
+		http.Error(w, "Error iterating attempts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attempts)
+}
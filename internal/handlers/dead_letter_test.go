@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskHandler_ListDeadLetter(t *testing.T) {
+	handler, mock := setupTestHandler(t)
+
+	mock.ExpectQuery(`SELECT `+regexp.QuoteMeta(store.TaskColumns)+` FROM tasks WHERE status = \$1 ORDER BY updated_at DESC LIMIT \$2 OFFSET \$3`).
+		WithArgs("dead", 20, 20).
+		WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Poison Task", "", "dead"))
+
+	req := httptest.NewRequest("GET", "/api/v1/dead-letter?page=2&size=20", nil)
+	w := httptest.NewRecorder()
+
+	handler.ListDeadLetter(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response []models.Task
+	err := json.NewDecoder(w.Body).Decode(&response)
+	assert.NoError(t, err)
+	if assert.Len(t, response, 1) {
+		assert.Equal(t, "dead", response[0].Status)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTaskHandler_ReplayDeadLetter(t *testing.T) {
+	handler, mock := setupTestHandler(t)
+
+	var deleted []string
+	handler.cache.(*redisMock).delFunc = func(ctx context.Context, keys ...string) *redis.IntCmd {
+		deleted = append(deleted, keys...)
+		return redis.NewIntCmd(ctx)
+	}
+
+	replayQuery := `WITH selected AS \( SELECT id, unique_key FROM tasks WHERE status = \$3 AND \(\$4 OR id = ANY\(\$5\)\) FOR UPDATE \), ` +
+		`replayable AS \( SELECT DISTINCT ON \(unique_key, CASE WHEN unique_key IS NULL THEN id END\) id FROM selected WHERE unique_key IS NULL OR NOT EXISTS \( SELECT 1 FROM tasks holder WHERE holder.unique_key = selected.unique_key AND holder.status IN \(\$6, \$7, \$8\) \) ORDER BY unique_key, CASE WHEN unique_key IS NULL THEN id END, id DESC \), ` +
+		`replayed AS \( UPDATE tasks SET status = \$1, attempts = 0, run_at = \$2, updated_at = \$2 WHERE id IN \(SELECT id FROM replayable\) RETURNING id \) ` +
+		`SELECT selected.id, replayed.id IS NOT NULL FROM selected LEFT JOIN replayed ON replayed.id = selected.id ORDER BY selected.id`
+	replayRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "replayed"})
+	}
+
+	tests := []struct {
+		name           string
+		payload        string
+		expectedStatus int
+		expectedKeys   []string
+		expectedBody   string
+		mockDB         func()
+	}{
+		{
+			name:           "Replay selected tasks",
+			payload:        `{"ids": [4, 9]}`,
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"task:4", "task:9"},
+			expectedBody:   `{"replayed": 2, "ids": [4, 9], "skipped": []}`,
+			mockDB: func() {
+				mock.ExpectQuery(replayQuery).
+					WithArgs("pending", sqlmock.AnyArg(), "dead", false, pq.Array([]int64{4, 9}), "pending", "in_progress", "blocked").
+					WillReturnRows(replayRows().AddRow(4, true).AddRow(9, true))
+			},
+		},
+		{
+			name:           "Task whose unique key is taken is skipped",
+			payload:        `{"ids": [4, 9]}`,
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"task:9"},
+			expectedBody:   `{"replayed": 1, "ids": [9], "skipped": [4]}`,
+			mockDB: func() {
+				mock.ExpectQuery(replayQuery).
+					WithArgs("pending", sqlmock.AnyArg(), "dead", false, pq.Array([]int64{4, 9}), "pending", "in_progress", "blocked").
+					WillReturnRows(replayRows().AddRow(4, false).AddRow(9, true))
+			},
+		},
+		{
+			name:           "Replay all tasks",
+			payload:        `{"all": true}`,
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"task:12"},
+			mockDB: func() {
+				mock.ExpectQuery(replayQuery).
+					WithArgs("pending", sqlmock.AnyArg(), "dead", true, sqlmock.AnyArg(), "pending", "in_progress", "blocked").
+					WillReturnRows(replayRows().AddRow(12, true))
+			},
+		},
+		{
+			name:           "Nothing selected",
+			payload:        `{}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Database error",
+			payload:        `{"all": true}`,
+			expectedStatus: http.StatusInternalServerError,
+			mockDB: func() {
+				mock.ExpectQuery(replayQuery).
+					WillReturnError(errors.New("connection reset"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleted = nil
+			tt.mockDB()
+
+			req := httptest.NewRequest("POST", "/api/v1/dead-letter/replay", strings.NewReader(tt.payload))
+			w := httptest.NewRecorder()
+
+			handler.ReplayDeadLetter(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedKeys, deleted)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestTaskHandler_ListAttempts(t *testing.T) {
+	handler, mock := setupTestHandler(t)
+
+	mock.ExpectQuery(`SELECT id, task_id, attempt, worker_id, error, created_at FROM task_attempts WHERE task_id = \$1 ORDER BY created_at, id`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "task_id", "attempt", "worker_id", "error", "created_at"}).
+			AddRow(1, 3, 1, "worker-1", "timeout", time.Now()).
+			AddRow(2, 3, 2, nil, "lease expired", time.Now()))
+
+	req := httptest.NewRequest("GET", "/api/v1/tasks/3/attempts", nil)
+	w := httptest.NewRecorder()
+
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("id", "3")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+	handler.ListAttempts(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response []models.TaskAttempt
+	err := json.NewDecoder(w.Body).Decode(&response)
+	assert.NoError(t, err)
+	if assert.Len(t, response, 2) {
+		assert.Equal(t, "timeout", response[0].Error)
+		assert.Nil(t, response[1].WorkerID)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
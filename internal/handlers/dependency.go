@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+)
+
+// GetTaskGraph returns the workflow a task belongs to: the tasks it depends
+// on, the tasks that depend on it, transitively, and the dependencies
+// between them
+func (h *TaskHandler) GetTaskGraph(w http.ResponseWriter, r *http.Request) {
+	taskID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		WITH RECURSIVE upstream AS (
+			SELECT $1::bigint AS id
+			UNION
+			SELECT d.depends_on_id
+			FROM task_dependencies d
+			JOIN upstream ON d.task_id = upstream.id
+		), downstream AS (
+			SELECT $1::bigint AS id
+			UNION
+			SELECT d.task_id
+			FROM task_dependencies d
+			JOIN downstream ON d.depends_on_id = downstream.id
+		)
+		SELECT ` + store.TaskColumns + `
+		FROM tasks
+		WHERE id IN (SELECT id FROM upstream UNION SELECT id FROM downstream)
+		ORDER BY id`
+
+	ctx := r.Context()
+	rows, err := h.db.QueryContext(ctx, query, taskID)
+	if err != nil {
+		http.Error(w, "Failed to get task graph", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	graph := models.TaskGraph{
+		Tasks:        []models.Task{},
+		Dependencies: []models.TaskDependency{},
+	}
+	var ids []int64
+	for rows.Next() {
+		task, err := store.ScanTask(rows)
+		if err != nil {
+			http.Error(w, "Failed to scan task", http.StatusInternalServerError)
+			return
+		}
+		graph.Tasks = append(graph.Tasks, task)
+		ids = append(ids, task.ID)
+	}
+
+	if err = rows.Err(); err != nil {
+		http.Error(w, "Error iterating tasks", http.StatusInternalServerError)
+		return
+	}
+	if len(graph.Tasks) == 0 {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+
+	query = `
+		SELECT task_id, depends_on_id
+		FROM task_dependencies
+		WHERE task_id = ANY($1) AND depends_on_id = ANY($1)
+		ORDER BY task_id, depends_on_id`
+
+	depRows, err := h.db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		http.Error(w, "Failed to get task graph", http.StatusInternalServerError)
+		return
+	}
+	defer depRows.Close()
+
+	for depRows.Next() {
+		var dep models.TaskDependency
+		if err := depRows.Scan(&dep.TaskID, &dep.DependsOnID); err != nil {
+			http.Error(w, "Failed to scan dependency", http.StatusInternalServerError)
+			return
+		}
+		graph.Dependencies = append(graph.Dependencies, dep)
+	}
+
+	if err = depRows.Err(); err != nil {
+		http.Error(w, "Error iterating dependencies", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(graph)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskHandler_GetTaskGraph(t *testing.T) {
+	graphQuery := `WITH RECURSIVE upstream AS (.+) SELECT ` + regexp.QuoteMeta(store.TaskColumns) + ` FROM tasks WHERE id IN \(SELECT id FROM upstream UNION SELECT id FROM downstream\) ORDER BY id`
+	edgesQuery := `SELECT task_id, depends_on_id FROM task_dependencies WHERE task_id = ANY\(\$1\) AND depends_on_id = ANY\(\$1\) ORDER BY task_id, depends_on_id`
+
+	tests := []struct {
+		name                 string
+		taskID               string
+		expectedStatus       int
+		expectedTasks        int
+		expectedDependencies []models.TaskDependency
+		mockDB               func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:           "Workflow around a task",
+			taskID:         "2",
+			expectedStatus: http.StatusOK,
+			expectedTasks:  3,
+			expectedDependencies: []models.TaskDependency{
+				{TaskID: 2, DependsOnID: 1},
+				{TaskID: 3, DependsOnID: 2},
+			},
+			mockDB: func(mock sqlmock.Sqlmock) {
+				rows := addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Extract", "", "completed")
+				rows = addTaskRow(rows, 2, "Transform", "", "pending")
+				rows = addTaskRow(rows, 3, "Load", "", "blocked")
+				mock.ExpectQuery(graphQuery).
+					WithArgs(2).
+					WillReturnRows(rows)
+				mock.ExpectQuery(edgesQuery).
+					WithArgs(pq.Array([]int64{1, 2, 3})).
+					WillReturnRows(sqlmock.NewRows([]string{"task_id", "depends_on_id"}).AddRow(2, 1).AddRow(3, 2))
+			},
+		},
+		{
+			name:                 "Task without dependencies",
+			taskID:               "4",
+			expectedStatus:       http.StatusOK,
+			expectedTasks:        1,
+			expectedDependencies: []models.TaskDependency{},
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(graphQuery).
+					WithArgs(4).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 4, "Standalone", "", "pending"))
+				mock.ExpectQuery(edgesQuery).
+					WithArgs(pq.Array([]int64{4})).
+					WillReturnRows(sqlmock.NewRows([]string{"task_id", "depends_on_id"}))
+			},
+		},
+		{
+			name:           "Task not found",
+			taskID:         "999",
+			expectedStatus: http.StatusNotFound,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(graphQuery).
+					WithArgs(999).
+					WillReturnRows(sqlmock.NewRows(taskRowColumns))
+			},
+		},
+		{
+			name:           "Invalid task ID",
+			taskID:         "invalid",
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, mock := setupTestHandler(t)
+			tt.mockDB(mock)
+
+			req := httptest.NewRequest("GET", "/api/v1/tasks/"+tt.taskID+"/graph", nil)
+			w := httptest.NewRecorder()
+
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("id", tt.taskID)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+			handler.GetTaskGraph(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusOK {
+				var graph models.TaskGraph
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&graph))
+				assert.Len(t, graph.Tasks, tt.expectedTasks)
+				assert.Equal(t, tt.expectedDependencies, graph.Dependencies)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+)
+
+// maxIdempotencyKeyLength is the longest Idempotency-Key header accepted
+const maxIdempotencyKeyLength = 255
+
+// idempotencyRecord is the outcome of the first request made with an
+// idempotency key
+type idempotencyRecord struct {
+	TaskID      int64  `json:"task_id"`
+	StatusCode  int    `json:"status_code"`
+	RequestHash string `json:"request_hash"`
+}
+
+// createTaskIdempotently creates a task once per idempotency key. Repeats of
+// the request within the retention window get the original response, with
+// the Idempotent-Replayed header set, instead of creating another task.
+// Reusing a key for a different request is rejected with 422.
+func (h *TaskHandler) createTaskIdempotently(w http.ResponseWriter, r *http.Request, key string, req models.CreateTaskRequest, task store.NewTask, now time.Time) {
+	if len(key) > maxIdempotencyKeyLength {
+		http.Error(w, "Idempotency key must be at most 255 characters", http.StatusBadRequest)
+		return
+	}
+
+	requestHash := hashRequest(req)
+	ctx := r.Context()
+	cacheKey := "idempotency:" + key
+
+	// Try the cache first; repeats usually arrive shortly after the original
+	if cached, err := h.cache.Get(ctx, cacheKey).Bytes(); err == nil {
+		var record idempotencyRecord
+		if json.Unmarshal(cached, &record) == nil {
+			replayIdempotent(w, record, requestHash)
+			return
+		}
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "Failed to create task", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Claiming the key blocks while a concurrent request holding it is
+	// still in flight, so only one of them creates the task
+	claimed, err := claimIdempotencyKey(ctx, tx, key, requestHash, now, now.Add(-h.config.IdempotencyRetention))
+	if err != nil {
+		http.Error(w, "Failed to create task", http.StatusInternalServerError)
+		return
+	}
+	if !claimed {
+		record, err := loadIdempotencyKey(ctx, tx, key)
+		if err != nil {
+			http.Error(w, "Failed to create task", http.StatusInternalServerError)
+			return
+		}
+		replayIdempotent(w, record, requestHash)
+		return
+	}
+
+	// A conflicting unique key rolls back the claim too, so the request can
+	// be retried with the same key once the key is free
+	taskID, status, err := h.insertTask(ctx, tx, task, now)
+	if err != nil {
+		insertTaskError(w, err)
+		return
+	}
+
+	record := idempotencyRecord{TaskID: taskID, StatusCode: status, RequestHash: requestHash}
+	query := `
+		UPDATE idempotency_keys
+		SET task_id = $1, status_code = $2
+		WHERE key = $3`
+
+	if _, err := tx.ExecContext(ctx, query, record.TaskID, record.StatusCode, key); err != nil {
+		http.Error(w, "Failed to create task", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create task", http.StatusInternalServerError)
+		return
+	}
+
+	recordJSON, _ := json.Marshal(record)
+	h.cache.Set(ctx, cacheKey, recordJSON, h.config.IdempotencyRetention)
+
+	writeTaskID(w, record.StatusCode, record.TaskID)
+}
+
+// claimIdempotencyKey stores a new idempotency key, or takes over one that
+// was first used before cutoff. It returns false when the key is in use.
+func claimIdempotencyKey(ctx context.Context, tx *sql.Tx, key, requestHash string, now, cutoff time.Time) (bool, error) {
+	query := `
+		INSERT INTO idempotency_keys (key, request_hash, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash,
+			task_id = NULL,
+			status_code = NULL,
+			created_at = EXCLUDED.created_at
+		WHERE idempotency_keys.created_at < $4
+		RETURNING key`
+
+	var claimed string
+	err := tx.QueryRowContext(ctx, query, key, requestHash, now, cutoff).Scan(&claimed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// loadIdempotencyKey reads the outcome recorded for an idempotency key
+func loadIdempotencyKey(ctx context.Context, tx *sql.Tx, key string) (idempotencyRecord, error) {
+	query := `
+		SELECT task_id, status_code, request_hash
+		FROM idempotency_keys
+		WHERE key = $1`
+
+	var record idempotencyRecord
+	err := tx.QueryRowContext(ctx, query, key).Scan(&record.TaskID, &record.StatusCode, &record.RequestHash)
+	return record, err
+}
+
+// replayIdempotent repeats the response recorded for an idempotency key
+// when the request matches the one that first used it
+func replayIdempotent(w http.ResponseWriter, record idempotencyRecord, requestHash string) {
+	if record.RequestHash != requestHash {
+		http.Error(w, "Idempotency key was used for a different request", http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Idempotent-Replayed", "true")
+	writeTaskID(w, record.StatusCode, record.TaskID)
+}
+
+// hashRequest fingerprints a create request, so a reused idempotency key
+// can be told apart from a retry
+func hashRequest(req models.CreateTaskRequest) string {
+	body, _ := json.Marshal(req)
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeTaskID responds with the ID of a created task
+func writeTaskID(w http.ResponseWriter, status int, taskID int64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]int64{"id": taskID})
+}
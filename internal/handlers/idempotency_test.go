@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/queuet/internal/models"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskHandler_CreateTaskIdempotently(t *testing.T) {
+	claimQuery := `INSERT INTO idempotency_keys \(key, request_hash, created_at\) VALUES \(\$1, \$2, \$3\) ON CONFLICT \(key\) DO UPDATE SET request_hash = EXCLUDED.request_hash, task_id = NULL, status_code = NULL, created_at = EXCLUDED.created_at WHERE idempotency_keys.created_at < \$4 RETURNING key`
+	loadQuery := `SELECT task_id, status_code, request_hash FROM idempotency_keys WHERE key = \$1`
+	recordQuery := `UPDATE idempotency_keys SET task_id = \$1, status_code = \$2 WHERE key = \$3`
+
+	payload := `{"title": "Send Email"}`
+	requestHash := hashRequest(models.CreateTaskRequest{Title: "Send Email"})
+
+	tests := []struct {
+		name             string
+		key              string
+		payload          string
+		cached           string
+		expectedStatus   int
+		expectedBody     string
+		expectedReplayed bool
+		expectedCached   bool
+		mockDB           func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:           "New key creates the task",
+			key:            "order-42",
+			payload:        payload,
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"id":7}`,
+			expectedCached: true,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(claimQuery).
+					WithArgs("order-42", requestHash, sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("order-42"))
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Send Email", "", sql.NullString{}, "pending", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+				mock.ExpectExec(recordQuery).
+					WithArgs(int64(7), http.StatusCreated, "order-42").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:             "Repeat replays from the database",
+			key:              "order-42",
+			payload:          payload,
+			expectedStatus:   http.StatusCreated,
+			expectedBody:     `{"id":7}`,
+			expectedReplayed: true,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(claimQuery).
+					WithArgs("order-42", requestHash, sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"key"}))
+				mock.ExpectQuery(loadQuery).
+					WithArgs("order-42").
+					WillReturnRows(sqlmock.NewRows([]string{"task_id", "status_code", "request_hash"}).AddRow(7, 201, requestHash))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:             "Repeat replays from the cache",
+			key:              "order-42",
+			payload:          payload,
+			cached:           `{"task_id":7,"status_code":201,"request_hash":"` + requestHash + `"}`,
+			expectedStatus:   http.StatusCreated,
+			expectedBody:     `{"id":7}`,
+			expectedReplayed: true,
+			mockDB:           func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Key reused for a different request",
+			key:            "order-42",
+			payload:        `{"title": "Send SMS"}`,
+			cached:         `{"task_id":7,"status_code":201,"request_hash":"` + requestHash + `"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Key too long",
+			key:            strings.Repeat("k", 256),
+			payload:        payload,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Database error",
+			key:            "order-42",
+			payload:        payload,
+			expectedStatus: http.StatusInternalServerError,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(claimQuery).
+					WithArgs("order-42", requestHash, sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, mock := setupTestHandler(t)
+			tt.mockDB(mock)
+
+			cachedKeys := []string{}
+			handler.cache.(*redisMock).getFunc = func(ctx context.Context, key string) *redis.StringCmd {
+				assert.Equal(t, "idempotency:"+tt.key, key)
+				cmd := redis.NewStringCmd(ctx)
+				if tt.cached == "" {
+					cmd.SetErr(redis.Nil)
+				} else {
+					cmd.SetVal(tt.cached)
+				}
+				return cmd
+			}
+			handler.cache.(*redisMock).setFunc = func(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+				assert.Equal(t, time.Hour, expiration)
+				cachedKeys = append(cachedKeys, key)
+				return redis.NewStatusCmd(ctx)
+			}
+
+			req := httptest.NewRequest("POST", "/tasks", bytes.NewBufferString(tt.payload))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Idempotency-Key", tt.key)
+			rr := httptest.NewRecorder()
+
+			handler.CreateTask(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, rr.Body.String())
+			}
+			assert.Equal(t, tt.expectedReplayed, rr.Header().Get("Idempotent-Replayed") == "true")
+			if tt.expectedCached {
+				assert.Equal(t, []string{"idempotency:" + tt.key}, cachedKeys)
+			} else {
+				assert.Empty(t, cachedKeys)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+)
+
+// QueueHandler manages per-queue settings. Tasks may use a queue that has
+// no settings, in which case the server defaults apply.
+type QueueHandler struct {
+	db *sql.DB
+}
+
+func NewQueueHandler(db *sql.DB) *QueueHandler {
+	return &QueueHandler{
+		db: db,
+	}
+}
+
+func (h *QueueHandler) CreateQueue(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateQueueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := store.ValidateQueueName(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateQueueSettings(req.DefaultMaxAttempts, req.LeaseSeconds, req.MaxInFlight, req.RateLimit, req.RatePeriodSeconds); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		INSERT INTO queues (name, default_max_attempts, lease_seconds, max_in_flight, rate_limit, rate_period_seconds, paused, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		RETURNING ` + store.QueueColumns
+
+	queue, err := store.ScanQueue(h.db.QueryRowContext(
+		r.Context(),
+		query,
+		req.Name,
+		nullInt(req.DefaultMaxAttempts),
+		nullInt(req.LeaseSeconds),
+		nullInt(req.MaxInFlight),
+		nullInt(req.RateLimit),
+		nullInt(req.RatePeriodSeconds),
+		req.Paused,
+		time.Now(),
+	))
+
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+		http.Error(w, "Queue already exists", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to create queue", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(queue)
+}
+
+func (h *QueueHandler) GetQueue(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT ` + store.QueueColumns + `
+		FROM queues
+		WHERE name = $1`
+
+	queue, err := store.ScanQueue(h.db.QueryRowContext(r.Context(), query, chi.URLParam(r, "name")))
+
+	if err == sql.ErrNoRows {
+		http.Error(w, "Queue not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to get queue", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queue)
+}
+
+// UpdateQueue changes the settings set in the request. Tasks already in the
+// queue keep their max_attempts; new leases use the new lease_seconds, and
+// tasks claimed over a lowered max_in_flight run to completion. Setting
+// max_in_flight, rate_limit or rate_period_seconds to 0 removes it.
+func (h *QueueHandler) UpdateQueue(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateQueueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateQueueSettings(req.DefaultMaxAttempts, req.LeaseSeconds, unlessZero(req.MaxInFlight), unlessZero(req.RateLimit), unlessZero(req.RatePeriodSeconds)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		UPDATE queues
+		SET default_max_attempts = COALESCE($1, default_max_attempts),
+			lease_seconds = COALESCE($2, lease_seconds),
+			max_in_flight = NULLIF(COALESCE($3, max_in_flight), 0),
+			rate_limit = NULLIF(COALESCE($4, rate_limit), 0),
+			rate_period_seconds = NULLIF(COALESCE($5, rate_period_seconds), 0),
+			paused = COALESCE($6, paused),
+			updated_at = $7
+		WHERE name = $8
+		RETURNING ` + store.QueueColumns
+
+	var paused sql.NullBool
+	if req.Paused != nil {
+		paused = sql.NullBool{Bool: *req.Paused, Valid: true}
+	}
+
+	queue, err := store.ScanQueue(h.db.QueryRowContext(
+		r.Context(),
+		query,
+		nullInt(req.DefaultMaxAttempts),
+		nullInt(req.LeaseSeconds),
+		nullInt(req.MaxInFlight),
+		nullInt(req.RateLimit),
+		nullInt(req.RatePeriodSeconds),
+		paused,
+		time.Now(),
+		chi.URLParam(r, "name"),
+	))
+
+	if err == sql.ErrNoRows {
+		http.Error(w, "Queue not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to update queue", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queue)
+}
+
+// PauseQueue stops workers from claiming tasks from a queue. New tasks are
+// still accepted, and tasks already claimed run to completion.
+func (h *QueueHandler) PauseQueue(w http.ResponseWriter, r *http.Request) {
+	h.setPaused(w, r, true)
+}
+
+// ResumeQueue lets workers claim tasks from a paused queue again
+func (h *QueueHandler) ResumeQueue(w http.ResponseWriter, r *http.Request) {
+	h.setPaused(w, r, false)
+}
+
+// setPaused stores the paused flag of a queue, creating its settings if the
+// queue has none yet
+func (h *QueueHandler) setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	name := chi.URLParam(r, "name")
+	if err := store.ValidateQueueName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		INSERT INTO queues (name, paused, created_at, updated_at)
+		VALUES ($1, $2, $3, $3)
+		ON CONFLICT (name) DO UPDATE
+		SET paused = EXCLUDED.paused,
+			updated_at = EXCLUDED.updated_at
+		RETURNING ` + store.QueueColumns
+
+	queue, err := store.ScanQueue(h.db.QueryRowContext(r.Context(), query, name, paused, time.Now()))
+	if err != nil {
+		http.Error(w, "Failed to update queue", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queue)
+}
+
+func (h *QueueHandler) ListQueues(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT ` + store.QueueColumns + `
+		FROM queues
+		ORDER BY name`
+
+	rows, err := h.db.QueryContext(r.Context(), query)
+	if err != nil {
+		http.Error(w, "Failed to list queues", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	queues := []models.Queue{}
+	for rows.Next() {
+		queue, err := store.ScanQueue(rows)
+		if err != nil {
+			http.Error(w, "Failed to scan queue", http.StatusInternalServerError)
+			return
+		}
+		queues = append(queues, queue)
+	}
+
+	if err = rows.Err(); err != nil {
+		http.Error(w, "Error iterating queues", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queues)
+}
+
+// validateQueueSettings checks the optional numeric queue settings. Errors
+// are of type store.ValidationError.
+func validateQueueSettings(defaultMaxAttempts, leaseSeconds, maxInFlight, rateLimit, ratePeriodSeconds *int) error {
+	if defaultMaxAttempts != nil && *defaultMaxAttempts < 1 {
+		return store.ValidationError("Default max attempts must be at least 1")
+	}
+	if leaseSeconds != nil && *leaseSeconds < 1 {
+		return store.ValidationError("Lease seconds must be at least 1")
+	}
+	if maxInFlight != nil && *maxInFlight < 1 {
+		return store.ValidationError("Max in flight must be at least 1")
+	}
+	if rateLimit != nil && *rateLimit < 1 {
+		return store.ValidationError("Rate limit must be at least 1")
+	}
+	if ratePeriodSeconds != nil && *ratePeriodSeconds < 1 {
+		return store.ValidationError("Rate period seconds must be at least 1")
+	}
+	return nil
+}
+
+// unlessZero returns v unless it is 0, which removes a limit when updating a
+// queue
+func unlessZero(v *int) *int {
+	if v != nil && *v == 0 {
+		return nil
+	}
+	return v
+}
+
+// nullInt converts an optional integer into a query argument
+func nullInt(v *int) sql.NullInt64 {
+	if v == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*v), Valid: true}
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// queueRowColumns are the column names returned by queue queries
+var queueRowColumns = []string{"name", "default_max_attempts", "lease_seconds", "max_in_flight", "rate_limit", "rate_period_seconds", "paused", "created_at", "updated_at"}
+
+// addQueueRow appends a queue with the given settings to rows
+func addQueueRow(rows *sqlmock.Rows, name string, maxAttempts, leaseSeconds, maxInFlight, rateLimit, ratePeriod interface{}, paused bool) *sqlmock.Rows {
+	return rows.AddRow(name, maxAttempts, leaseSeconds, maxInFlight, rateLimit, ratePeriod, paused, time.Now(), time.Now())
+}
+
+func setupTestQueueHandler(t *testing.T) (*QueueHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+
+	return NewQueueHandler(db), mock
+}
+
+func TestQueueHandler_CreateQueue(t *testing.T) {
+	handler, mock := setupTestQueueHandler(t)
+
+	insertQuery := `INSERT INTO queues \(name, default_max_attempts, lease_seconds, max_in_flight, rate_limit, rate_period_seconds, paused, created_at, updated_at\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, \$7, \$8, \$8\) RETURNING ` + regexp.QuoteMeta(store.QueueColumns)
+
+	tests := []struct {
+		name           string
+		payload        string
+		expectedStatus int
+		mockDB         func()
+	}{
+		{
+			name:           "Queue with settings",
+			payload:        `{"name": "emails", "default_max_attempts": 10, "lease_seconds": 120, "max_in_flight": 5, "rate_limit": 100, "rate_period_seconds": 60}`,
+			expectedStatus: http.StatusCreated,
+			mockDB: func() {
+				mock.ExpectQuery(insertQuery).
+					WithArgs(
+						"emails",
+						sql.NullInt64{Int64: 10, Valid: true},
+						sql.NullInt64{Int64: 120, Valid: true},
+						sql.NullInt64{Int64: 5, Valid: true},
+						sql.NullInt64{Int64: 100, Valid: true},
+						sql.NullInt64{Int64: 60, Valid: true},
+						false,
+						sqlmock.AnyArg(),
+					).
+					WillReturnRows(addQueueRow(sqlmock.NewRows(queueRowColumns), "emails", 10, 120, 5, 100, 60, false))
+			},
+		},
+		{
+			name:           "Queue with defaults",
+			payload:        `{"name": "reports"}`,
+			expectedStatus: http.StatusCreated,
+			mockDB: func() {
+				mock.ExpectQuery(insertQuery).
+					WithArgs("reports", sql.NullInt64{}, sql.NullInt64{}, sql.NullInt64{}, sql.NullInt64{}, sql.NullInt64{}, false, sqlmock.AnyArg()).
+					WillReturnRows(addQueueRow(sqlmock.NewRows(queueRowColumns), "reports", nil, nil, nil, nil, nil, false))
+			},
+		},
+		{
+			name:           "Queue already exists",
+			payload:        `{"name": "emails"}`,
+			expectedStatus: http.StatusConflict,
+			mockDB: func() {
+				mock.ExpectQuery(insertQuery).
+					WillReturnError(&pq.Error{Code: "23505"})
+			},
+		},
+		{
+			name:           "Invalid name",
+			payload:        `{"name": "emails eu"}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid max attempts",
+			payload:        `{"name": "emails", "default_max_attempts": 0}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid lease",
+			payload:        `{"name": "emails", "lease_seconds": -1}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid max in flight",
+			payload:        `{"name": "emails", "max_in_flight": 0}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid rate period",
+			payload:        `{"name": "emails", "rate_limit": 100, "rate_period_seconds": 0}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockDB()
+
+			req := httptest.NewRequest("POST", "/api/v1/queues", strings.NewReader(tt.payload))
+			w := httptest.NewRecorder()
+
+			handler.CreateQueue(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestQueueHandler_GetQueue(t *testing.T) {
+	handler, mock := setupTestQueueHandler(t)
+
+	selectQuery := `SELECT ` + regexp.QuoteMeta(store.QueueColumns) + ` FROM queues WHERE name = \$1`
+
+	t.Run("Existing queue", func(t *testing.T) {
+		mock.ExpectQuery(selectQuery).
+			WithArgs("emails").
+			WillReturnRows(addQueueRow(sqlmock.NewRows(queueRowColumns), "emails", 10, nil, nil, nil, nil, false))
+
+		w := httptest.NewRecorder()
+		handler.GetQueue(w, withURLParam(httptest.NewRequest("GET", "/api/v1/queues/emails", nil), "name", "emails"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response models.Queue
+		err := json.NewDecoder(w.Body).Decode(&response)
+		assert.NoError(t, err)
+		if assert.NotNil(t, response.DefaultMaxAttempts) {
+			assert.Equal(t, 10, *response.DefaultMaxAttempts)
+		}
+		assert.Nil(t, response.LeaseSeconds)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Missing queue", func(t *testing.T) {
+		mock.ExpectQuery(selectQuery).
+			WithArgs("sms").
+			WillReturnRows(sqlmock.NewRows(queueRowColumns))
+
+		w := httptest.NewRecorder()
+		handler.GetQueue(w, withURLParam(httptest.NewRequest("GET", "/api/v1/queues/sms", nil), "name", "sms"))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestQueueHandler_UpdateQueue(t *testing.T) {
+	handler, mock := setupTestQueueHandler(t)
+
+	updateQuery := `UPDATE queues SET default_max_attempts = COALESCE\(\$1, default_max_attempts\), lease_seconds = COALESCE\(\$2, lease_seconds\), max_in_flight = NULLIF\(COALESCE\(\$3, max_in_flight\), 0\), rate_limit = NULLIF\(COALESCE\(\$4, rate_limit\), 0\), rate_period_seconds = NULLIF\(COALESCE\(\$5, rate_period_seconds\), 0\), paused = COALESCE\(\$6, paused\), updated_at = \$7 WHERE name = \$8 RETURNING ` + regexp.QuoteMeta(store.QueueColumns)
+
+	tests := []struct {
+		name           string
+		queue          string
+		payload        string
+		expectedStatus int
+		mockDB         func()
+	}{
+		{
+			name:           "Change lease",
+			queue:          "emails",
+			payload:        `{"lease_seconds": 300}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectQuery(updateQuery).
+					WithArgs(sql.NullInt64{}, sql.NullInt64{Int64: 300, Valid: true}, sql.NullInt64{}, sql.NullInt64{}, sql.NullInt64{}, sql.NullBool{}, sqlmock.AnyArg(), "emails").
+					WillReturnRows(addQueueRow(sqlmock.NewRows(queueRowColumns), "emails", nil, 300, nil, nil, nil, false))
+			},
+		},
+		{
+			name:           "Remove max in flight",
+			queue:          "emails",
+			payload:        `{"max_in_flight": 0}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectQuery(updateQuery).
+					WithArgs(sql.NullInt64{}, sql.NullInt64{}, sql.NullInt64{Int64: 0, Valid: true}, sql.NullInt64{}, sql.NullInt64{}, sql.NullBool{}, sqlmock.AnyArg(), "emails").
+					WillReturnRows(addQueueRow(sqlmock.NewRows(queueRowColumns), "emails", nil, nil, nil, nil, nil, false))
+			},
+		},
+		{
+			name:           "Remove rate limit",
+			queue:          "sms",
+			payload:        `{"rate_limit": 0, "rate_period_seconds": 0}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectQuery(updateQuery).
+					WithArgs(sql.NullInt64{}, sql.NullInt64{}, sql.NullInt64{}, sql.NullInt64{Int64: 0, Valid: true}, sql.NullInt64{Int64: 0, Valid: true}, sql.NullBool{}, sqlmock.AnyArg(), "sms").
+					WillReturnRows(addQueueRow(sqlmock.NewRows(queueRowColumns), "sms", nil, nil, nil, nil, nil, false))
+			},
+		},
+		{
+			name:           "Invalid rate limit",
+			queue:          "sms",
+			payload:        `{"rate_limit": -5}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid max in flight",
+			queue:          "emails",
+			payload:        `{"max_in_flight": -1}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Missing queue",
+			queue:          "sms",
+			payload:        `{"default_max_attempts": 2}`,
+			expectedStatus: http.StatusNotFound,
+			mockDB: func() {
+				mock.ExpectQuery(updateQuery).
+					WithArgs(sql.NullInt64{Int64: 2, Valid: true}, sql.NullInt64{}, sql.NullInt64{}, sql.NullInt64{}, sql.NullInt64{}, sql.NullBool{}, sqlmock.AnyArg(), "sms").
+					WillReturnRows(sqlmock.NewRows(queueRowColumns))
+			},
+		},
+		{
+			name:           "Invalid lease",
+			queue:          "emails",
+			payload:        `{"lease_seconds": 0}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockDB()
+
+			req := httptest.NewRequest("PUT", "/api/v1/queues/"+tt.queue, strings.NewReader(tt.payload))
+			w := httptest.NewRecorder()
+
+			handler.UpdateQueue(w, withURLParam(req, "name", tt.queue))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestQueueHandler_PauseQueue(t *testing.T) {
+	handler, mock := setupTestQueueHandler(t)
+
+	upsertQuery := `INSERT INTO queues \(name, paused, created_at, updated_at\) VALUES \(\$1, \$2, \$3, \$3\) ON CONFLICT \(name\) DO UPDATE SET paused = EXCLUDED.paused, updated_at = EXCLUDED.updated_at RETURNING ` + regexp.QuoteMeta(store.QueueColumns)
+
+	t.Run("Pause", func(t *testing.T) {
+		mock.ExpectQuery(upsertQuery).
+			WithArgs("emails", true, sqlmock.AnyArg()).
+			WillReturnRows(addQueueRow(sqlmock.NewRows(queueRowColumns), "emails", nil, nil, nil, nil, nil, true))
+
+		w := httptest.NewRecorder()
+		handler.PauseQueue(w, withURLParam(httptest.NewRequest("POST", "/api/v1/queues/emails/pause", nil), "name", "emails"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response models.Queue
+		err := json.NewDecoder(w.Body).Decode(&response)
+		assert.NoError(t, err)
+		assert.True(t, response.Paused)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		mock.ExpectQuery(upsertQuery).
+			WithArgs("emails", false, sqlmock.AnyArg()).
+			WillReturnRows(addQueueRow(sqlmock.NewRows(queueRowColumns), "emails", nil, nil, nil, nil, nil, false))
+
+		w := httptest.NewRecorder()
+		handler.ResumeQueue(w, withURLParam(httptest.NewRequest("POST", "/api/v1/queues/emails/resume", nil), "name", "emails"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response models.Queue
+		err := json.NewDecoder(w.Body).Decode(&response)
+		assert.NoError(t, err)
+		assert.False(t, response.Paused)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid name", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.PauseQueue(w, withURLParam(httptest.NewRequest("POST", "/api/v1/queues/a%20b/pause", nil), "name", "a b"))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestQueueHandler_ListQueues(t *testing.T) {
+	handler, mock := setupTestQueueHandler(t)
+
+	mock.ExpectQuery(`SELECT ` + regexp.QuoteMeta(store.QueueColumns) + ` FROM queues ORDER BY name`).
+		WillReturnRows(addQueueRow(addQueueRow(sqlmock.NewRows(queueRowColumns), "default", nil, nil, nil, nil, nil, false), "emails", 10, 120, nil, nil, nil, true))
+
+	w := httptest.NewRecorder()
+	handler.ListQueues(w, httptest.NewRequest("GET", "/api/v1/queues", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response []models.Queue
+	err := json.NewDecoder(w.Body).Decode(&response)
+	assert.NoError(t, err)
+	if assert.Len(t, response, 2) {
+		assert.True(t, response[1].Paused)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+)
+
+// uniqueViolation is the Postgres error code for a unique constraint violation
+const uniqueViolation = "23505"
+
+// ScheduleHandler manages the cron schedules that the scheduler turns into
+// tasks
+type ScheduleHandler struct {
+	db     *sql.DB
+	config *Config
+}
+
+func NewScheduleHandler(db *sql.DB, config *Config) *ScheduleHandler {
+	return &ScheduleHandler{
+		db:     db,
+		config: config,
+	}
+}
+
+func (h *ScheduleHandler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.TaskTemplate.Payload) > h.config.MaxPayloadBytes {
+		http.Error(w, "Task template payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	schedule := models.Schedule{
+		Name:           req.Name,
+		CronExpression: req.CronExpression,
+		Timezone:       req.Timezone,
+		TaskTemplate:   req.TaskTemplate,
+		CatchUp:        req.CatchUp,
+		Enabled:        req.Enabled == nil || *req.Enabled,
+	}
+	if schedule.Timezone == "" {
+		schedule.Timezone = "UTC"
+	}
+	if schedule.CatchUp == "" {
+		schedule.CatchUp = models.CatchUpSkip
+	}
+
+	now := time.Now()
+	nextRun, err := validateSchedule(schedule, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		INSERT INTO schedules (name, cron_expression, timezone, task_template, catch_up, enabled, next_run_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		RETURNING ` + store.ScheduleColumns
+
+	template, _ := json.Marshal(schedule.TaskTemplate)
+	schedule, err = store.ScanSchedule(h.db.QueryRowContext(
+		r.Context(),
+		query,
+		schedule.Name,
+		schedule.CronExpression,
+		schedule.Timezone,
+		string(template),
+		schedule.CatchUp,
+		schedule.Enabled,
+		nextRun,
+		now,
+	))
+
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+		http.Error(w, "Schedule name already exists", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to create schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(schedule)
+}
+
+func (h *ScheduleHandler) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid schedule ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		SELECT ` + store.ScheduleColumns + `
+		FROM schedules
+		WHERE id = $1`
+
+	schedule, err := store.ScanSchedule(h.db.QueryRowContext(r.Context(), query, scheduleID))
+
+	if err == sql.ErrNoRows {
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to get schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// UpdateSchedule changes the fields set in the request. The next run is
+// recomputed from now when the expression, time zone or enabled flag
+// changes, so missed ticks are never caught up because of an edit.
+func (h *ScheduleHandler) UpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid schedule ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.UpdateScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.TaskTemplate != nil && len(req.TaskTemplate.Payload) > h.config.MaxPayloadBytes {
+		http.Error(w, "Task template payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "Failed to update schedule", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	schedule, err := lockSchedule(ctx, tx, scheduleID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to get schedule", http.StatusInternalServerError)
+		return
+	}
+
+	reschedule := false
+	if req.Name != nil {
+		schedule.Name = *req.Name
+	}
+	if req.CronExpression != nil && *req.CronExpression != schedule.CronExpression {
+		schedule.CronExpression = *req.CronExpression
+		reschedule = true
+	}
+	if req.Timezone != nil && *req.Timezone != schedule.Timezone {
+		schedule.Timezone = *req.Timezone
+		reschedule = true
+	}
+	if req.TaskTemplate != nil {
+		schedule.TaskTemplate = *req.TaskTemplate
+	}
+	if req.CatchUp != nil {
+		schedule.CatchUp = *req.CatchUp
+	}
+	if req.Enabled != nil && *req.Enabled != schedule.Enabled {
+		schedule.Enabled = *req.Enabled
+		reschedule = true
+	}
+
+	now := time.Now()
+	nextRun, err := validateSchedule(schedule, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !reschedule && schedule.Enabled && schedule.NextRunAt != nil {
+		nextRun = sql.NullTime{Time: *schedule.NextRunAt, Valid: true}
+	}
+
+	query := `
+		UPDATE schedules
+		SET name = $1,
+			cron_expression = $2,
+			timezone = $3,
+			task_template = $4,
+			catch_up = $5,
+			enabled = $6,
+			next_run_at = $7,
+			updated_at = $8
+		WHERE id = $9
+		RETURNING ` + store.ScheduleColumns
+
+	template, _ := json.Marshal(schedule.TaskTemplate)
+	schedule, err = store.ScanSchedule(tx.QueryRowContext(
+		ctx,
+		query,
+		schedule.Name,
+		schedule.CronExpression,
+		schedule.Timezone,
+		string(template),
+		schedule.CatchUp,
+		schedule.Enabled,
+		nextRun,
+		now,
+		scheduleID,
+	))
+
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+		http.Error(w, "Schedule name already exists", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to update schedule", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update schedule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// DeleteSchedule removes a schedule. Tasks it already enqueued are kept.
+func (h *ScheduleHandler) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid schedule ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.db.ExecContext(r.Context(), `DELETE FROM schedules WHERE id = $1`, scheduleID)
+	if err != nil {
+		http.Error(w, "Failed to delete schedule", http.StatusInternalServerError)
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		http.Error(w, "Failed to get rows affected", http.StatusInternalServerError)
+		return
+	}
+
+	if rowsAffected == 0 {
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ScheduleHandler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	pageSize, offset := pagination(r)
+
+	query := `
+		SELECT ` + store.ScheduleColumns + `
+		FROM schedules
+		ORDER BY id
+		LIMIT $1 OFFSET $2`
+
+	rows, err := h.db.QueryContext(r.Context(), query, pageSize, offset)
+	if err != nil {
+		http.Error(w, "Failed to list schedules", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	schedules := []models.Schedule{}
+	for rows.Next() {
+		schedule, err := store.ScanSchedule(rows)
+		if err != nil {
+			http.Error(w, "Failed to scan schedule", http.StatusInternalServerError)
+			return
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	if err = rows.Err(); err != nil {
+		http.Error(w, "Error iterating schedules", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedules)
+}
+
+// lockSchedule selects a schedule and locks its row until tx ends, keeping
+// the scheduler from firing it mid-update
+func lockSchedule(ctx context.Context, tx *sql.Tx, scheduleID int64) (models.Schedule, error) {
+	query := `
+		SELECT ` + store.ScheduleColumns + `
+		FROM schedules
+		WHERE id = $1
+		FOR UPDATE`
+
+	return store.ScanSchedule(tx.QueryRowContext(ctx, query, scheduleID))
+}
+
+// validateSchedule checks a schedule and returns its first run after now,
+// which is null for a disabled schedule. Errors are of type
+// store.ValidationError.
+func validateSchedule(schedule models.Schedule, now time.Time) (sql.NullTime, error) {
+	if schedule.Name == "" {
+		return sql.NullTime{}, store.ValidationError("Name is required")
+	}
+	if schedule.CatchUp != models.CatchUpSkip && schedule.CatchUp != models.CatchUpOnce && schedule.CatchUp != models.CatchUpAll {
+		return sql.NullTime{}, store.ValidationError("Invalid catch_up value")
+	}
+
+	// Every tick creates a task from the template, so it must be valid on
+	// its own and cannot pin the task to a fixed time
+	if schedule.TaskTemplate.RunAt != nil {
+		return sql.NullTime{}, store.ValidationError("Task template must not set run_at")
+	}
+	if _, err := store.NewTaskFromRequest(schedule.TaskTemplate, now); err != nil {
+		return sql.NullTime{}, store.ValidationError("Invalid task template: " + err.Error())
+	}
+
+	cronSchedule, loc, err := store.ParseSchedule(schedule.CronExpression, schedule.Timezone)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+
+	next := cronSchedule.Next(now.In(loc))
+	if next.IsZero() {
+		return sql.NullTime{}, store.ValidationError("Cron expression never fires")
+	}
+	if !schedule.Enabled {
+		return sql.NullTime{}, nil
+	}
+
+	return sql.NullTime{Time: next, Valid: true}, nil
+}
@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// scheduleRowColumns are the column names returned by schedule queries
+var scheduleRowColumns = []string{"id", "name", "cron_expression", "timezone", "task_template", "catch_up", "enabled", "next_run_at", "last_run_at", "created_at", "updated_at"}
+
+// addScheduleRow appends an enabled nightly schedule to rows
+func addScheduleRow(rows *sqlmock.Rows, id int64, name string) *sqlmock.Rows {
+	now := time.Now()
+	return rows.AddRow(id, name, "0 3 * * *", "UTC", []byte(`{"title":"Nightly report"}`), "skip", true, now.Add(time.Hour), nil, now, now)
+}
+
+func setupTestScheduleHandler(t *testing.T) (*ScheduleHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+
+	return NewScheduleHandler(db, &Config{MaxPayloadBytes: 64}), mock
+}
+
+// withURLParam adds a chi URL parameter to a request
+func withURLParam(req *http.Request, key, value string) *http.Request {
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add(key, value)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+}
+
+func TestScheduleHandler_CreateSchedule(t *testing.T) {
+	handler, mock := setupTestScheduleHandler(t)
+
+	insertQuery := `INSERT INTO schedules \(name, cron_expression, timezone, task_template, catch_up, enabled, next_run_at, created_at, updated_at\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, \$7, \$8, \$8\) RETURNING ` + regexp.QuoteMeta(store.ScheduleColumns)
+
+	tests := []struct {
+		name           string
+		payload        string
+		expectedStatus int
+		mockDB         func()
+	}{
+		{
+			name:           "Valid schedule with defaults",
+			payload:        `{"name": "nightly", "cron_expression": "0 3 * * *", "task_template": {"title": "Nightly report"}}`,
+			expectedStatus: http.StatusCreated,
+			mockDB: func() {
+				mock.ExpectQuery(insertQuery).
+					WithArgs("nightly", "0 3 * * *", "UTC", sqlmock.AnyArg(), "skip", true, afterTime{time.Now()}, sqlmock.AnyArg()).
+					WillReturnRows(addScheduleRow(sqlmock.NewRows(scheduleRowColumns), 1, "nightly"))
+			},
+		},
+		{
+			name:           "Disabled schedule has no next run",
+			payload:        `{"name": "hourly", "cron_expression": "@hourly", "timezone": "Europe/Berlin", "catch_up": "all", "enabled": false, "task_template": {"title": "Sync"}}`,
+			expectedStatus: http.StatusCreated,
+			mockDB: func() {
+				mock.ExpectQuery(insertQuery).
+					WithArgs("hourly", "@hourly", "Europe/Berlin", sqlmock.AnyArg(), "all", false, nil, sqlmock.AnyArg()).
+					WillReturnRows(addScheduleRow(sqlmock.NewRows(scheduleRowColumns), 2, "hourly"))
+			},
+		},
+		{
+			name:           "Duplicate name",
+			payload:        `{"name": "nightly", "cron_expression": "0 3 * * *", "task_template": {"title": "Nightly report"}}`,
+			expectedStatus: http.StatusConflict,
+			mockDB: func() {
+				mock.ExpectQuery(insertQuery).
+					WillReturnError(&pq.Error{Code: "23505"})
+			},
+		},
+		{
+			name:           "Missing name",
+			payload:        `{"cron_expression": "0 3 * * *", "task_template": {"title": "Nightly report"}}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid cron expression",
+			payload:        `{"name": "nightly", "cron_expression": "0 25 * * *", "task_template": {"title": "Nightly report"}}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Cron expression that never fires",
+			payload:        `{"name": "nightly", "cron_expression": "0 0 31 2 *", "task_template": {"title": "Nightly report"}}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid timezone",
+			payload:        `{"name": "nightly", "cron_expression": "0 3 * * *", "timezone": "Mars/Olympus", "task_template": {"title": "Nightly report"}}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid catch up policy",
+			payload:        `{"name": "nightly", "cron_expression": "0 3 * * *", "catch_up": "sometimes", "task_template": {"title": "Nightly report"}}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Template without title",
+			payload:        `{"name": "nightly", "cron_expression": "0 3 * * *", "task_template": {}}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Template with fixed run_at",
+			payload:        `{"name": "nightly", "cron_expression": "0 3 * * *", "task_template": {"title": "Nightly report", "run_at": "2030-01-01T00:00:00Z"}}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Template payload too large",
+			payload:        `{"name": "nightly", "cron_expression": "0 3 * * *", "task_template": {"title": "Nightly report", "payload": {"data": "` + strings.Repeat("x", 64) + `"}}}`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			mockDB:         func() {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockDB()
+
+			req := httptest.NewRequest("POST", "/api/v1/schedules", strings.NewReader(tt.payload))
+			w := httptest.NewRecorder()
+
+			handler.CreateSchedule(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestScheduleHandler_GetSchedule(t *testing.T) {
+	handler, mock := setupTestScheduleHandler(t)
+
+	selectQuery := `SELECT ` + regexp.QuoteMeta(store.ScheduleColumns) + ` FROM schedules WHERE id = \$1`
+
+	t.Run("Existing schedule", func(t *testing.T) {
+		mock.ExpectQuery(selectQuery).
+			WithArgs(1).
+			WillReturnRows(addScheduleRow(sqlmock.NewRows(scheduleRowColumns), 1, "nightly"))
+
+		w := httptest.NewRecorder()
+		handler.GetSchedule(w, withURLParam(httptest.NewRequest("GET", "/api/v1/schedules/1", nil), "id", "1"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response models.Schedule
+		err := json.NewDecoder(w.Body).Decode(&response)
+		assert.NoError(t, err)
+		assert.Equal(t, "nightly", response.Name)
+		assert.Equal(t, "Nightly report", response.TaskTemplate.Title)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Missing schedule", func(t *testing.T) {
+		mock.ExpectQuery(selectQuery).
+			WithArgs(9).
+			WillReturnRows(sqlmock.NewRows(scheduleRowColumns))
+
+		w := httptest.NewRecorder()
+		handler.GetSchedule(w, withURLParam(httptest.NewRequest("GET", "/api/v1/schedules/9", nil), "id", "9"))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid ID", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.GetSchedule(w, withURLParam(httptest.NewRequest("GET", "/api/v1/schedules/abc", nil), "id", "abc"))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestScheduleHandler_UpdateSchedule(t *testing.T) {
+	handler, mock := setupTestScheduleHandler(t)
+
+	lockQuery := `SELECT ` + regexp.QuoteMeta(store.ScheduleColumns) + ` FROM schedules WHERE id = \$1 FOR UPDATE`
+	updateQuery := `UPDATE schedules SET name = \$1, cron_expression = \$2, timezone = \$3, task_template = \$4, catch_up = \$5, enabled = \$6, next_run_at = \$7, updated_at = \$8 WHERE id = \$9 RETURNING ` + regexp.QuoteMeta(store.ScheduleColumns)
+
+	tests := []struct {
+		name           string
+		payload        string
+		expectedStatus int
+		mockDB         func()
+	}{
+		{
+			name:           "Changing the template keeps the next run",
+			payload:        `{"task_template": {"title": "Nightly summary"}}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				nextRun := time.Date(2030, time.January, 1, 3, 0, 0, 0, time.UTC)
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(scheduleRowColumns).
+						AddRow(1, "nightly", "0 3 * * *", "UTC", []byte(`{"title":"Nightly report"}`), "skip", true, nextRun, nil, time.Now(), time.Now()))
+				mock.ExpectQuery(updateQuery).
+					WithArgs("nightly", "0 3 * * *", "UTC", `{"title":"Nightly summary","description":""}`, "skip", true, nextRun, sqlmock.AnyArg(), 1).
+					WillReturnRows(addScheduleRow(sqlmock.NewRows(scheduleRowColumns), 1, "nightly"))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Disabling clears the next run",
+			payload:        `{"enabled": false}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnRows(addScheduleRow(sqlmock.NewRows(scheduleRowColumns), 1, "nightly"))
+				mock.ExpectQuery(updateQuery).
+					WithArgs("nightly", "0 3 * * *", "UTC", sqlmock.AnyArg(), "skip", false, nil, sqlmock.AnyArg(), 1).
+					WillReturnRows(addScheduleRow(sqlmock.NewRows(scheduleRowColumns), 1, "nightly"))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Invalid cron expression",
+			payload:        `{"cron_expression": "every day"}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnRows(addScheduleRow(sqlmock.NewRows(scheduleRowColumns), 1, "nightly"))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Template payload too large",
+			payload:        `{"task_template": {"title": "Nightly report", "payload": {"data": "` + strings.Repeat("x", 64) + `"}}}`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Missing schedule",
+			payload:        `{"enabled": false}`,
+			expectedStatus: http.StatusNotFound,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows(scheduleRowColumns))
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockDB()
+
+			req := httptest.NewRequest("PUT", "/api/v1/schedules/1", strings.NewReader(tt.payload))
+			w := httptest.NewRecorder()
+
+			handler.UpdateSchedule(w, withURLParam(req, "id", "1"))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestScheduleHandler_DeleteSchedule(t *testing.T) {
+	handler, mock := setupTestScheduleHandler(t)
+
+	t.Run("Existing schedule", func(t *testing.T) {
+		mock.ExpectExec(`DELETE FROM schedules WHERE id = \$1`).
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		w := httptest.NewRecorder()
+		handler.DeleteSchedule(w, withURLParam(httptest.NewRequest("DELETE", "/api/v1/schedules/1", nil), "id", "1"))
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Missing schedule", func(t *testing.T) {
+		mock.ExpectExec(`DELETE FROM schedules WHERE id = \$1`).
+			WithArgs(2).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		w := httptest.NewRecorder()
+		handler.DeleteSchedule(w, withURLParam(httptest.NewRequest("DELETE", "/api/v1/schedules/2", nil), "id", "2"))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestScheduleHandler_ListSchedules(t *testing.T) {
+	handler, mock := setupTestScheduleHandler(t)
+
+	mock.ExpectQuery(`SELECT `+regexp.QuoteMeta(store.ScheduleColumns)+` FROM schedules ORDER BY id LIMIT \$1 OFFSET \$2`).
+		WithArgs(10, 0).
+		WillReturnRows(addScheduleRow(addScheduleRow(sqlmock.NewRows(scheduleRowColumns), 1, "nightly"), 2, "hourly"))
+
+	w := httptest.NewRecorder()
+	handler.ListSchedules(w, httptest.NewRequest("GET", "/api/v1/schedules", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response []models.Schedule
+	err := json.NewDecoder(w.Body).Decode(&response)
+	assert.NoError(t, err)
+	assert.Len(t, response, 2)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
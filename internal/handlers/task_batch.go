@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+)
+
+// errBatchTooLarge is returned by decodeBatch when a batch has more than the
+// allowed number of tasks
+var errBatchTooLarge = errors.New("batch too large")
+
+// CreateTaskBatch creates many tasks in one transaction. The body is a JSON
+// array of create requests, or one request per line when sent as
+// application/x-ndjson. Each task gets a result in the response. By default
+// tasks that cannot be created are skipped and the response is 207
+// Multi-Status; with atomic=true any failure rolls back the whole batch.
+func (h *TaskHandler) CreateTaskBatch(w http.ResponseWriter, r *http.Request) {
+	atomic := r.URL.Query().Get("atomic") == "true"
+
+	reqs, err := decodeBatch(r, h.config.MaxBatchSize)
+	if err == errBatchTooLarge {
+		http.Error(w, fmt.Sprintf("Batch must contain at most %d tasks", h.config.MaxBatchSize), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(reqs) == 0 {
+		http.Error(w, "Batch must contain at least one task", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	plan := h.planBatch(reqs, now)
+	if atomic && plan.response.Failed > 0 {
+		writeBatchResponse(w, http.StatusBadRequest, rolledBack(plan.response))
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "Failed to create tasks", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	response, err := h.insertBatch(ctx, tx, plan, now)
+	if err != nil {
+		http.Error(w, "Failed to create tasks", http.StatusInternalServerError)
+		return
+	}
+	if atomic && response.Failed > 0 {
+		writeBatchResponse(w, http.StatusBadRequest, rolledBack(response))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create tasks", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusCreated
+	if response.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	writeBatchResponse(w, status, response)
+}
+
+// batchPlan holds the validated tasks of a batch create. Plain tasks are
+// inserted together; tasks with a unique key or dependencies are inserted
+// one at a time. The response already has the results of invalid tasks.
+type batchPlan struct {
+	response     models.TaskBatchResponse
+	plain        []store.NewTask
+	plainIndexes []int
+	special      map[int]store.NewTask
+}
+
+// planBatch validates the tasks of a batch create
+func (h *TaskHandler) planBatch(reqs []models.CreateTaskRequest, now time.Time) *batchPlan {
+	plan := &batchPlan{
+		response: models.TaskBatchResponse{Results: make([]models.TaskBatchResult, len(reqs))},
+		special:  map[int]store.NewTask{},
+	}
+
+	for i, req := range reqs {
+		if len(req.Payload) > h.config.MaxPayloadBytes {
+			plan.fail(i, models.TaskBatchResult{Status: http.StatusRequestEntityTooLarge, Error: "Payload too large"})
+			continue
+		}
+
+		task, err := store.NewTaskFromRequest(req, now)
+		if err != nil {
+			plan.fail(i, models.TaskBatchResult{Status: http.StatusBadRequest, Error: err.Error()})
+			continue
+		}
+
+		if task.UniqueKey == "" && len(task.DependsOn) == 0 {
+			plan.plain = append(plan.plain, task)
+			plan.plainIndexes = append(plan.plainIndexes, i)
+		} else {
+			plan.special[i] = task
+		}
+	}
+	return plan
+}
+
+// fail records that the task at index i could not be created
+func (p *batchPlan) fail(i int, result models.TaskBatchResult) {
+	p.response.Results[i] = result
+	p.response.Failed++
+}
+
+// setBatchID puts every task of the plan in a batch
+func (p *batchPlan) setBatchID(batchID int64) {
+	for i := range p.plain {
+		p.plain[i].BatchID = batchID
+	}
+	for i, task := range p.special {
+		task.BatchID = batchID
+		p.special[i] = task
+	}
+}
+
+// tasks returns every valid task of the plan
+func (p *batchPlan) tasks() []store.NewTask {
+	tasks := append([]store.NewTask(nil), p.plain...)
+	for _, task := range p.special {
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// insertBatch inserts the valid tasks of a plan and returns the result for
+// every task. Tasks that conflict or have invalid dependencies are reported
+// as failed; other errors abort the batch.
+func (h *TaskHandler) insertBatch(ctx context.Context, tx *sql.Tx, plan *batchPlan, now time.Time) (models.TaskBatchResponse, error) {
+	if len(plan.plain) > 0 {
+		ids, err := store.InsertTasks(ctx, tx, plan.plain, now)
+		if err != nil {
+			return plan.response, err
+		}
+		for j, id := range ids {
+			plan.response.Results[plan.plainIndexes[j]] = models.TaskBatchResult{Status: http.StatusCreated, ID: id}
+		}
+	}
+
+	for i := range plan.response.Results {
+		task, ok := plan.special[i]
+		if !ok {
+			continue
+		}
+
+		taskID, status, err := h.insertTask(ctx, tx, task, now)
+		if err == store.ErrUniqueKeyTaken {
+			plan.fail(i, models.TaskBatchResult{Status: http.StatusConflict, Error: "A task with this unique key is already pending or in progress"})
+			continue
+		} else if _, ok := err.(store.ValidationError); ok {
+			plan.fail(i, models.TaskBatchResult{Status: http.StatusBadRequest, Error: err.Error()})
+			continue
+		} else if err != nil {
+			return plan.response, err
+		}
+		plan.response.Results[i] = models.TaskBatchResult{Status: status, ID: taskID}
+	}
+
+	plan.response.Succeeded = len(plan.response.Results) - plan.response.Failed
+	return plan.response, nil
+}
+
+// CompleteTaskBatch marks many tasks leased by the calling worker as
+// completed in one transaction. Each task gets a result in the response:
+// tasks whose lease the worker no longer holds are reported with 409, and
+// tasks that do not exist with 404, while the others are completed. The
+// response is 200 when every task was completed and 207 Multi-Status
+// otherwise.
+func (h *TaskHandler) CompleteTaskBatch(w http.ResponseWriter, r *http.Request) {
+	var req models.CompleteTaskBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.WorkerID == "" {
+		http.Error(w, "Worker ID is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Tasks) == 0 {
+		http.Error(w, "Batch must contain at least one task", http.StatusBadRequest)
+		return
+	}
+	if len(req.Tasks) > h.config.MaxBatchSize {
+		http.Error(w, fmt.Sprintf("Batch must contain at most %d tasks", h.config.MaxBatchSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ids := make([]int64, len(req.Tasks))
+	results := make([]sql.NullString, len(req.Tasks))
+	listed := make(map[int64]bool, len(req.Tasks))
+	for i, task := range req.Tasks {
+		if listed[task.ID] {
+			http.Error(w, fmt.Sprintf("Task %d is listed more than once", task.ID), http.StatusBadRequest)
+			return
+		}
+		listed[task.ID] = true
+		ids[i] = task.ID
+		results[i] = store.NullJSON(task.Result)
+	}
+
+	query := `
+		UPDATE tasks
+		SET status = $1,
+			result = completed.task_result::jsonb,
+			lease_owner = NULL,
+			lease_expires_at = NULL,
+			updated_at = $2
+		FROM unnest($3::bigint[], $4::text[]) AS completed(task_id, task_result)
+		WHERE tasks.id = completed.task_id AND tasks.status = $5 AND tasks.lease_owner = $6
+		RETURNING ` + store.TaskColumns
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "Failed to complete tasks", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	rows, err := tx.QueryContext(
+		ctx,
+		query,
+		models.TaskStatusCompleted,
+		now,
+		pq.Array(ids),
+		pq.Array(results),
+		models.TaskStatusInProgress,
+		req.WorkerID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to complete tasks", http.StatusInternalServerError)
+		return
+	}
+	tasks, err := store.ScanTasks(rows)
+	if err != nil {
+		http.Error(w, "Failed to complete tasks", http.StatusInternalServerError)
+		return
+	}
+
+	completed := make(map[int64]bool, len(tasks))
+	var released []int64
+	for _, task := range tasks {
+		completed[task.ID] = true
+		dependents, err := store.ReleaseDependents(ctx, tx, task, now)
+		if err != nil {
+			http.Error(w, "Failed to complete tasks", http.StatusInternalServerError)
+			return
+		}
+		released = append(released, dependents...)
+	}
+
+	// Tasks that were not completed either do not exist or are not leased
+	// to the worker
+	var existing map[int64]bool
+	if len(tasks) < len(ids) {
+		if existing, err = existingTasks(ctx, tx, ids); err != nil {
+			http.Error(w, "Failed to complete tasks", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to complete tasks", http.StatusInternalServerError)
+		return
+	}
+
+	for _, task := range tasks {
+		cacheKey := fmt.Sprintf("task:%d", task.ID)
+		taskJSON, _ := json.Marshal(task)
+		h.cache.Set(ctx, cacheKey, taskJSON, time.Hour)
+	}
+	h.uncacheTasks(ctx, released)
+
+	response := models.TaskBatchResponse{Results: make([]models.TaskBatchResult, len(ids))}
+	for i, id := range ids {
+		switch {
+		case completed[id]:
+			response.Results[i] = models.TaskBatchResult{Status: http.StatusOK, ID: id}
+			response.Succeeded++
+		case existing[id]:
+			response.Results[i] = models.TaskBatchResult{Status: http.StatusConflict, ID: id, Error: "Task is not leased by this worker"}
+			response.Failed++
+		default:
+			response.Results[i] = models.TaskBatchResult{Status: http.StatusNotFound, ID: id, Error: "Task not found"}
+			response.Failed++
+		}
+	}
+
+	status := http.StatusOK
+	if response.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	writeBatchResponse(w, status, response)
+}
+
+// existingTasks returns which of the given tasks exist
+func existingTasks(ctx context.Context, tx *sql.Tx, ids []int64) (map[int64]bool, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM tasks WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := map[int64]bool{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		existing[id] = true
+	}
+	return existing, rows.Err()
+}
+
+// decodeBatch reads the create requests of a batch, either a JSON array or
+// newline-delimited JSON. It stops reading once the batch exceeds limit and
+// returns errBatchTooLarge. Other errors are of type store.ValidationError.
+func decodeBatch(r *http.Request, limit int) ([]models.CreateTaskRequest, error) {
+	ndjson := strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-ndjson")
+	dec := json.NewDecoder(r.Body)
+
+	if !ndjson {
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+			return nil, store.ValidationError("Batch must be a JSON array of tasks")
+		}
+	}
+
+	var reqs []models.CreateTaskRequest
+	for ndjson || dec.More() {
+		var req models.CreateTaskRequest
+		err := dec.Decode(&req)
+		if ndjson && err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, store.ValidationError(fmt.Sprintf("Invalid task at position %d", len(reqs)+1))
+		}
+
+		reqs = append(reqs, req)
+		if len(reqs) > limit {
+			return nil, errBatchTooLarge
+		}
+	}
+
+	// A truncated array ends without its closing bracket
+	if !ndjson {
+		if tok, err := dec.Token(); err != nil || tok != json.Delim(']') {
+			return nil, store.ValidationError("Batch must be a JSON array of tasks")
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return nil, store.ValidationError("Unexpected data after the batch")
+		}
+	}
+	return reqs, nil
+}
+
+// rolledBack reports an atomic batch in which some tasks failed. Nothing is
+// kept, so the tasks that did not fail are reported as not created either.
+func rolledBack(response models.TaskBatchResponse) models.TaskBatchResponse {
+	for i, result := range response.Results {
+		if result.Error == "" {
+			response.Results[i] = models.TaskBatchResult{Status: http.StatusFailedDependency, Error: "Not created because another task in the batch failed"}
+		}
+	}
+	response.Succeeded = 0
+	response.Failed = len(response.Results)
+	return response
+}
+
+// writeBatchResponse responds with the results of a batch create
+func writeBatchResponse(w http.ResponseWriter, status int, response models.TaskBatchResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
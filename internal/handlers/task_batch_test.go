@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/queuet/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskHandler_CreateTaskBatch(t *testing.T) {
+	allocateQuery := `SELECT nextval\(pg_get_serial_sequence\('tasks', 'id'\)\) FROM generate_series\(1, \$1\)`
+	insertQuery := `INSERT INTO tasks \(id, title, description, payload, status, queue, priority, max_attempts, run_at, created_at, updated_at, batch_id, timeout_seconds\) SELECT (.+) FROM unnest`
+
+	expectInsert := func(mock sqlmock.Sqlmock, ids ...int64) {
+		rows := sqlmock.NewRows([]string{"nextval"})
+		for _, id := range ids {
+			rows.AddRow(id)
+		}
+		mock.ExpectQuery(allocateQuery).WithArgs(len(ids)).WillReturnRows(rows)
+		mock.ExpectExec(insertQuery).
+			WithArgs("pending", 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, int64(len(ids))))
+	}
+
+	tests := []struct {
+		name           string
+		url            string
+		contentType    string
+		payload        string
+		expectedStatus int
+		expectedBody   string
+		mockDB         func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:           "JSON array",
+			url:            "/tasks/batch",
+			contentType:    "application/json",
+			payload:        `[{"title": "First"}, {"title": "Second", "queue": "emails"}]`,
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"succeeded":2,"failed":0,"results":[{"status":201,"id":10},{"status":201,"id":11}]}`,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectInsert(mock, 10, 11)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "NDJSON stream",
+			url:            "/tasks/batch",
+			contentType:    "application/x-ndjson",
+			payload:        "{\"title\": \"First\"}\n{\"title\": \"Second\"}\n",
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"succeeded":2,"failed":0,"results":[{"status":201,"id":10},{"status":201,"id":11}]}`,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectInsert(mock, 10, 11)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Partial success",
+			url:            "/tasks/batch",
+			contentType:    "application/json",
+			payload:        `[{"title": "First"}, {"title": ""}, {"title": "Third"}]`,
+			expectedStatus: http.StatusMultiStatus,
+			expectedBody:   `{"succeeded":2,"failed":1,"results":[{"status":201,"id":10},{"status":400,"error":"Title is required"},{"status":201,"id":11}]}`,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectInsert(mock, 10, 11)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Task with a unique key",
+			url:            "/tasks/batch",
+			contentType:    "application/json",
+			payload:        `[{"title": "First"}, {"title": "Sync", "unique_key": "sync"}]`,
+			expectedStatus: http.StatusMultiStatus,
+			expectedBody:   `{"succeeded":1,"failed":1,"results":[{"status":201,"id":10},{"status":409,"error":"A task with this unique key is already pending or in progress"}]}`,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectInsert(mock, 10)
+				mock.ExpectQuery(`INSERT INTO tasks \(title`).
+					WithArgs("Sync", "", sql.NullString{}, "pending", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{String: "sync", Valid: true}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Atomic batch with an invalid task",
+			url:            "/tasks/batch?atomic=true",
+			contentType:    "application/json",
+			payload:        `[{"title": "First"}, {"title": ""}]`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"succeeded":0,"failed":2,"results":[{"status":424,"error":"Not created because another task in the batch failed"},{"status":400,"error":"Title is required"}]}`,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Atomic batch with a conflict",
+			url:            "/tasks/batch?atomic=true",
+			contentType:    "application/json",
+			payload:        `[{"title": "First"}, {"title": "Sync", "unique_key": "sync"}]`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"succeeded":0,"failed":2,"results":[{"status":424,"error":"Not created because another task in the batch failed"},{"status":409,"error":"A task with this unique key is already pending or in progress"}]}`,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectInsert(mock, 10)
+				mock.ExpectQuery(`INSERT INTO tasks \(title`).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Payload too large",
+			url:            "/tasks/batch",
+			contentType:    "application/json",
+			payload:        `[{"title": "First", "payload": {"data": "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}}, {"title": "Second"}]`,
+			expectedStatus: http.StatusMultiStatus,
+			expectedBody:   `{"succeeded":1,"failed":1,"results":[{"status":413,"error":"Payload too large"},{"status":201,"id":10}]}`,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectInsert(mock, 10)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Too many tasks",
+			url:            "/tasks/batch",
+			contentType:    "application/json",
+			payload:        `[{"title": "1"}, {"title": "2"}, {"title": "3"}, {"title": "4"}]`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Empty batch",
+			url:            "/tasks/batch",
+			contentType:    "application/json",
+			payload:        `[]`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Not an array",
+			url:            "/tasks/batch",
+			contentType:    "application/json",
+			payload:        `{"title": "First"}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Truncated array",
+			url:            "/tasks/batch",
+			contentType:    "application/json",
+			payload:        `[{"title": "First"}, {"title": "Second"}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Data after the array",
+			url:            "/tasks/batch",
+			contentType:    "application/json",
+			payload:        `[{"title": "First"}] {"title": "Second"}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Invalid JSON",
+			url:            "/tasks/batch",
+			contentType:    "application/x-ndjson",
+			payload:        "{\"title\": \"First\"}\n{invalid}\n",
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Database error",
+			url:            "/tasks/batch",
+			contentType:    "application/json",
+			payload:        `[{"title": "First"}]`,
+			expectedStatus: http.StatusInternalServerError,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(allocateQuery).WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, mock := setupTestHandler(t)
+			tt.mockDB(mock)
+
+			req := httptest.NewRequest("POST", tt.url, bytes.NewBufferString(tt.payload))
+			req.Header.Set("Content-Type", tt.contentType)
+			rr := httptest.NewRecorder()
+
+			handler.CreateTaskBatch(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, rr.Body.String())
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestTaskHandler_CompleteTaskBatch(t *testing.T) {
+	completeQuery := `UPDATE tasks SET status = \$1, result = completed.task_result::jsonb, lease_owner = NULL, lease_expires_at = NULL, updated_at = \$2 FROM unnest\(\$3::bigint\[\], \$4::text\[\]\) AS completed\(task_id, task_result\) WHERE tasks.id = completed.task_id AND tasks.status = \$5 AND tasks.lease_owner = \$6 RETURNING ` + regexp.QuoteMeta(store.TaskColumns)
+	existingQuery := `SELECT id FROM tasks WHERE id = ANY\(\$1\)`
+
+	tests := []struct {
+		name           string
+		payload        string
+		expectedStatus int
+		expectedBody   string
+		mockDB         func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:           "Every task completed",
+			payload:        `{"worker_id": "worker-1", "tasks": [{"id": 1, "result": {"sent": true}}, {"id": 2}]}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"succeeded":2,"failed":0,"results":[{"status":200,"id":1},{"status":200,"id":2}]}`,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(completeQuery).
+					WithArgs("completed", sqlmock.AnyArg(), pq.Array([]int64{1, 2}), pq.Array([]sql.NullString{{String: `{"sent": true}`, Valid: true}, {}}), "in_progress", "worker-1").
+					WillReturnRows(addTaskRow(addTaskRow(sqlmock.NewRows(taskRowColumns), 2, "Second", "", "completed"), 1, "First", "", "completed"))
+				expectDependents(mock, 2)
+				expectDependents(mock, 1, 5)
+				mock.ExpectQuery(`UPDATE tasks SET status = \$1, updated_at = \$2 WHERE id = ANY\(\$3\)`).
+					WithArgs("pending", sqlmock.AnyArg(), pq.Array([]int64{5}), "completed").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Lost and missing tasks",
+			payload:        `{"worker_id": "worker-1", "tasks": [{"id": 1}, {"id": 2}, {"id": 3}]}`,
+			expectedStatus: http.StatusMultiStatus,
+			expectedBody:   `{"succeeded":1,"failed":2,"results":[{"status":200,"id":1},{"status":409,"id":2,"error":"Task is not leased by this worker"},{"status":404,"id":3,"error":"Task not found"}]}`,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(completeQuery).
+					WithArgs("completed", sqlmock.AnyArg(), pq.Array([]int64{1, 2, 3}), sqlmock.AnyArg(), "in_progress", "worker-1").
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "First", "", "completed"))
+				expectDependents(mock, 1)
+				mock.ExpectQuery(existingQuery).
+					WithArgs(pq.Array([]int64{1, 2, 3})).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Database error",
+			payload:        `{"worker_id": "worker-1", "tasks": [{"id": 1}]}`,
+			expectedStatus: http.StatusInternalServerError,
+			mockDB: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(completeQuery).WillReturnError(errors.New("connection reset"))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Task listed twice",
+			payload:        `{"worker_id": "worker-1", "tasks": [{"id": 1}, {"id": 1}]}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Too many tasks",
+			payload:        `{"worker_id": "worker-1", "tasks": [{"id": 1}, {"id": 2}, {"id": 3}, {"id": 4}]}`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Empty batch",
+			payload:        `{"worker_id": "worker-1", "tasks": []}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name:           "Missing worker ID",
+			payload:        `{"tasks": [{"id": 1}]}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func(mock sqlmock.Sqlmock) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, mock := setupTestHandler(t)
+			tt.mockDB(mock)
+
+			req := httptest.NewRequest("POST", "/tasks/complete", bytes.NewBufferString(tt.payload))
+			rr := httptest.NewRecorder()
+
+			handler.CompleteTaskBatch(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, rr.Body.String())
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -5,12 +5,17 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
 	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -21,15 +26,36 @@ type RedisClient interface {
 	Del(ctx context.Context, keys ...string) *redis.IntCmd
 }
 
+// RateLimiter is an interface for the token buckets that rate limit queues
+type RateLimiter interface {
+	Peek(ctx context.Context, key string, limit int, period time.Duration) (bool, time.Duration, error)
+	Take(ctx context.Context, key string, limit int, period time.Duration) (bool, time.Duration, error)
+}
+
+// TaskNotifier lets claims wait for tasks, which the database announces as
+// they become claimable
+type TaskNotifier interface {
+	Wait(queues []string) (ready <-chan struct{}, stop func())
+	Done() <-chan struct{}
+}
+
 type TaskHandler struct {
-	db    *sql.DB
-	cache RedisClient
+	db       *sql.DB
+	cache    RedisClient
+	limiter  RateLimiter
+	notifier TaskNotifier
+	config   *Config
 }
 
-func NewTaskHandler(db *sql.DB, cache RedisClient) *TaskHandler {
+// NewTaskHandler creates a task handler. A nil limiter disables queue rate
+// limits, and a nil notifier makes claims return at once instead of waiting.
+func NewTaskHandler(db *sql.DB, cache RedisClient, limiter RateLimiter, notifier TaskNotifier, config *Config) *TaskHandler {
 	return &TaskHandler{
-		db:    db,
-		cache: cache,
+		db:       db,
+		cache:    cache,
+		limiter:  limiter,
+		notifier: notifier,
+		config:   config,
 	}
 }
 
@@ -40,37 +66,136 @@ func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate required fields
-	if req.Title == "" {
-		http.Error(w, "Title is required", http.StatusBadRequest)
+	if len(req.Payload) > h.config.MaxPayloadBytes {
+		http.Error(w, "Payload too large", http.StatusRequestEntityTooLarge)
 		return
 	}
 
-	// Insert task into database
-	query := `
-		INSERT INTO tasks (title, description, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $4)
-		RETURNING id`
-
-	var taskID int64
 	now := time.Now()
-	err := h.db.QueryRow(
-		query,
-		req.Title,
-		req.Description,
-		"pending", // Default status
-		now,
-	).Scan(&taskID)
+	task, err := store.NewTaskFromRequest(req, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		h.createTaskIdempotently(w, r, key, req, task, now)
+		return
+	}
+
+	if task.UniqueKey == "" && len(task.DependsOn) == 0 {
+		// Insert task into database
+		taskID, err := store.InsertTask(r.Context(), h.db, task, now)
+		if err != nil {
+			http.Error(w, "Failed to create task", http.StatusInternalServerError)
+			return
+		}
+
+		// Return the created task ID
+		writeTaskID(w, http.StatusCreated, taskID)
+		return
+	}
 
+	// Replacing a task holding the unique key, and locking dependencies,
+	// take a transaction
+	tx, err := h.db.BeginTx(r.Context(), nil)
 	if err != nil {
 		http.Error(w, "Failed to create task", http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	// Return the created task ID
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]int64{"id": taskID})
+	taskID, status, err := h.insertTask(r.Context(), tx, task, now)
+	if err != nil {
+		insertTaskError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create task", http.StatusInternalServerError)
+		return
+	}
+
+	writeTaskID(w, status, taskID)
+}
+
+// insertTask inserts a task within tx, applying its unique policy when
+// another pending, in-progress or blocked task holds its unique key. It
+// returns the new task's ID with 201 Created, or under return_existing the
+// holder's ID with 200 OK. store.ErrUniqueKeyTaken is returned under reject,
+// and under replace when the holder is already in progress. A replaced task
+// is cancelled, and the tasks depending on it wait for its replacement
+// instead.
+func (h *TaskHandler) insertTask(ctx context.Context, tx *sql.Tx, task store.NewTask, now time.Time) (int64, int, error) {
+	taskID, err := store.InsertTask(ctx, tx, task, now)
+	if err != store.ErrUniqueKeyTaken {
+		return taskID, http.StatusCreated, err
+	}
+
+	switch task.UniquePolicy {
+	case models.UniquePolicyReturnExisting:
+		query := `
+			SELECT id
+			FROM tasks
+			WHERE unique_key = $1 AND status IN ($2, $3, $4)`
+
+		err := tx.QueryRowContext(ctx, query, task.UniqueKey, models.TaskStatusPending, models.TaskStatusInProgress, models.TaskStatusBlocked).Scan(&taskID)
+		if err == sql.ErrNoRows {
+			// The holder finished after the insert was attempted
+			taskID, err = store.InsertTask(ctx, tx, task, now)
+			return taskID, http.StatusCreated, err
+		}
+		return taskID, http.StatusOK, err
+
+	case models.UniquePolicyReplace:
+		query := `
+			UPDATE tasks
+			SET status = $1,
+				updated_at = $2
+			WHERE unique_key = $3 AND status IN ($4, $5)
+			RETURNING id`
+
+		var replacedID int64
+		err := tx.QueryRowContext(ctx, query, models.TaskStatusCancelled, now, task.UniqueKey, models.TaskStatusPending, models.TaskStatusBlocked).Scan(&replacedID)
+		if err == sql.ErrNoRows {
+			// Fails again if the holder is in progress
+			taskID, err = store.InsertTask(ctx, tx, task, now)
+			return taskID, http.StatusCreated, err
+		} else if err != nil {
+			return 0, 0, err
+		}
+		h.cache.Del(ctx, fmt.Sprintf("task:%d", replacedID))
+
+		taskID, err = store.InsertTask(ctx, tx, task, now)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		query = `
+			UPDATE task_dependencies
+			SET depends_on_id = $1
+			WHERE depends_on_id = $2`
+
+		if _, err := tx.ExecContext(ctx, query, taskID, replacedID); err != nil {
+			return 0, 0, err
+		}
+		return taskID, http.StatusCreated, nil
+	}
+
+	return 0, 0, store.ErrUniqueKeyTaken
+}
+
+// insertTaskError responds to an error from insertTask
+func insertTaskError(w http.ResponseWriter, err error) {
+	if err == store.ErrUniqueKeyTaken {
+		http.Error(w, "A task with this unique key is already pending or in progress", http.StatusConflict)
+		return
+	}
+	if _, ok := err.(store.ValidationError); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "Failed to create task", http.StatusInternalServerError)
 }
 
 func (h *TaskHandler) GetTask(w http.ResponseWriter, r *http.Request) {
@@ -94,19 +219,11 @@ func (h *TaskHandler) GetTask(w http.ResponseWriter, r *http.Request) {
 
 	// Cache miss, get from database
 	query := `
-		SELECT id, title, description, status, created_at, updated_at
+		SELECT ` + store.TaskColumns + `
 		FROM tasks
 		WHERE id = $1`
 
-	var task models.Task
-	err = h.db.QueryRow(query, taskID).Scan(
-		&task.ID,
-		&task.Title,
-		&task.Description,
-		&task.Status,
-		&task.CreatedAt,
-		&task.UpdatedAt,
-	)
+	task, err := store.ScanTask(h.db.QueryRow(query, taskID))
 
 	if err == sql.ErrNoRows {
 		http.Error(w, "Task not found", http.StatusNotFound)
@@ -142,53 +259,102 @@ func (h *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid status value", http.StatusBadRequest)
 		return
 	}
+	if len(req.Payload) > h.config.MaxPayloadBytes {
+		http.Error(w, "Payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
 
 	// Update task in database
 	query := `
 		UPDATE tasks
 		SET title = COALESCE($1, title),
 			description = COALESCE($2, description),
-			status = COALESCE($3, status),
-			updated_at = $4
-		WHERE id = $5
-		RETURNING id, title, description, status, created_at, updated_at`
+			payload = COALESCE($3, payload),
+			status = COALESCE($4, status),
+			priority = COALESCE($5, priority),
+			updated_at = $6
+		WHERE id = $7
+		RETURNING ` + store.TaskColumns
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "Failed to update task", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Blocked, dead and cancelled tasks only leave their status through
+	// their dependencies, a replay or a cancellation, which check unique
+	// keys and dependencies
+	if req.Status != "" {
+		current, err := store.LockTask(ctx, tx, taskID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Task not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to get task", http.StatusInternalServerError)
+			return
+		}
+		switch current.Status {
+		case models.TaskStatusBlocked, models.TaskStatusDead, models.TaskStatusCancelled:
+			http.Error(w, fmt.Sprintf("Status of a %s task cannot be changed", current.Status), http.StatusConflict)
+			return
+		}
+	}
 
-	var task models.Task
 	now := time.Now()
-	err = h.db.QueryRow(
+	task, err := store.ScanTask(tx.QueryRowContext(
+		ctx,
 		query,
 		sql.NullString{String: req.Title, Valid: req.Title != ""},
 		sql.NullString{String: req.Description, Valid: req.Description != ""},
+		store.NullJSON(req.Payload),
 		sql.NullString{String: req.Status, Valid: req.Status != ""},
+		nullInt(req.Priority),
 		now,
 		taskID,
-	).Scan(
-		&task.ID,
-		&task.Title,
-		&task.Description,
-		&task.Status,
-		&task.CreatedAt,
-		&task.UpdatedAt,
-	)
+	))
 
 	if err == sql.ErrNoRows {
 		http.Error(w, "Task not found", http.StatusNotFound)
 		return
+	} else if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+		// e.g. a failed task made pending while another holds its key
+		insertTaskError(w, store.ErrUniqueKeyTaken)
+		return
 	} else if err != nil {
 		http.Error(w, "Failed to update task", http.StatusInternalServerError)
 		return
 	}
 
+	// Completing a task by hand releases its dependents too
+	var released []int64
+	if req.Status != "" {
+		if released, err = store.ReleaseDependents(ctx, tx, task, now); err != nil {
+			http.Error(w, "Failed to update task", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update task", http.StatusInternalServerError)
+		return
+	}
+
 	// Update cache
-	ctx := r.Context()
 	cacheKey := fmt.Sprintf("task:%d", taskID)
 	taskJSON, _ := json.Marshal(task)
 	h.cache.Set(ctx, cacheKey, taskJSON, time.Hour)
+	h.uncacheTasks(ctx, released)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(task)
 }
 
+// DeleteTask deletes a task. Its blocked dependents can no longer be
+// released by it, so they are cancelled as if it had been cancelled, unless
+// it had already completed.
 func (h *TaskHandler) DeleteTask(w http.ResponseWriter, r *http.Request) {
 	taskID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
@@ -196,35 +362,149 @@ func (h *TaskHandler) DeleteTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete task from database
-	query := `DELETE FROM tasks WHERE id = $1`
-	result, err := h.db.Exec(query, taskID)
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
 	if err != nil {
 		http.Error(w, "Failed to delete task", http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		http.Error(w, "Failed to get rows affected", http.StatusInternalServerError)
+	task, err := store.LockTask(ctx, tx, taskID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to get task", http.StatusInternalServerError)
 		return
 	}
 
-	if rowsAffected == 0 {
-		http.Error(w, "Task not found", http.StatusNotFound)
+	// The dependency rows go with the task, so dependents are released first
+	var cancelled []int64
+	if task.Status != models.TaskStatusCompleted {
+		task.Status = models.TaskStatusCancelled
+		cancelled, err = store.ReleaseDependents(ctx, tx, task, time.Now())
+		if err != nil {
+			http.Error(w, "Failed to delete task", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Delete task from database
+	query := `DELETE FROM tasks WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, taskID); err != nil {
+		http.Error(w, "Failed to delete task", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to delete task", http.StatusInternalServerError)
 		return
 	}
 
 	// Delete from cache
-	ctx := r.Context()
 	cacheKey := fmt.Sprintf("task:%d", taskID)
 	h.cache.Del(ctx, cacheKey)
+	h.uncacheTasks(ctx, cancelled)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// ListTasks lists tasks, newest first. Pass queue to list a single queue,
+// scheduled=true to only list pending tasks whose run_at is still in the
+// future, priority or min_priority to filter by priority, and sort=priority
+// to list the highest priority tasks first.
 func (h *TaskHandler) ListTasks(w http.ResponseWriter, r *http.Request) {
-	// Get pagination parameters
+	pageSize, offset := pagination(r)
+	params := r.URL.Query()
+
+	var filter taskFilter
+	if queue := params.Get("queue"); queue != "" {
+		filter.add("queue = $%d", queue)
+	}
+	if batchIDStr := params.Get("batch_id"); batchIDStr != "" {
+		batchID, err := strconv.ParseInt(batchIDStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid batch_id", http.StatusBadRequest)
+			return
+		}
+		filter.add("batch_id = $%d", batchID)
+	}
+	if scheduled, _ := strconv.ParseBool(params.Get("scheduled")); scheduled {
+		filter.add("status = $%d", models.TaskStatusPending)
+		filter.add("run_at > $%d", time.Now())
+	}
+	if priorityStr := params.Get("priority"); priorityStr != "" {
+		priority, err := strconv.Atoi(priorityStr)
+		if err != nil {
+			http.Error(w, "Invalid priority", http.StatusBadRequest)
+			return
+		}
+		filter.add("priority = $%d", priority)
+	}
+	if minPriorityStr := params.Get("min_priority"); minPriorityStr != "" {
+		minPriority, err := strconv.Atoi(minPriorityStr)
+		if err != nil {
+			http.Error(w, "Invalid min_priority", http.StatusBadRequest)
+			return
+		}
+		filter.add("priority >= $%d", minPriority)
+	}
+
+	orderBy := "created_at DESC"
+	switch params.Get("sort") {
+	case "", "created_at":
+	case "priority":
+		orderBy = "priority DESC, created_at DESC"
+	default:
+		http.Error(w, "Invalid sort value", http.StatusBadRequest)
+		return
+	}
+
+	// Get tasks from database with pagination
+	query := `
+		SELECT ` + store.TaskColumns + `
+		FROM tasks` + filter.where() + `
+		ORDER BY ` + orderBy + `
+		LIMIT ` + filter.next(pageSize) + ` OFFSET ` + filter.next(offset)
+
+	h.writeTasks(w, query, filter.args...)
+}
+
+// taskFilter accumulates WHERE conditions and their positional arguments
+type taskFilter struct {
+	conditions []string
+	args       []interface{}
+}
+
+// add appends a condition whose %d verb is replaced by the argument's
+// placeholder number
+func (f *taskFilter) add(condition string, arg interface{}) {
+	f.conditions = append(f.conditions, fmt.Sprintf(condition, f.placeholder(arg)))
+}
+
+// next appends an argument and returns its placeholder, e.g. "$3"
+func (f *taskFilter) next(arg interface{}) string {
+	return fmt.Sprintf("$%d", f.placeholder(arg))
+}
+
+func (f *taskFilter) placeholder(arg interface{}) int {
+	f.args = append(f.args, arg)
+	return len(f.args)
+}
+
+// where renders the accumulated conditions as a WHERE clause
+func (f *taskFilter) where() string {
+	if len(f.conditions) == 0 {
+		return ""
+	}
+	return `
+		WHERE ` + strings.Join(f.conditions, " AND ")
+}
+
+// pagination reads the page and size query parameters and returns the
+// matching LIMIT and OFFSET
+func pagination(r *http.Request) (int, int) {
 	page := 1
 	pageSize := 10
 
@@ -239,16 +519,13 @@ func (h *TaskHandler) ListTasks(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	offset := (page - 1) * pageSize
-
-	// Get tasks from database with pagination
-	query := `
-		SELECT id, title, description, status, created_at, updated_at
-		FROM tasks
-		ORDER BY created_at DESC
-		LIMIT $1 OFFSET $2`
+	return pageSize, (page - 1) * pageSize
+}
 
-	rows, err := h.db.Query(query, pageSize, offset)
+// writeTasks runs a query selecting store.TaskColumns and writes the tasks
+// as a JSON array
+func (h *TaskHandler) writeTasks(w http.ResponseWriter, query string, args ...interface{}) {
+	rows, err := h.db.Query(query, args...)
 	if err != nil {
 		http.Error(w, "Failed to list tasks", http.StatusInternalServerError)
 		return
@@ -257,15 +534,7 @@ func (h *TaskHandler) ListTasks(w http.ResponseWriter, r *http.Request) {
 
 	var tasks []models.Task
 	for rows.Next() {
-		var task models.Task
-		err := rows.Scan(
-			&task.ID,
-			&task.Title,
-			&task.Description,
-			&task.Status,
-			&task.CreatedAt,
-			&task.UpdatedAt,
-		)
+		task, err := store.ScanTask(rows)
 		if err != nil {
 			http.Error(w, "Failed to scan task", http.StatusInternalServerError)
 			return
@@ -281,3 +550,768 @@ func (h *TaskHandler) ListTasks(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(tasks)
 }
+
+// ClaimTask leases the highest priority pending task to the calling worker,
+// taking the one that has been due the longest among equal priorities. Tasks
+// scheduled for the future and tasks on paused queues are skipped. The row is
+// selected with FOR UPDATE SKIP LOCKED so concurrent claims never receive the
+// same task. Queues at their max_in_flight limit are skipped as well. Responds
+// with 204 when no task is available, adding Retry-After when the only tasks
+// left are held back by a limit. A worker that was marked dead is alive again
+// once it leases a task.
+//
+// With ?wait=N the request is held for up to N seconds, capped by
+// MaxClaimWait, until a task becomes claimable on one of the worker's queues.
+// The claim is retried when a notification arrives, and every
+// ClaimRecheckInterval for tasks that become due without one, such as
+// delayed tasks. Waiting ends early when the request's context is done or the
+// server shuts down.
+//
+// With ?max=N up to N tasks, capped by MaxClaimBatch, are leased at once and
+// the response is an array of them. Rate limited queues hand out at most as
+// many tasks as their buckets hold tokens.
+func (h *TaskHandler) ClaimTask(w http.ResponseWriter, r *http.Request) {
+	var req models.ClaimTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.WorkerID == "" {
+		http.Error(w, "Worker ID is required", http.StatusBadRequest)
+		return
+	}
+	if req.LeaseSeconds < 0 {
+		http.Error(w, "Lease seconds must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	for _, queue := range req.Queues {
+		if err := store.ValidateQueueName(queue); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	wait, err := claimWait(r, h.config.MaxClaimWait)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	max, batch, err := claimMax(r, h.config.MaxClaimBatch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	deadline := time.Now().Add(wait)
+	// Leave a second to claim and respond before the request times out
+	if d, ok := ctx.Deadline(); ok && d.Add(-time.Second).Before(deadline) {
+		deadline = d.Add(-time.Second)
+	}
+
+	for {
+		// Waiting starts before claiming so that a task created in between
+		// still wakes the claim
+		var ready <-chan struct{}
+		stop := func() {}
+		if h.notifier != nil && time.Now().Before(deadline) {
+			ready, stop = h.notifier.Wait(req.Queues)
+		}
+
+		tasks, retryAfter, err := h.claim(ctx, req, max)
+		if err != nil {
+			stop()
+			http.Error(w, "Failed to claim task", http.StatusInternalServerError)
+			return
+		}
+		if len(tasks) > 0 {
+			stop()
+			h.writeClaimedTasks(w, r, tasks, batch)
+			return
+		}
+
+		remaining := time.Until(deadline)
+		if ready == nil || retryAfter > 0 || remaining <= 0 {
+			stop()
+			setRetryAfter(w, retryAfter)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if remaining > h.config.ClaimRecheckInterval {
+			remaining = h.config.ClaimRecheckInterval
+		}
+		woken := h.waitForTask(ctx, ready, remaining)
+		stop()
+		if !woken {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+}
+
+// claim leases up to max tasks in its own transaction, highest priority
+// first. It returns no tasks when none is available, along with how long to
+// wait when the only tasks left are held back by a limit.
+func (h *TaskHandler) claim(ctx context.Context, req models.ClaimTaskRequest, max int) ([]models.Task, time.Duration, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	limited, err := lockLimitedQueues(ctx, tx, req.Queues, now)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rateLimits, throttled, retryAfter, err := h.throttledQueues(ctx, tx, req.Queues)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Candidates are locked in one statement; those beyond the free slots
+	// of a queue with max_in_flight are left for other claims. Limited queues
+	// that were not locked are skipped, since their count is not protected.
+	query := `
+		WITH candidates AS (
+			SELECT id, queue, priority, run_at
+			FROM tasks
+			WHERE status = $7 AND run_at <= $3
+				AND (COALESCE(cardinality($8::text[]), 0) = 0 OR queue = ANY($8))
+				AND (COALESCE(cardinality($9::text[]), 0) = 0 OR queue <> ALL($9))
+				AND NOT EXISTS (
+					SELECT 1
+					FROM queues
+					WHERE name = tasks.queue
+						AND (paused OR max_in_flight <= (
+							SELECT count(*) FROM tasks running WHERE running.queue = queues.name AND running.status = $1
+						) OR (max_in_flight IS NOT NULL AND NOT COALESCE(name = ANY($11::text[]), false)))
+				)
+			ORDER BY priority DESC, run_at, id
+			LIMIT $10
+			FOR UPDATE SKIP LOCKED
+		), ranked AS (
+			SELECT id, queue, row_number() OVER (PARTITION BY queue ORDER BY priority DESC, run_at, id) AS rank
+			FROM candidates
+		)
+		UPDATE tasks
+		SET status = $1,
+			lease_owner = $2,
+			lease_expires_at = ` + leaseExpiry("$3", "$4", "$5", "$6") + `,
+			progress = NULL,
+			progress_message = NULL,
+			deadline_at = $3 + timeout_seconds * interval '1 second',
+			updated_at = $3
+		WHERE id IN (
+			SELECT ranked.id
+			FROM ranked
+			LEFT JOIN queues ON queues.name = ranked.queue
+			WHERE queues.max_in_flight IS NULL OR ranked.rank <= queues.max_in_flight - (
+				SELECT count(*) FROM tasks running WHERE running.queue = ranked.queue AND running.status = $1
+			)
+		)
+		RETURNING ` + store.TaskColumns
+
+	rows, err := tx.QueryContext(
+		ctx,
+		query,
+		models.TaskStatusInProgress,
+		req.WorkerID,
+		now,
+		leaseSeconds(req.LeaseSeconds),
+		int64(h.config.DefaultLease/time.Second),
+		int64(h.config.MaxLease/time.Second),
+		models.TaskStatusPending,
+		pq.Array(req.Queues),
+		pq.Array(throttled),
+		max,
+		pq.Array(limited),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	tasks, err := store.ScanTasks(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(tasks) == 0 {
+		if len(limited) > 0 {
+			saturated, err := saturatedQueueWaiting(ctx, tx, limited, now)
+			if err != nil {
+				return nil, 0, err
+			}
+			if saturated && (retryAfter == 0 || h.config.ClaimRetryAfter < retryAfter) {
+				retryAfter = h.config.ClaimRetryAfter
+			}
+		}
+		return nil, retryAfter, nil
+	}
+
+	// RETURNING has no order
+	sort.Slice(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if !a.RunAt.Equal(b.RunAt) {
+			return a.RunAt.Before(b.RunAt)
+		}
+		return a.ID < b.ID
+	})
+
+	// The buckets may have been drained by other claims since they were
+	// checked. Tasks of a queue past its last token go back to pending.
+	var claimed []models.Task
+	var unclaimed []int64
+	denied := map[string]bool{}
+	var wait time.Duration
+	for _, task := range tasks {
+		if limit, ok := rateLimits[task.Queue]; ok && !denied[task.Queue] {
+			allowed, retry, err := h.limiter.Take(ctx, limit.key(), limit.limit, limit.period)
+			if err != nil {
+				log.Printf("Error rate limiting queue %s: %v", task.Queue, err)
+			} else if !allowed {
+				denied[task.Queue] = true
+				if wait == 0 || retry < wait {
+					wait = retry
+				}
+			}
+		}
+		if denied[task.Queue] {
+			unclaimed = append(unclaimed, task.ID)
+			continue
+		}
+		claimed = append(claimed, task)
+	}
+
+	if len(claimed) == 0 {
+		return nil, wait, nil
+	}
+	if len(unclaimed) > 0 {
+		if err := unclaimTasks(ctx, tx, unclaimed); err != nil {
+			return nil, 0, err
+		}
+	}
+	if err := reviveWorker(ctx, tx, req.WorkerID, now); err != nil {
+		return nil, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, 0, err
+	}
+	return claimed, 0, nil
+}
+
+// reviveWorker marks a worker that was marked dead as alive again once it
+// leases tasks in tx. Otherwise the reaper would release them, costing an
+// attempt each, until the worker's next heartbeat.
+func reviveWorker(ctx context.Context, tx *sql.Tx, workerID string, now time.Time) error {
+	query := `
+		UPDATE workers
+		SET status = $1,
+			last_seen_at = $2,
+			updated_at = $2
+		WHERE id = $3 AND status = $4`
+
+	_, err := tx.ExecContext(ctx, query, models.WorkerStatusAlive, now, workerID, models.WorkerStatusDead)
+	return err
+}
+
+// unclaimTasks returns tasks leased earlier in tx to pending
+func unclaimTasks(ctx context.Context, tx *sql.Tx, ids []int64) error {
+	query := `
+		UPDATE tasks
+		SET status = $1,
+			lease_owner = NULL,
+			lease_expires_at = NULL,
+			deadline_at = NULL
+		WHERE id = ANY($2)`
+
+	_, err := tx.ExecContext(ctx, query, models.TaskStatusPending, pq.Array(ids))
+	return err
+}
+
+// writeClaimedTasks caches claimed tasks and writes them as the response,
+// as an array for batch claims and as the only task otherwise
+func (h *TaskHandler) writeClaimedTasks(w http.ResponseWriter, r *http.Request, tasks []models.Task, batch bool) {
+	for _, task := range tasks {
+		cacheKey := fmt.Sprintf("task:%d", task.ID)
+		taskJSON, _ := json.Marshal(task)
+		h.cache.Set(r.Context(), cacheKey, taskJSON, time.Hour)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if batch {
+		json.NewEncoder(w).Encode(tasks)
+		return
+	}
+	json.NewEncoder(w).Encode(tasks[0])
+}
+
+// Heartbeat extends the lease on a task held by the calling worker and
+// optionally records its progress. The update only applies while the caller
+// still owns the lease; otherwise the request is rejected with 409.
+func (h *TaskHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
+	taskID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.HeartbeatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.WorkerID == "" {
+		http.Error(w, "Worker ID is required", http.StatusBadRequest)
+		return
+	}
+	if req.LeaseSeconds < 0 {
+		http.Error(w, "Lease seconds must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.Progress != nil && (*req.Progress < 0 || *req.Progress > 100) {
+		http.Error(w, "Progress must be between 0 and 100", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		UPDATE tasks
+		SET lease_expires_at = ` + leaseExpiry("$4", "$1", "$8", "$9") + `,
+			progress = COALESCE($2, progress),
+			progress_message = COALESCE($3, progress_message),
+			updated_at = $4
+		WHERE id = $5 AND status = $6 AND lease_owner = $7
+		RETURNING ` + store.TaskColumns
+
+	var progress sql.NullInt64
+	if req.Progress != nil {
+		progress = sql.NullInt64{Int64: int64(*req.Progress), Valid: true}
+	}
+	var message sql.NullString
+	if req.Message != nil {
+		message = sql.NullString{String: *req.Message, Valid: true}
+	}
+
+	ctx := r.Context()
+	task, err := store.ScanTask(h.db.QueryRowContext(
+		ctx,
+		query,
+		leaseSeconds(req.LeaseSeconds),
+		progress,
+		message,
+		time.Now(),
+		taskID,
+		models.TaskStatusInProgress,
+		req.WorkerID,
+		int64(h.config.DefaultLease/time.Second),
+		int64(h.config.MaxLease/time.Second),
+	))
+
+	if err == sql.ErrNoRows {
+		h.leaseNotHeld(w, r, taskID)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to extend lease", http.StatusInternalServerError)
+		return
+	}
+
+	// Update cache
+	cacheKey := fmt.Sprintf("task:%d", taskID)
+	taskJSON, _ := json.Marshal(task)
+	h.cache.Set(ctx, cacheKey, taskJSON, time.Hour)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// CompleteTask marks a task leased by the calling worker as completed and
+// stores its optional JSON result
+func (h *TaskHandler) CompleteTask(w http.ResponseWriter, r *http.Request) {
+	taskID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.CompleteTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.WorkerID == "" {
+		http.Error(w, "Worker ID is required", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		UPDATE tasks
+		SET status = $1,
+			result = $2,
+			lease_owner = NULL,
+			lease_expires_at = NULL,
+			updated_at = $3
+		WHERE id = $4 AND status = $5 AND lease_owner = $6
+		RETURNING ` + store.TaskColumns
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "Failed to complete task", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	task, err := store.ScanTask(tx.QueryRowContext(
+		ctx,
+		query,
+		models.TaskStatusCompleted,
+		store.NullJSON(req.Result),
+		now,
+		taskID,
+		models.TaskStatusInProgress,
+		req.WorkerID,
+	))
+
+	if err == sql.ErrNoRows {
+		h.leaseNotHeld(w, r, taskID)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to complete task", http.StatusInternalServerError)
+		return
+	}
+
+	released, err := store.ReleaseDependents(ctx, tx, task, now)
+	if err != nil {
+		http.Error(w, "Failed to complete task", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to complete task", http.StatusInternalServerError)
+		return
+	}
+
+	// Update cache
+	cacheKey := fmt.Sprintf("task:%d", taskID)
+	taskJSON, _ := json.Marshal(task)
+	h.cache.Set(ctx, cacheKey, taskJSON, time.Hour)
+	h.uncacheTasks(ctx, released)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// FailTask records a failed attempt at a task leased by the calling worker.
+// The task is rescheduled with exponential backoff until it reaches its
+// max_attempts, after which it is marked failed.
+func (h *TaskHandler) FailTask(w http.ResponseWriter, r *http.Request) {
+	taskID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.FailTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.WorkerID == "" {
+		http.Error(w, "Worker ID is required", http.StatusBadRequest)
+		return
+	}
+	if req.Error == "" {
+		http.Error(w, "Error message is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "Failed to fail task", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	task, err := store.LockTask(ctx, tx, taskID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to get task", http.StatusInternalServerError)
+		return
+	}
+
+	if task.Status != models.TaskStatusInProgress || task.LeaseOwner == nil || *task.LeaseOwner != req.WorkerID {
+		http.Error(w, "Task is not leased by this worker", http.StatusConflict)
+		return
+	}
+
+	failure := store.Failure{
+		WorkerID: req.WorkerID,
+		Error:    req.Error,
+		Retry:    req.Retry == nil || *req.Retry,
+	}
+	now := time.Now()
+	task, err = store.FailTask(ctx, tx, task, failure, h.config.Backoff, now)
+	if err != nil {
+		http.Error(w, "Failed to fail task", http.StatusInternalServerError)
+		return
+	}
+
+	cancelled, err := store.ReleaseDependents(ctx, tx, task, now)
+	if err != nil {
+		http.Error(w, "Failed to fail task", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to fail task", http.StatusInternalServerError)
+		return
+	}
+
+	// Update cache
+	cacheKey := fmt.Sprintf("task:%d", taskID)
+	taskJSON, _ := json.Marshal(task)
+	h.cache.Set(ctx, cacheKey, taskJSON, time.Hour)
+	h.uncacheTasks(ctx, cancelled)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// uncacheTasks drops the cached copies of tasks updated as a side effect of
+// a request
+func (h *TaskHandler) uncacheTasks(ctx context.Context, ids []int64) {
+	if len(ids) == 0 {
+		return
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = fmt.Sprintf("task:%d", id)
+	}
+	h.cache.Del(ctx, keys...)
+}
+
+// leaseNotHeld responds to a lease-guarded update that matched no rows,
+// distinguishing a missing task (404) from one leased to someone else or no
+// longer in progress (409)
+func (h *TaskHandler) leaseNotHeld(w http.ResponseWriter, r *http.Request, taskID int64) {
+	var exists bool
+	err := h.db.QueryRowContext(r.Context(), `SELECT EXISTS(SELECT 1 FROM tasks WHERE id = $1)`, taskID).Scan(&exists)
+	if err != nil {
+		http.Error(w, "Failed to get task", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Task is not leased by this worker", http.StatusConflict)
+}
+
+// lockLimitedQueues locks the settings of the queues a claim may take from
+// that have a max_in_flight limit and tasks ready to claim, and returns their
+// names. Concurrent claims on those queues wait for each other, so the
+// in-flight count each one checks cannot change under it, while claims on
+// other queues are not held up by limited queues with nothing to claim. Rows
+// are locked in name order to avoid deadlocks.
+func lockLimitedQueues(ctx context.Context, tx *sql.Tx, queues []string, now time.Time) ([]string, error) {
+	query := `
+		SELECT name
+		FROM queues
+		WHERE max_in_flight IS NOT NULL AND NOT paused
+			AND (COALESCE(cardinality($1::text[]), 0) = 0 OR name = ANY($1))
+			AND EXISTS (SELECT 1 FROM tasks WHERE queue = queues.name AND status = $2 AND run_at <= $3)
+		ORDER BY name
+		FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, query, pq.Array(queues), models.TaskStatusPending, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// rateLimit is the token bucket limiting how fast a queue's tasks are claimed
+type rateLimit struct {
+	queue  string
+	limit  int
+	period time.Duration
+}
+
+// key is the Redis key of the queue's token bucket
+func (l rateLimit) key() string {
+	return "ratelimit:queue:" + l.queue
+}
+
+// throttledQueues returns the rate limits of the queues a claim may take
+// from, and which of them have no token left along with the shortest wait
+// until one of those has. Errors from the rate limiter are logged and the
+// queue is treated as not throttled, so claims keep working without Redis.
+func (h *TaskHandler) throttledQueues(ctx context.Context, tx *sql.Tx, queues []string) (map[string]rateLimit, []string, time.Duration, error) {
+	if h.limiter == nil {
+		return nil, nil, 0, nil
+	}
+
+	query := `
+		SELECT name, rate_limit, COALESCE(rate_period_seconds, 1)
+		FROM queues
+		WHERE rate_limit IS NOT NULL
+			AND (COALESCE(cardinality($1::text[]), 0) = 0 OR name = ANY($1))`
+
+	rows, err := tx.QueryContext(ctx, query, pq.Array(queues))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer rows.Close()
+
+	limits := map[string]rateLimit{}
+	for rows.Next() {
+		var limit rateLimit
+		var periodSeconds int
+		if err := rows.Scan(&limit.queue, &limit.limit, &periodSeconds); err != nil {
+			return nil, nil, 0, err
+		}
+		limit.period = time.Duration(periodSeconds) * time.Second
+		limits[limit.queue] = limit
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, 0, err
+	}
+
+	var throttled []string
+	var retryAfter time.Duration
+	for _, limit := range limits {
+		allowed, wait, err := h.limiter.Peek(ctx, limit.key(), limit.limit, limit.period)
+		if err != nil {
+			log.Printf("Error rate limiting queue %s: %v", limit.queue, err)
+			continue
+		}
+		if !allowed {
+			throttled = append(throttled, limit.queue)
+			if retryAfter == 0 || wait < retryAfter {
+				retryAfter = wait
+			}
+		}
+	}
+	return limits, throttled, retryAfter, nil
+}
+
+// claimWait parses the wait query parameter of a claim, capped at max
+func claimWait(r *http.Request, max time.Duration) (time.Duration, error) {
+	value := r.URL.Query().Get("wait")
+	if value == "" {
+		return 0, nil
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("Wait must be a non-negative number of seconds")
+	}
+	if wait := time.Duration(seconds) * time.Second; wait < max {
+		return wait, nil
+	}
+	return max, nil
+}
+
+// claimMax parses the max query parameter of a claim, capped at limit. It
+// reports whether max was given, in which case the claim is a batch claim.
+func claimMax(r *http.Request, limit int) (int, bool, error) {
+	value := r.URL.Query().Get("max")
+	if value == "" {
+		return 1, false, nil
+	}
+
+	max, err := strconv.Atoi(value)
+	if err != nil || max < 1 {
+		return 0, false, fmt.Errorf("Max must be a positive number of tasks")
+	}
+	if max > limit {
+		max = limit
+	}
+	return max, true, nil
+}
+
+// waitForTask waits for ready to be closed or for d to pass. It returns false
+// when the claim should stop waiting because the request is done or the
+// server is shutting down.
+func (h *TaskHandler) waitForTask(ctx context.Context, ready <-chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return true
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-h.notifier.Done():
+		return false
+	}
+}
+
+// setRetryAfter suggests retrying after d, rounded up to whole seconds. A
+// zero duration sets nothing.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	seconds := int((d + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// saturatedQueueWaiting reports whether one of the given queues is at its
+// max_in_flight limit while it has due tasks waiting
+func saturatedQueueWaiting(ctx context.Context, tx *sql.Tx, queues []string, now time.Time) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM queues
+			WHERE name = ANY($1) AND NOT paused
+				AND max_in_flight <= (SELECT count(*) FROM tasks WHERE queue = queues.name AND status = $2)
+				AND EXISTS (SELECT 1 FROM tasks WHERE queue = queues.name AND status = $3 AND run_at <= $4)
+		)`
+
+	var saturated bool
+	err := tx.QueryRowContext(ctx, query, pq.Array(queues), models.TaskStatusInProgress, models.TaskStatusPending, now).Scan(&saturated)
+	return saturated, err
+}
+
+// leaseSeconds converts the lease requested by a worker into a query
+// argument, leaving it NULL when the worker did not ask for one
+func leaseSeconds(seconds int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(seconds), Valid: seconds > 0}
+}
+
+// leaseExpiry renders the SQL expression for the end of a lease on a tasks
+// row that starts at the now placeholder. The lease requested by the worker
+// wins, then the lease_seconds of the task's queue, then the configured
+// default, all capped at the maximum lease.
+func leaseExpiry(now, requested, fallback, max string) string {
+	return now + `::timestamptz + make_interval(secs => LEAST(COALESCE(` + requested +
+		`, (SELECT lease_seconds FROM queues WHERE name = tasks.queue), ` + fallback + `), ` + max + `))`
+}
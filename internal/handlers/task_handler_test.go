@@ -3,9 +3,13 @@ package handlers
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
@@ -13,7 +17,9 @@ import (
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
 	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 )
@@ -46,7 +52,92 @@ func (m *redisMock) Del(ctx context.Context, keys ...string) *redis.IntCmd {
 	return redis.NewIntCmd(ctx)
 }
 
+// rateLimiterMock answers every Peek and Take with the configured results
+type rateLimiterMock struct {
+	peekAllowed bool
+	peekWait    time.Duration
+	peekErr     error
+	takeAllowed bool
+	takeWait    time.Duration
+	takeErr     error
+}
+
+func (m *rateLimiterMock) Peek(ctx context.Context, key string, limit int, period time.Duration) (bool, time.Duration, error) {
+	return m.peekAllowed, m.peekWait, m.peekErr
+}
+
+func (m *rateLimiterMock) Take(ctx context.Context, key string, limit int, period time.Duration) (bool, time.Duration, error) {
+	return m.takeAllowed, m.takeWait, m.takeErr
+}
+
+// notifierMock wakes waiting claims at once when ready is closed
+type notifierMock struct {
+	ready chan struct{}
+	done  chan struct{}
+	waits int
+}
+
+// newNotifierMock creates a notifier whose waits are woken at once, and
+// which is already closed, as requested
+func newNotifierMock(woken, closed bool) *notifierMock {
+	m := &notifierMock{ready: make(chan struct{}), done: make(chan struct{})}
+	if woken {
+		close(m.ready)
+	}
+	if closed {
+		close(m.done)
+	}
+	return m
+}
+
+func (m *notifierMock) Wait(queues []string) (<-chan struct{}, func()) {
+	m.waits++
+	return m.ready, func() {}
+}
+
+func (m *notifierMock) Done() <-chan struct{} {
+	return m.done
+}
+
+// taskRowColumns are the column names produced by a store.TaskColumns select
+var taskRowColumns = []string{"id", "title", "description", "status", "queue", "priority", "unique_key", "batch_id", "lease_owner", "lease_expires_at", "attempts", "max_attempts", "last_error", "payload", "result", "run_at", "progress", "progress_message", "cancel_requested_at", "timeout_seconds", "deadline_at", "created_at", "updated_at"}
+
+// addTaskRow appends a task with no lease to the mock rows
+func addTaskRow(rows *sqlmock.Rows, id int64, title, description, status string) *sqlmock.Rows {
+	return rows.AddRow(id, title, description, status, "default", 0, nil, nil, nil, nil, 0, 3, nil, nil, nil, time.Now(), nil, nil, nil, nil, nil, time.Now(), time.Now())
+}
+
+// addLeasedTaskRow appends an in_progress task leased to owner to the mock rows
+func addLeasedTaskRow(rows *sqlmock.Rows, id int64, owner string) *sqlmock.Rows {
+	return rows.AddRow(id, "Test Task", "Test Description", "in_progress", "default", 0, nil, nil, owner, time.Now().Add(time.Minute), 0, 3, nil, nil, nil, time.Now(), nil, nil, nil, nil, nil, time.Now(), time.Now())
+}
+
+// afterTime matches time arguments later than the given instant
+type afterTime struct {
+	t time.Time
+}
+
+func (a afterTime) Match(v driver.Value) bool {
+	ts, ok := v.(time.Time)
+	return ok && ts.After(a.t)
+}
+
 // Setup test handler with mock DB and Redis
+// dependentsQuery locks the blocked dependents of a task that finished
+var dependentsQuery = `SELECT id FROM tasks WHERE status = \$1 AND id IN \(SELECT task_id FROM task_dependencies WHERE depends_on_id = \$2\) ORDER BY id FOR UPDATE`
+
+// expectDependents expects a finished task's blocked dependents to be
+// looked up, returning dependents
+func expectDependents(mock sqlmock.Sqlmock, taskID int64, dependents ...int64) {
+	rows := sqlmock.NewRows([]string{"id"})
+	for _, id := range dependents {
+		rows.AddRow(id)
+	}
+	mock.ExpectQuery(dependentsQuery).
+		WithArgs("blocked", taskID).
+		WillReturnRows(rows)
+}
+
 func setupTestHandler(t *testing.T) (*TaskHandler, sqlmock.Sqlmock) {
 	// Create mock DB
 	db, mock, err := sqlmock.New()
@@ -61,6 +152,18 @@ func setupTestHandler(t *testing.T) (*TaskHandler, sqlmock.Sqlmock) {
 	handler := &TaskHandler{
 		db:    db,
 		cache: redisClient,
+		config: &Config{
+			DefaultLease:         30 * time.Second,
+			MaxLease:             time.Hour,
+			Backoff:              store.BackoffPolicy{Base: time.Second, Max: time.Minute},
+			ClaimRetryAfter:      5 * time.Second,
+			MaxPayloadBytes:      64,
+			IdempotencyRetention: time.Hour,
+			MaxBatchSize:         3,
+			MaxClaimWait:         10 * time.Second,
+			ClaimRecheckInterval: 10 * time.Millisecond,
+			MaxClaimBatch:        10,
+		},
 	}
 
 	return handler, mock
@@ -80,11 +183,285 @@ func TestTaskHandler_CreateTask(t *testing.T) {
 			payload:        `{"title": "Test Task", "description": "Test Description"}`,
 			expectedStatus: http.StatusCreated,
 			mockDB: func() {
-				mock.ExpectQuery(`INSERT INTO tasks \(title, description, status, created_at, updated_at\) VALUES \(\$1, \$2, \$3, \$4, \$4\) RETURNING id`).
-					WithArgs("Test Task", "Test Description", "pending", sqlmock.AnyArg()).
+				mock.ExpectQuery(`INSERT INTO tasks \(title, description, payload, status, queue, priority, max_attempts, run_at, created_at, updated_at, unique_key, batch_id, timeout_seconds\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, COALESCE\(\$7, \(SELECT default_max_attempts FROM queues WHERE name = \$5\), \$8\), \$9, \$10, \$10, \$11, \$12, \$13\) ON CONFLICT \(unique_key\) WHERE status IN \('pending', 'in_progress', 'blocked'\) DO NOTHING RETURNING id`).
+					WithArgs("Test Task", "Test Description", sql.NullString{}, "pending", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{}, sql.NullInt64{}).
 					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 			},
 		},
+		{
+			name:           "Custom max attempts",
+			payload:        `{"title": "Test Task", "max_attempts": 5}`,
+			expectedStatus: http.StatusCreated,
+			mockDB: func() {
+				mock.ExpectQuery(`INSERT INTO tasks \(title, description, payload, status, queue, priority, max_attempts, run_at, created_at, updated_at, unique_key, batch_id, timeout_seconds\)`).
+					WithArgs("Test Task", "", sql.NullString{}, "pending", "default", 0, sql.NullInt64{Int64: 5, Valid: true}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+			},
+		},
+		{
+			name:           "Task with a timeout",
+			payload:        `{"title": "Test Task", "timeout_seconds": 300}`,
+			expectedStatus: http.StatusCreated,
+			mockDB: func() {
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Test Task", "", sql.NullString{}, "pending", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{}, sql.NullInt64{Int64: 300, Valid: true}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+			},
+		},
+		{
+			name:           "Task on a named queue",
+			payload:        `{"title": "Send Email", "queue": "emails"}`,
+			expectedStatus: http.StatusCreated,
+			mockDB: func() {
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Send Email", "", sql.NullString{}, "pending", "emails", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6))
+			},
+		},
+		{
+			name:           "Task with a payload",
+			payload:        `{"title": "Resize Image", "payload": {"url": "https://example.com/a.png", "width": 200}}`,
+			expectedStatus: http.StatusCreated,
+			mockDB: func() {
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Resize Image", "", sql.NullString{String: `{"url": "https://example.com/a.png", "width": 200}`, Valid: true}, "pending", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+			},
+		},
+		{
+			name:           "Payload too large",
+			payload:        `{"title": "Resize Image", "payload": {"data": "` + strings.Repeat("x", 64) + `"}}`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Unique key free",
+			payload:        `{"title": "Rebuild Index", "unique_key": "rebuild-index:customer-42"}`,
+			expectedStatus: http.StatusCreated,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Rebuild Index", "", sql.NullString{}, "pending", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{String: "rebuild-index:customer-42", Valid: true}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Unique key taken",
+			payload:        `{"title": "Rebuild Index", "unique_key": "rebuild-index:customer-42"}`,
+			expectedStatus: http.StatusConflict,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Rebuild Index", "", sql.NullString{}, "pending", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{String: "rebuild-index:customer-42", Valid: true}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Unique key taken returns the existing task",
+			payload:        `{"title": "Rebuild Index", "unique_key": "rebuild-index:customer-42", "unique_policy": "return_existing"}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Rebuild Index", "", sql.NullString{}, "pending", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{String: "rebuild-index:customer-42", Valid: true}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery(`SELECT id FROM tasks WHERE unique_key = \$1 AND status IN \(\$2, \$3, \$4\)`).
+					WithArgs("rebuild-index:customer-42", "pending", "in_progress", "blocked").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Unique key taken cancels and replaces the pending task",
+			payload:        `{"title": "Rebuild Index", "unique_key": "rebuild-index:customer-42", "unique_policy": "replace"}`,
+			expectedStatus: http.StatusCreated,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Rebuild Index", "", sql.NullString{}, "pending", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{String: "rebuild-index:customer-42", Valid: true}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery(`UPDATE tasks SET status = \$1, updated_at = \$2 WHERE unique_key = \$3 AND status IN \(\$4, \$5\) RETURNING id`).
+					WithArgs("cancelled", sqlmock.AnyArg(), "rebuild-index:customer-42", "pending", "blocked").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Rebuild Index", "", sql.NullString{}, "pending", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{String: "rebuild-index:customer-42", Valid: true}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+				mock.ExpectExec(`UPDATE task_dependencies SET depends_on_id = \$1 WHERE depends_on_id = \$2`).
+					WithArgs(int64(9), int64(4)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Unique key held by a task in progress is not replaced",
+			payload:        `{"title": "Rebuild Index", "unique_key": "rebuild-index:customer-42", "unique_policy": "replace"}`,
+			expectedStatus: http.StatusConflict,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Rebuild Index", "", sql.NullString{}, "pending", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{String: "rebuild-index:customer-42", Valid: true}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery(`UPDATE tasks SET status = \$1, updated_at = \$2 WHERE unique_key = \$3 AND status IN \(\$4, \$5\) RETURNING id`).
+					WithArgs("cancelled", sqlmock.AnyArg(), "rebuild-index:customer-42", "pending", "blocked").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Rebuild Index", "", sql.NullString{}, "pending", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{String: "rebuild-index:customer-42", Valid: true}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Dependencies not yet completed",
+			payload:        `{"title": "Load", "depends_on": [1, 2, 1]}`,
+			expectedStatus: http.StatusCreated,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT id, status FROM tasks WHERE id = ANY\(\$1\) ORDER BY id FOR SHARE`).
+					WithArgs(pq.Array([]int64{1, 2})).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, "completed").AddRow(2, "in_progress"))
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Load", "", sql.NullString{}, "blocked", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+				mock.ExpectExec(`INSERT INTO task_dependencies \(task_id, depends_on_id\) SELECT \$1, unnest\(\$2::bigint\[\]\)`).
+					WithArgs(int64(3), pq.Array([]int64{1, 2})).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Dependencies already completed",
+			payload:        `{"title": "Load", "depends_on": [1, 2]}`,
+			expectedStatus: http.StatusCreated,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT id, status FROM tasks WHERE id = ANY\(\$1\) ORDER BY id FOR SHARE`).
+					WithArgs(pq.Array([]int64{1, 2})).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, "completed").AddRow(2, "completed"))
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Load", "", sql.NullString{}, "pending", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+				mock.ExpectExec(`INSERT INTO task_dependencies \(task_id, depends_on_id\) SELECT \$1, unnest\(\$2::bigint\[\]\)`).
+					WithArgs(int64(3), pq.Array([]int64{1, 2})).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Dependency already dead",
+			payload:        `{"title": "Load", "depends_on": [1, 2]}`,
+			expectedStatus: http.StatusCreated,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT id, status FROM tasks WHERE id = ANY\(\$1\) ORDER BY id FOR SHARE`).
+					WithArgs(pq.Array([]int64{1, 2})).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, "dead").AddRow(2, "pending"))
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Load", "", sql.NullString{}, "cancelled", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+				mock.ExpectExec(`INSERT INTO task_dependencies \(task_id, depends_on_id\) SELECT \$1, unnest\(\$2::bigint\[\]\)`).
+					WithArgs(int64(3), pq.Array([]int64{1, 2})).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Dependency not found",
+			payload:        `{"title": "Load", "depends_on": [1, 2]}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT id, status FROM tasks WHERE id = ANY\(\$1\) ORDER BY id FOR SHARE`).
+					WithArgs(pq.Array([]int64{1, 2})).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, "completed"))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Invalid dependency ID",
+			payload:        `{"title": "Load", "depends_on": [0]}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Unique policy without a unique key",
+			payload:        `{"title": "Rebuild Index", "unique_policy": "replace"}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid unique policy",
+			payload:        `{"title": "Rebuild Index", "unique_key": "rebuild-index:customer-42", "unique_policy": "merge"}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid queue name",
+			payload:        `{"title": "Send Email", "queue": "emails/eu"}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "High priority task",
+			payload:        `{"title": "Escalation", "priority": 10}`,
+			expectedStatus: http.StatusCreated,
+			mockDB: func() {
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Escalation", "", sql.NullString{}, "pending", "default", 10, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+			},
+		},
+		{
+			name:           "Scheduled for a fixed time",
+			payload:        `{"title": "Nightly Task", "run_at": "2030-01-02T03:00:00Z"}`,
+			expectedStatus: http.StatusCreated,
+			mockDB: func() {
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Nightly Task", "", sql.NullString{}, "pending", "default", 0, sql.NullInt64{}, 3, time.Date(2030, 1, 2, 3, 0, 0, 0, time.UTC), sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+			},
+		},
+		{
+			name:           "Delayed task",
+			payload:        `{"title": "Delayed Task", "delay_seconds": 3600}`,
+			expectedStatus: http.StatusCreated,
+			mockDB: func() {
+				mock.ExpectQuery(`INSERT INTO tasks`).
+					WithArgs("Delayed Task", "", sql.NullString{}, "pending", "default", 0, sql.NullInt64{}, 3, afterTime{time.Now().Add(59 * time.Minute)}, sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{}, sql.NullInt64{}).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+			},
+		},
+		{
+			name:           "Both run_at and delay",
+			payload:        `{"title": "Task", "run_at": "2030-01-02T03:00:00Z", "delay_seconds": 60}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Negative delay",
+			payload:        `{"title": "Task", "delay_seconds": -1}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid run_at",
+			payload:        `{"title": "Task", "run_at": "tomorrow"}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid max attempts",
+			payload:        `{"title": "Test Task", "max_attempts": 0}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid timeout",
+			payload:        `{"title": "Test Task", "timeout_seconds": 0}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
 		{
 			name:           "Invalid JSON",
 			payload:        `{"title": "Test Task", "description": }`,
@@ -131,10 +508,9 @@ func TestTaskHandler_GetTask(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			checkResponse:  true,
 			mockDB: func() {
-				mock.ExpectQuery(`SELECT id, title, description, status, created_at, updated_at FROM tasks WHERE id = \$1`).
+				mock.ExpectQuery(`SELECT ` + regexp.QuoteMeta(store.TaskColumns) + ` FROM tasks WHERE id = \$1`).
 					WithArgs(1).
-					WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "status", "created_at", "updated_at"}).
-						AddRow(1, "Test Task", "Test Description", "pending", time.Now(), time.Now()))
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Test Task", "Test Description", "pending"))
 			},
 			mockRedis: func(h *TaskHandler) {
 				h.cache.(*redisMock).getFunc = func(ctx context.Context, key string) *redis.StringCmd {
@@ -189,6 +565,8 @@ func TestTaskHandler_GetTask(t *testing.T) {
 func TestTaskHandler_UpdateTask(t *testing.T) {
 	handler, mock := setupTestHandler(t)
 
+	lockQuery := `SELECT ` + regexp.QuoteMeta(store.TaskColumns) + ` FROM tasks WHERE id = \$1 FOR UPDATE`
+
 	tests := []struct {
 		name           string
 		taskID         string
@@ -202,18 +580,128 @@ func TestTaskHandler_UpdateTask(t *testing.T) {
 			payload:        `{"title": "Updated Task", "status": "completed"}`,
 			expectedStatus: http.StatusOK,
 			mockDB: func() {
-				mock.ExpectQuery(`UPDATE tasks SET title = COALESCE\(\$1, title\), description = COALESCE\(\$2, description\), status = COALESCE\(\$3, status\), updated_at = \$4 WHERE id = \$5 RETURNING id, title, description, status, created_at, updated_at`).
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Test Task", "Test Description", "in_progress"))
+				mock.ExpectQuery(`UPDATE tasks SET title = COALESCE\(\$1, title\), description = COALESCE\(\$2, description\), payload = COALESCE\(\$3, payload\), status = COALESCE\(\$4, status\), priority = COALESCE\(\$5, priority\), updated_at = \$6 WHERE id = \$7 RETURNING `+regexp.QuoteMeta(store.TaskColumns)).
 					WithArgs(
 						sql.NullString{String: "Updated Task", Valid: true},
 						sql.NullString{String: "", Valid: false},
+						sql.NullString{},
 						sql.NullString{String: "completed", Valid: true},
+						sql.NullInt64{},
 						sqlmock.AnyArg(),
 						1,
 					).
-					WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "status", "created_at", "updated_at"}).
-						AddRow(1, "Updated Task", "Test Description", "completed", time.Now(), time.Now()))
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Updated Task", "Test Description", "completed"))
+				expectDependents(mock, 1)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Raise priority",
+			taskID:         "2",
+			payload:        `{"priority": 50}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`UPDATE tasks SET (.+) priority = COALESCE\(\$5, priority\)`).
+					WithArgs(
+						sql.NullString{},
+						sql.NullString{},
+						sql.NullString{},
+						sql.NullString{},
+						sql.NullInt64{Int64: 50, Valid: true},
+						sqlmock.AnyArg(),
+						2,
+					).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 2, "Test Task", "Test Description", "pending"))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Replace payload",
+			taskID:         "3",
+			payload:        `{"payload": {"size": "large"}}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`UPDATE tasks SET (.+) payload = COALESCE\(\$3, payload\)`).
+					WithArgs(
+						sql.NullString{},
+						sql.NullString{},
+						sql.NullString{String: `{"size": "large"}`, Valid: true},
+						sql.NullString{},
+						sql.NullInt64{},
+						sqlmock.AnyArg(),
+						3,
+					).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 3, "Test Task", "Test Description", "pending"))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Blocked task cannot be made pending",
+			taskID:         "4",
+			payload:        `{"status": "pending"}`,
+			expectedStatus: http.StatusConflict,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(4).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 4, "Test Task", "Test Description", "blocked"))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Dead task cannot be made pending",
+			taskID:         "5",
+			payload:        `{"status": "pending"}`,
+			expectedStatus: http.StatusConflict,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(5).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 5, "Test Task", "Test Description", "dead"))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Unique key taken",
+			taskID:         "6",
+			payload:        `{"status": "pending"}`,
+			expectedStatus: http.StatusConflict,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(6).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 6, "Test Task", "Test Description", "failed"))
+				mock.ExpectQuery(`UPDATE tasks SET (.+) status = COALESCE\(\$4, status\)`).
+					WillReturnError(&pq.Error{Code: uniqueViolation})
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Missing task",
+			taskID:         "999",
+			payload:        `{"status": "completed"}`,
+			expectedStatus: http.StatusNotFound,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(999).
+					WillReturnRows(sqlmock.NewRows(taskRowColumns))
+				mock.ExpectRollback()
 			},
 		},
+		{
+			name:           "Payload too large",
+			taskID:         "3",
+			payload:        `{"payload": {"data": "` + strings.Repeat("x", 64) + `"}}`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			mockDB:         func() {},
+		},
 		{
 			name:           "Invalid task ID",
 			taskID:         "invalid",
@@ -252,6 +740,8 @@ func TestTaskHandler_UpdateTask(t *testing.T) {
 func TestTaskHandler_DeleteTask(t *testing.T) {
 	handler, mock := setupTestHandler(t)
 
+	lockQuery := `SELECT ` + regexp.QuoteMeta(store.TaskColumns) + ` FROM tasks WHERE id = \$1 FOR UPDATE`
+
 	tests := []struct {
 		name           string
 		taskID         string
@@ -263,9 +753,33 @@ func TestTaskHandler_DeleteTask(t *testing.T) {
 			taskID:         "1",
 			expectedStatus: http.StatusNoContent,
 			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Test Task", "", "completed"))
+				mock.ExpectExec(`DELETE FROM tasks WHERE id = \$1`).
+					WithArgs(1).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Parent with a blocked child cancels the child",
+			taskID:         "1",
+			expectedStatus: http.StatusNoContent,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Test Task", "", "pending"))
+				expectDependents(mock, 1, 2)
+				mock.ExpectQuery(`WITH RECURSIVE downstream AS`).
+					WithArgs(pq.Array([]int64{2}), "cancelled", sqlmock.AnyArg(), "blocked").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
 				mock.ExpectExec(`DELETE FROM tasks WHERE id = \$1`).
 					WithArgs(1).
 					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
 			},
 		},
 		{
@@ -279,9 +793,11 @@ func TestTaskHandler_DeleteTask(t *testing.T) {
 			taskID:         "999",
 			expectedStatus: http.StatusNotFound,
 			mockDB: func() {
-				mock.ExpectExec(`DELETE FROM tasks WHERE id = \$1`).
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
 					WithArgs(999).
-					WillReturnResult(sqlmock.NewResult(0, 0))
+					WillReturnRows(sqlmock.NewRows(taskRowColumns))
+				mock.ExpectRollback()
 			},
 		},
 	}
@@ -308,11 +824,12 @@ func TestTaskHandler_DeleteTask(t *testing.T) {
 func TestTaskHandler_ListTasks(t *testing.T) {
 	handler, mock := setupTestHandler(t)
 
-	mock.ExpectQuery(`SELECT id, title, description, status, created_at, updated_at FROM tasks ORDER BY created_at DESC LIMIT \$1 OFFSET \$2`).
+	rows := sqlmock.NewRows(taskRowColumns)
+	addTaskRow(rows, 1, "Task 1", "Description 1", "pending")
+	addTaskRow(rows, 2, "Task 2", "Description 2", "completed")
+	mock.ExpectQuery(`SELECT `+regexp.QuoteMeta(store.TaskColumns)+` FROM tasks ORDER BY created_at DESC LIMIT \$1 OFFSET \$2`).
 		WithArgs(10, 0).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "status", "created_at", "updated_at"}).
-			AddRow(1, "Task 1", "Description 1", "pending", time.Now(), time.Now()).
-			AddRow(2, "Task 2", "Description 2", "completed", time.Now(), time.Now()))
+		WillReturnRows(rows)
 
 	req := httptest.NewRequest("GET", "/api/v1/tasks", nil)
 	w := httptest.NewRecorder()
@@ -327,3 +844,1013 @@ func TestTaskHandler_ListTasks(t *testing.T) {
 	assert.Len(t, response, 2)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestTaskHandler_ListTasks_Priority(t *testing.T) {
+	handler, mock := setupTestHandler(t)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		mockDB         func()
+	}{
+		{
+			name:           "Sort by priority",
+			query:          "?sort=priority",
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectQuery(`SELECT `+regexp.QuoteMeta(store.TaskColumns)+` FROM tasks ORDER BY priority DESC, created_at DESC LIMIT \$1 OFFSET \$2`).
+					WithArgs(10, 0).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Task", "", "pending"))
+			},
+		},
+		{
+			name:           "Filter by priority",
+			query:          "?priority=5",
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectQuery(`SELECT `+regexp.QuoteMeta(store.TaskColumns)+` FROM tasks WHERE priority = \$1 ORDER BY created_at DESC LIMIT \$2 OFFSET \$3`).
+					WithArgs(5, 10, 0).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Task", "", "pending"))
+			},
+		},
+		{
+			name:           "Minimum priority combined with scheduled",
+			query:          "?scheduled=true&min_priority=-1&sort=priority",
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectQuery(`SELECT `+regexp.QuoteMeta(store.TaskColumns)+` FROM tasks WHERE status = \$1 AND run_at > \$2 AND priority >= \$3 ORDER BY priority DESC, created_at DESC LIMIT \$4 OFFSET \$5`).
+					WithArgs("pending", sqlmock.AnyArg(), -1, 10, 0).
+					WillReturnRows(sqlmock.NewRows(taskRowColumns))
+			},
+		},
+		{
+			name:           "Filter by batch",
+			query:          "?batch_id=4&queue=emails",
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectQuery(`SELECT `+regexp.QuoteMeta(store.TaskColumns)+` FROM tasks WHERE queue = \$1 AND batch_id = \$2 ORDER BY created_at DESC LIMIT \$3 OFFSET \$4`).
+					WithArgs("emails", int64(4), 10, 0).
+					WillReturnRows(sqlmock.NewRows(taskRowColumns))
+			},
+		},
+		{
+			name:           "Invalid batch",
+			query:          "?batch_id=latest",
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid priority",
+			query:          "?priority=high",
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid min priority",
+			query:          "?min_priority=1.5",
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid sort",
+			query:          "?sort=title",
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockDB()
+
+			req := httptest.NewRequest("GET", "/api/v1/tasks"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			handler.ListTasks(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestTaskHandler_ListTasks_Scheduled(t *testing.T) {
+	handler, mock := setupTestHandler(t)
+
+	mock.ExpectQuery(`SELECT `+regexp.QuoteMeta(store.TaskColumns)+` FROM tasks WHERE status = \$1 AND run_at > \$2 ORDER BY created_at DESC LIMIT \$3 OFFSET \$4`).
+		WithArgs("pending", sqlmock.AnyArg(), 10, 0).
+		WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Nightly Task", "", "pending"))
+
+	req := httptest.NewRequest("GET", "/api/v1/tasks?scheduled=true", nil)
+	w := httptest.NewRecorder()
+
+	handler.ListTasks(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response []models.Task
+	err := json.NewDecoder(w.Body).Decode(&response)
+	assert.NoError(t, err)
+	assert.Len(t, response, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTaskHandler_ClaimTask(t *testing.T) {
+	handler, mock := setupTestHandler(t)
+
+	claimQuery := `WITH candidates AS \( SELECT id, queue, priority, run_at FROM tasks WHERE status = \$7 AND run_at <= \$3 AND \(COALESCE\(cardinality\(\$8::text\[\]\), 0\) = 0 OR queue = ANY\(\$8\)\) AND \(COALESCE\(cardinality\(\$9::text\[\]\), 0\) = 0 OR queue <> ALL\(\$9\)\) AND NOT EXISTS \( SELECT 1 FROM queues WHERE name = tasks.queue AND \(paused OR max_in_flight <= \( SELECT count\(\*\) FROM tasks running WHERE running.queue = queues.name AND running.status = \$1 \) OR \(max_in_flight IS NOT NULL AND NOT COALESCE\(name = ANY\(\$11::text\[\]\), false\)\)\) \) ORDER BY priority DESC, run_at, id LIMIT \$10 FOR UPDATE SKIP LOCKED \), ranked AS \( SELECT id, queue, row_number\(\) OVER \(PARTITION BY queue ORDER BY priority DESC, run_at, id\) AS rank FROM candidates \) ` +
+		`UPDATE tasks SET status = \$1, lease_owner = \$2, lease_expires_at = ` + leaseExpiryPattern(3, 4, 5, 6) + `, progress = NULL, progress_message = NULL, deadline_at = \$3 \+ timeout_seconds \* interval '1 second', updated_at = \$3 ` +
+		`WHERE id IN \( SELECT ranked.id FROM ranked LEFT JOIN queues ON queues.name = ranked.queue WHERE queues.max_in_flight IS NULL OR ranked.rank <= queues.max_in_flight - \( SELECT count\(\*\) FROM tasks running WHERE running.queue = ranked.queue AND running.status = \$1 \) \) RETURNING ` + regexp.QuoteMeta(store.TaskColumns)
+
+	lockQuery := `SELECT name FROM queues WHERE max_in_flight IS NOT NULL AND NOT paused AND \(COALESCE\(cardinality\(\$1::text\[\]\), 0\) = 0 OR name = ANY\(\$1\)\) AND EXISTS \(SELECT 1 FROM tasks WHERE queue = queues.name AND status = \$2 AND run_at <= \$3\) ORDER BY name FOR UPDATE`
+	saturatedQuery := `SELECT EXISTS \( SELECT 1 FROM queues WHERE name = ANY\(\$1\) AND NOT paused AND max_in_flight <= \(SELECT count\(\*\) FROM tasks WHERE queue = queues.name AND status = \$2\) AND EXISTS \(SELECT 1 FROM tasks WHERE queue = queues.name AND status = \$3 AND run_at <= \$4\) \)`
+
+	throttleQuery := `SELECT name, rate_limit, COALESCE\(rate_period_seconds, 1\) FROM queues WHERE rate_limit IS NOT NULL AND \(COALESCE\(cardinality\(\$1::text\[\]\), 0\) = 0 OR name = ANY\(\$1\)\)`
+	rateLimitRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"name", "rate_limit", "rate_period_seconds"}).AddRow("default", 100, 60)
+	}
+
+	reviveQuery := `UPDATE workers SET status = \$1, last_seen_at = \$2, updated_at = \$2 WHERE id = \$3 AND status = \$4`
+	// expectRevive expects worker-1 to be marked alive as it leases tasks
+	expectRevive := func() {
+		mock.ExpectExec(reviveQuery).
+			WithArgs("alive", sqlmock.AnyArg(), "worker-1", "dead").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	runAt := time.Now().Add(-time.Minute)
+	// addClaimedRow appends a task leased to worker-1
+	addClaimedRow := func(rows *sqlmock.Rows, id int64, queue string, priority int) *sqlmock.Rows {
+		return rows.AddRow(id, "Test Task", "", "in_progress", queue, priority, nil, nil, "worker-1", time.Now().Add(time.Minute), 0, 3, nil, nil, nil, runAt, nil, nil, nil, nil, nil, runAt, runAt)
+	}
+
+	// expectEmptyClaim expects a claim that finds no task
+	expectEmptyClaim := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(pq.Array([]string(nil)), "pending", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}))
+		mock.ExpectQuery(claimQuery).
+			WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{}, 30, 3600, "pending", pq.Array([]string(nil)), pq.Array([]string(nil)), 1, pq.Array([]string(nil))).
+			WillReturnRows(sqlmock.NewRows(taskRowColumns))
+		mock.ExpectRollback()
+	}
+	// expectClaim expects a claim that leases a task
+	expectClaim := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockQuery).
+			WithArgs(pq.Array([]string(nil)), "pending", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}))
+		mock.ExpectQuery(claimQuery).
+			WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{}, 30, 3600, "pending", pq.Array([]string(nil)), pq.Array([]string(nil)), 1, pq.Array([]string(nil))).
+			WillReturnRows(addLeasedTaskRow(sqlmock.NewRows(taskRowColumns), 1, "worker-1"))
+		expectRevive()
+		mock.ExpectCommit()
+	}
+
+	tests := []struct {
+		name               string
+		query              string
+		payload            string
+		limiter            *rateLimiterMock
+		notifier           *notifierMock
+		expectedStatus     int
+		expectedRetryAfter string
+		expectedWaits      int
+		expectedBatch      []int64
+		mockDB             func()
+	}{
+		{
+			name:           "Task claimed",
+			payload:        `{"worker_id": "worker-1"}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(pq.Array([]string(nil)), "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}))
+				mock.ExpectQuery(claimQuery).
+					WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{}, 30, 3600, "pending", pq.Array([]string(nil)), pq.Array([]string(nil)), 1, pq.Array([]string(nil))).
+					WillReturnRows(addLeasedTaskRow(sqlmock.NewRows(taskRowColumns), 1, "worker-1"))
+				expectRevive()
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Dead worker is alive again once it leases a task",
+			payload:        `{"worker_id": "worker-1"}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(pq.Array([]string(nil)), "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}))
+				mock.ExpectQuery(claimQuery).
+					WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{}, 30, 3600, "pending", pq.Array([]string(nil)), pq.Array([]string(nil)), 1, pq.Array([]string(nil))).
+					WillReturnRows(addLeasedTaskRow(sqlmock.NewRows(taskRowColumns), 1, "worker-1"))
+				mock.ExpectExec(reviveQuery).
+					WithArgs("alive", sqlmock.AnyArg(), "worker-1", "dead").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Error reviving the worker",
+			payload:        `{"worker_id": "worker-1"}`,
+			expectedStatus: http.StatusInternalServerError,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(pq.Array([]string(nil)), "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}))
+				mock.ExpectQuery(claimQuery).
+					WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{}, 30, 3600, "pending", pq.Array([]string(nil)), pq.Array([]string(nil)), 1, pq.Array([]string(nil))).
+					WillReturnRows(addLeasedTaskRow(sqlmock.NewRows(taskRowColumns), 1, "worker-1"))
+				mock.ExpectExec(reviveQuery).
+					WithArgs("alive", sqlmock.AnyArg(), "worker-1", "dead").
+					WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Task claimed from selected queues",
+			payload:        `{"worker_id": "worker-1", "queues": ["emails", "sms"], "lease_seconds": 120}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(pq.Array([]string{"emails", "sms"}), "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("sms"))
+				mock.ExpectQuery(claimQuery).
+					WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{Int64: 120, Valid: true}, 30, 3600, "pending", pq.Array([]string{"emails", "sms"}), pq.Array([]string(nil)), 1, pq.Array([]string{"sms"})).
+					WillReturnRows(addLeasedTaskRow(sqlmock.NewRows(taskRowColumns), 1, "worker-1"))
+				expectRevive()
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "No pending tasks",
+			payload:        `{"worker_id": "worker-1", "lease_seconds": 60}`,
+			expectedStatus: http.StatusNoContent,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(pq.Array([]string(nil)), "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}))
+				mock.ExpectQuery(claimQuery).
+					WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{Int64: 60, Valid: true}, 30, 3600, "pending", pq.Array([]string(nil)), pq.Array([]string(nil)), 1, pq.Array([]string(nil))).
+					WillReturnRows(sqlmock.NewRows(taskRowColumns))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:               "Queue at its limit",
+			payload:            `{"worker_id": "worker-1", "queues": ["sms"]}`,
+			expectedStatus:     http.StatusNoContent,
+			expectedRetryAfter: "5",
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(pq.Array([]string{"sms"}), "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("sms"))
+				mock.ExpectQuery(claimQuery).
+					WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{}, 30, 3600, "pending", pq.Array([]string{"sms"}), pq.Array([]string(nil)), 1, pq.Array([]string{"sms"})).
+					WillReturnRows(sqlmock.NewRows(taskRowColumns))
+				mock.ExpectQuery(saturatedQuery).
+					WithArgs(pq.Array([]string{"sms"}), "in_progress", "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Limited queue drained",
+			payload:        `{"worker_id": "worker-1", "queues": ["sms"]}`,
+			expectedStatus: http.StatusNoContent,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(pq.Array([]string{"sms"}), "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("sms"))
+				mock.ExpectQuery(claimQuery).
+					WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{}, 30, 3600, "pending", pq.Array([]string{"sms"}), pq.Array([]string(nil)), 1, pq.Array([]string{"sms"})).
+					WillReturnRows(sqlmock.NewRows(taskRowColumns))
+				mock.ExpectQuery(saturatedQuery).
+					WithArgs(pq.Array([]string{"sms"}), "in_progress", "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Rate limited queue with tokens",
+			payload:        `{"worker_id": "worker-1"}`,
+			limiter:        &rateLimiterMock{peekAllowed: true, takeAllowed: true},
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(pq.Array([]string(nil)), "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}))
+				mock.ExpectQuery(throttleQuery).
+					WithArgs(pq.Array([]string(nil))).
+					WillReturnRows(rateLimitRows())
+				mock.ExpectQuery(claimQuery).
+					WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{}, 30, 3600, "pending", pq.Array([]string(nil)), pq.Array([]string(nil)), 1, pq.Array([]string(nil))).
+					WillReturnRows(addLeasedTaskRow(sqlmock.NewRows(taskRowColumns), 1, "worker-1"))
+				expectRevive()
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:               "Rate limited queue out of tokens",
+			payload:            `{"worker_id": "worker-1"}`,
+			limiter:            &rateLimiterMock{peekAllowed: false, peekWait: 1500 * time.Millisecond},
+			expectedStatus:     http.StatusNoContent,
+			expectedRetryAfter: "2",
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(pq.Array([]string(nil)), "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}))
+				mock.ExpectQuery(throttleQuery).
+					WithArgs(pq.Array([]string(nil))).
+					WillReturnRows(rateLimitRows())
+				mock.ExpectQuery(claimQuery).
+					WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{}, 30, 3600, "pending", pq.Array([]string(nil)), pq.Array([]string{"default"}), 1, pq.Array([]string(nil))).
+					WillReturnRows(sqlmock.NewRows(taskRowColumns))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:               "Bucket drained by a concurrent claim",
+			payload:            `{"worker_id": "worker-1"}`,
+			limiter:            &rateLimiterMock{peekAllowed: true, takeAllowed: false, takeWait: 400 * time.Millisecond},
+			expectedStatus:     http.StatusNoContent,
+			expectedRetryAfter: "1",
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(pq.Array([]string(nil)), "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}))
+				mock.ExpectQuery(throttleQuery).
+					WithArgs(pq.Array([]string(nil))).
+					WillReturnRows(rateLimitRows())
+				mock.ExpectQuery(claimQuery).
+					WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{}, 30, 3600, "pending", pq.Array([]string(nil)), pq.Array([]string(nil)), 1, pq.Array([]string(nil))).
+					WillReturnRows(addLeasedTaskRow(sqlmock.NewRows(taskRowColumns), 1, "worker-1"))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Rate limiter unavailable",
+			payload:        `{"worker_id": "worker-1"}`,
+			limiter:        &rateLimiterMock{peekErr: redis.ErrClosed, takeErr: redis.ErrClosed},
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(pq.Array([]string(nil)), "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}))
+				mock.ExpectQuery(throttleQuery).
+					WithArgs(pq.Array([]string(nil))).
+					WillReturnRows(rateLimitRows())
+				mock.ExpectQuery(claimQuery).
+					WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{}, 30, 3600, "pending", pq.Array([]string(nil)), pq.Array([]string(nil)), 1, pq.Array([]string(nil))).
+					WillReturnRows(addLeasedTaskRow(sqlmock.NewRows(taskRowColumns), 1, "worker-1"))
+				expectRevive()
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Woken by a new task",
+			query:          "?wait=5",
+			payload:        `{"worker_id": "worker-1"}`,
+			notifier:       newNotifierMock(true, false),
+			expectedStatus: http.StatusOK,
+			expectedWaits:  2,
+			mockDB: func() {
+				expectEmptyClaim()
+				expectClaim()
+			},
+		},
+		{
+			name:           "Task found on a recheck",
+			query:          "?wait=5",
+			payload:        `{"worker_id": "worker-1"}`,
+			notifier:       newNotifierMock(false, false),
+			expectedStatus: http.StatusOK,
+			expectedWaits:  2,
+			mockDB: func() {
+				expectEmptyClaim()
+				expectClaim()
+			},
+		},
+		{
+			name:           "Waiting ends on shutdown",
+			query:          "?wait=5",
+			payload:        `{"worker_id": "worker-1"}`,
+			notifier:       newNotifierMock(false, true),
+			expectedStatus: http.StatusNoContent,
+			expectedWaits:  1,
+			mockDB:         expectEmptyClaim,
+		},
+		{
+			name:               "Limited queue is not waited on",
+			query:              "?wait=5",
+			payload:            `{"worker_id": "worker-1", "queues": ["sms"]}`,
+			notifier:           newNotifierMock(false, false),
+			expectedStatus:     http.StatusNoContent,
+			expectedRetryAfter: "5",
+			expectedWaits:      1,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(pq.Array([]string{"sms"}), "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("sms"))
+				mock.ExpectQuery(claimQuery).
+					WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{}, 30, 3600, "pending", pq.Array([]string{"sms"}), pq.Array([]string(nil)), 1, pq.Array([]string{"sms"})).
+					WillReturnRows(sqlmock.NewRows(taskRowColumns))
+				mock.ExpectQuery(saturatedQuery).
+					WithArgs(pq.Array([]string{"sms"}), "in_progress", "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Wait without a notifier",
+			query:          "?wait=5",
+			payload:        `{"worker_id": "worker-1"}`,
+			expectedStatus: http.StatusNoContent,
+			mockDB:         expectEmptyClaim,
+		},
+		{
+			name:           "Batch of tasks claimed",
+			query:          "?max=3",
+			payload:        `{"worker_id": "worker-1"}`,
+			expectedStatus: http.StatusOK,
+			expectedBatch:  []int64{2, 1, 3},
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(pq.Array([]string(nil)), "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}))
+				mock.ExpectQuery(claimQuery).
+					WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{}, 30, 3600, "pending", pq.Array([]string(nil)), pq.Array([]string(nil)), 3, pq.Array([]string(nil))).
+					WillReturnRows(addClaimedRow(addClaimedRow(addClaimedRow(sqlmock.NewRows(taskRowColumns), 3, "default", 0), 1, "default", 0), 2, "default", 5))
+				expectRevive()
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Batch capped by the max batch size",
+			query:          "?max=500",
+			payload:        `{"worker_id": "worker-1"}`,
+			expectedStatus: http.StatusOK,
+			expectedBatch:  []int64{1},
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(pq.Array([]string(nil)), "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}))
+				mock.ExpectQuery(claimQuery).
+					WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{}, 30, 3600, "pending", pq.Array([]string(nil)), pq.Array([]string(nil)), 10, pq.Array([]string(nil))).
+					WillReturnRows(addClaimedRow(sqlmock.NewRows(taskRowColumns), 1, "default", 0))
+				expectRevive()
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Batch past a rate limited queue's tokens",
+			query:          "?max=3",
+			payload:        `{"worker_id": "worker-1"}`,
+			limiter:        &rateLimiterMock{peekAllowed: true, takeAllowed: false, takeWait: time.Second},
+			expectedStatus: http.StatusOK,
+			expectedBatch:  []int64{2},
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(pq.Array([]string(nil)), "pending", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}))
+				mock.ExpectQuery(throttleQuery).
+					WithArgs(pq.Array([]string(nil))).
+					WillReturnRows(rateLimitRows())
+				mock.ExpectQuery(claimQuery).
+					WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{}, 30, 3600, "pending", pq.Array([]string(nil)), pq.Array([]string(nil)), 3, pq.Array([]string(nil))).
+					WillReturnRows(addClaimedRow(addClaimedRow(addClaimedRow(sqlmock.NewRows(taskRowColumns), 1, "default", 0), 2, "emails", 0), 3, "default", 0))
+				mock.ExpectExec(`UPDATE tasks SET status = \$1, lease_owner = NULL, lease_expires_at = NULL, deadline_at = NULL WHERE id = ANY\(\$2\)`).
+					WithArgs("pending", pq.Array([]int64{1, 3})).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				expectRevive()
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Invalid max",
+			query:          "?max=0",
+			payload:        `{"worker_id": "worker-1"}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid wait",
+			query:          "?wait=-1",
+			payload:        `{"worker_id": "worker-1"}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Missing worker ID",
+			payload:        `{}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Negative lease",
+			payload:        `{"worker_id": "worker-1", "lease_seconds": -5}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid queue name",
+			payload:        `{"worker_id": "worker-1", "queues": [""]}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockDB()
+			handler.limiter = nil
+			if tt.limiter != nil {
+				handler.limiter = tt.limiter
+			}
+			handler.notifier = nil
+			if tt.notifier != nil {
+				handler.notifier = tt.notifier
+			}
+
+			req := httptest.NewRequest("POST", "/api/v1/tasks/claim"+tt.query, strings.NewReader(tt.payload))
+			w := httptest.NewRecorder()
+
+			handler.ClaimTask(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedRetryAfter, w.Header().Get("Retry-After"))
+			if tt.notifier != nil {
+				assert.Equal(t, tt.expectedWaits, tt.notifier.waits)
+			}
+			if tt.expectedBatch != nil {
+				var response []models.Task
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+				ids := []int64{}
+				for _, task := range response {
+					ids = append(ids, task.ID)
+				}
+				assert.Equal(t, tt.expectedBatch, ids)
+			} else if tt.expectedStatus == http.StatusOK {
+				var response models.Task
+				err := json.NewDecoder(w.Body).Decode(&response)
+				assert.NoError(t, err)
+				assert.Equal(t, "in_progress", response.Status)
+				if assert.NotNil(t, response.LeaseOwner) {
+					assert.Equal(t, "worker-1", *response.LeaseOwner)
+				}
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestClaimWait(t *testing.T) {
+	tests := []struct {
+		query       string
+		expected    time.Duration
+		expectError bool
+	}{
+		{query: "", expected: 0},
+		{query: "?wait=0", expected: 0},
+		{query: "?wait=20", expected: 20 * time.Second},
+		{query: "?wait=3600", expected: 30 * time.Second},
+		{query: "?wait=-1", expectError: true},
+		{query: "?wait=soon", expectError: true},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/api/v1/tasks/claim"+tt.query, nil)
+		wait, err := claimWait(req, 30*time.Second)
+
+		if tt.expectError {
+			assert.EqualError(t, err, "Wait must be a non-negative number of seconds", tt.query)
+		} else {
+			assert.NoError(t, err, tt.query)
+			assert.Equal(t, tt.expected, wait, tt.query)
+		}
+	}
+}
+
+func TestClaimMax(t *testing.T) {
+	tests := []struct {
+		query         string
+		expected      int
+		expectedBatch bool
+		expectError   bool
+	}{
+		{query: "", expected: 1},
+		{query: "?max=1", expected: 1, expectedBatch: true},
+		{query: "?max=50", expected: 50, expectedBatch: true},
+		{query: "?max=500", expected: 100, expectedBatch: true},
+		{query: "?max=0", expectError: true},
+		{query: "?max=all", expectError: true},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/api/v1/tasks/claim"+tt.query, nil)
+		max, batch, err := claimMax(req, 100)
+
+		if tt.expectError {
+			assert.EqualError(t, err, "Max must be a positive number of tasks", tt.query)
+		} else {
+			assert.NoError(t, err, tt.query)
+			assert.Equal(t, tt.expected, max, tt.query)
+			assert.Equal(t, tt.expectedBatch, batch, tt.query)
+		}
+	}
+}
+
+func TestTaskHandler_ClaimTask_RequestTimeout(t *testing.T) {
+	handler, mock := setupTestHandler(t)
+	handler.notifier = newNotifierMock(false, false)
+	handler.config.ClaimRecheckInterval = time.Hour
+
+	// Claimed once before waiting and once more when the wait ends
+	for i := 0; i < 2; i++ {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT name FROM queues WHERE max_in_flight IS NOT NULL`).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}))
+		mock.ExpectQuery(`UPDATE tasks SET status = \$1, lease_owner = \$2`).
+			WillReturnRows(sqlmock.NewRows(taskRowColumns))
+		mock.ExpectRollback()
+	}
+
+	// A request timing out in 1.1 seconds stops waiting a second early
+	ctx, cancel := context.WithTimeout(context.Background(), 1100*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest("POST", "/api/v1/tasks/claim?wait=10", strings.NewReader(`{"worker_id": "worker-1"}`)).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	start := time.Now()
+	handler.ClaimTask(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.NoError(t, ctx.Err())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// leaseExpiryPattern matches leaseExpiry rendered with the given placeholders
+func leaseExpiryPattern(now, requested, fallback, max int) string {
+	return regexp.QuoteMeta(leaseExpiry(fmt.Sprintf("$%d", now), fmt.Sprintf("$%d", requested), fmt.Sprintf("$%d", fallback), fmt.Sprintf("$%d", max)))
+}
+
+func TestLeaseSeconds(t *testing.T) {
+	assert.Equal(t, sql.NullInt64{}, leaseSeconds(0))
+	assert.Equal(t, sql.NullInt64{Int64: 120, Valid: true}, leaseSeconds(120))
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	// Requested lease, then the queue's lease, then the default, capped at the maximum
+	assert.Equal(t,
+		`$1::timestamptz + make_interval(secs => LEAST(COALESCE($2, (SELECT lease_seconds FROM queues WHERE name = tasks.queue), $3), $4))`,
+		leaseExpiry("$1", "$2", "$3", "$4"))
+}
+
+func TestTaskHandler_Heartbeat(t *testing.T) {
+	handler, mock := setupTestHandler(t)
+
+	heartbeatQuery := `UPDATE tasks SET lease_expires_at = ` + leaseExpiryPattern(4, 1, 8, 9) + `, progress = COALESCE\(\$2, progress\), progress_message = COALESCE\(\$3, progress_message\), updated_at = \$4 WHERE id = \$5 AND status = \$6 AND lease_owner = \$7 RETURNING ` + regexp.QuoteMeta(store.TaskColumns)
+	existsQuery := `SELECT EXISTS\(SELECT 1 FROM tasks WHERE id = \$1\)`
+
+	tests := []struct {
+		name           string
+		taskID         string
+		payload        string
+		expectedStatus int
+		mockDB         func()
+	}{
+		{
+			name:           "Lease extended with progress",
+			taskID:         "1",
+			payload:        `{"worker_id": "worker-1", "progress": 40, "message": "resizing"}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectQuery(heartbeatQuery).
+					WithArgs(
+						sql.NullInt64{},
+						sql.NullInt64{Int64: 40, Valid: true},
+						sql.NullString{String: "resizing", Valid: true},
+						sqlmock.AnyArg(),
+						1,
+						"in_progress",
+						"worker-1",
+						30,
+						3600,
+					).
+					WillReturnRows(addLeasedTaskRow(sqlmock.NewRows(taskRowColumns), 1, "worker-1"))
+			},
+		},
+		{
+			name:           "Lease held by another worker",
+			taskID:         "1",
+			payload:        `{"worker_id": "worker-2"}`,
+			expectedStatus: http.StatusConflict,
+			mockDB: func() {
+				mock.ExpectQuery(heartbeatQuery).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(existsQuery).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			},
+		},
+		{
+			name:           "Task not found",
+			taskID:         "999",
+			payload:        `{"worker_id": "worker-1"}`,
+			expectedStatus: http.StatusNotFound,
+			mockDB: func() {
+				mock.ExpectQuery(heartbeatQuery).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(existsQuery).
+					WithArgs(999).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			},
+		},
+		{
+			name:           "Progress out of range",
+			taskID:         "1",
+			payload:        `{"worker_id": "worker-1", "progress": 101}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Missing worker ID",
+			taskID:         "1",
+			payload:        `{"progress": 10}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid task ID",
+			taskID:         "invalid",
+			payload:        `{"worker_id": "worker-1"}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockDB()
+
+			req := httptest.NewRequest("POST", "/api/v1/tasks/"+tt.taskID+"/heartbeat", strings.NewReader(tt.payload))
+			w := httptest.NewRecorder()
+
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("id", tt.taskID)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+			handler.Heartbeat(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestTaskHandler_CompleteTask(t *testing.T) {
+	handler, mock := setupTestHandler(t)
+
+	completeQuery := `UPDATE tasks SET status = \$1, result = \$2, lease_owner = NULL, lease_expires_at = NULL, updated_at = \$3 WHERE id = \$4 AND status = \$5 AND lease_owner = \$6 RETURNING ` + regexp.QuoteMeta(store.TaskColumns)
+
+	tests := []struct {
+		name           string
+		taskID         string
+		payload        string
+		expectedStatus int
+		mockDB         func()
+	}{
+		{
+			name:           "Completed with result",
+			taskID:         "1",
+			payload:        `{"worker_id": "worker-1", "result": {"pages": 3}}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(completeQuery).
+					WithArgs("completed", sql.NullString{String: `{"pages": 3}`, Valid: true}, sqlmock.AnyArg(), 1, "in_progress", "worker-1").
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Test Task", "Test Description", "completed"))
+				expectDependents(mock, 1)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Completed without result",
+			taskID:         "1",
+			payload:        `{"worker_id": "worker-1"}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(completeQuery).
+					WithArgs("completed", sql.NullString{}, sqlmock.AnyArg(), 1, "in_progress", "worker-1").
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Test Task", "Test Description", "completed"))
+				expectDependents(mock, 1)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Releases dependents",
+			taskID:         "1",
+			payload:        `{"worker_id": "worker-1"}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(completeQuery).
+					WithArgs("completed", sql.NullString{}, sqlmock.AnyArg(), 1, "in_progress", "worker-1").
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Test Task", "Test Description", "completed"))
+				expectDependents(mock, 1, 2, 3)
+				mock.ExpectQuery(`UPDATE tasks SET status = \$1, updated_at = \$2 WHERE id = ANY\(\$3\) AND NOT EXISTS \( SELECT 1 FROM task_dependencies d JOIN tasks parent ON parent.id = d.depends_on_id WHERE d.task_id = tasks.id AND parent.status <> \$4 \) RETURNING id`).
+					WithArgs("pending", sqlmock.AnyArg(), pq.Array([]int64{2, 3}), "completed").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Lease held by another worker",
+			taskID:         "1",
+			payload:        `{"worker_id": "worker-2"}`,
+			expectedStatus: http.StatusConflict,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(completeQuery).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM tasks WHERE id = \$1\)`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Missing worker ID",
+			taskID:         "1",
+			payload:        `{}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockDB()
+
+			req := httptest.NewRequest("POST", "/api/v1/tasks/"+tt.taskID+"/complete", strings.NewReader(tt.payload))
+			w := httptest.NewRecorder()
+
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("id", tt.taskID)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+			handler.CompleteTask(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestTaskHandler_FailTask(t *testing.T) {
+	handler, mock := setupTestHandler(t)
+
+	lockQuery := `SELECT ` + regexp.QuoteMeta(store.TaskColumns) + ` FROM tasks WHERE id = \$1 FOR UPDATE`
+	attemptQuery := `INSERT INTO task_attempts \(task_id, attempt, worker_id, error, created_at\) VALUES \(\$1, \$2, \$3, \$4, \$5\)`
+	failQuery := `UPDATE tasks SET status = \$1, attempts = \$2, last_error = \$3, run_at = \$4, lease_owner = NULL, lease_expires_at = NULL, updated_at = \$5 WHERE id = \$6 RETURNING ` + regexp.QuoteMeta(store.TaskColumns)
+
+	tests := []struct {
+		name           string
+		taskID         string
+		payload        string
+		expectedStatus int
+		mockDB         func()
+	}{
+		{
+			name:           "Failure is retried",
+			taskID:         "1",
+			payload:        `{"worker_id": "worker-1", "error": "timeout talking to S3"}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnRows(addLeasedTaskRow(sqlmock.NewRows(taskRowColumns), 1, "worker-1"))
+				mock.ExpectExec(attemptQuery).
+					WithArgs(1, 1, sql.NullString{String: "worker-1", Valid: true}, "timeout talking to S3", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery(failQuery).
+					WithArgs("pending", 1, "timeout talking to S3", sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Test Task", "Test Description", "pending"))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Non-retryable failure",
+			taskID:         "1",
+			payload:        `{"worker_id": "worker-1", "error": "invalid input", "retry": false}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnRows(addLeasedTaskRow(sqlmock.NewRows(taskRowColumns), 1, "worker-1"))
+				mock.ExpectExec(attemptQuery).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery(failQuery).
+					WithArgs("failed", 1, "invalid input", sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Test Task", "Test Description", "failed"))
+				expectDependents(mock, 1)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Failure cancels dependents",
+			taskID:         "1",
+			payload:        `{"worker_id": "worker-1", "error": "invalid input", "retry": false}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnRows(addLeasedTaskRow(sqlmock.NewRows(taskRowColumns), 1, "worker-1"))
+				mock.ExpectExec(attemptQuery).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery(failQuery).
+					WithArgs("failed", 1, "invalid input", sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Test Task", "Test Description", "failed"))
+				expectDependents(mock, 1, 2)
+				mock.ExpectQuery(`WITH RECURSIVE downstream AS \( SELECT unnest\(\$1::bigint\[\]\) AS id UNION SELECT d.task_id FROM task_dependencies d JOIN downstream ON d.depends_on_id = downstream.id \) UPDATE tasks SET status = \$2, updated_at = \$3 WHERE id IN \(SELECT id FROM downstream\) AND status = \$4 RETURNING id`).
+					WithArgs(pq.Array([]int64{2}), "cancelled", sqlmock.AnyArg(), "blocked").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2).AddRow(5))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "Lease held by another worker",
+			taskID:         "1",
+			payload:        `{"worker_id": "worker-2", "error": "boom"}`,
+			expectedStatus: http.StatusConflict,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(1).
+					WillReturnRows(addLeasedTaskRow(sqlmock.NewRows(taskRowColumns), 1, "worker-1"))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Task not found",
+			taskID:         "999",
+			payload:        `{"worker_id": "worker-1", "error": "boom"}`,
+			expectedStatus: http.StatusNotFound,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(lockQuery).
+					WithArgs(999).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "Missing error message",
+			taskID:         "1",
+			payload:        `{"worker_id": "worker-1"}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockDB()
+
+			req := httptest.NewRequest("POST", "/api/v1/tasks/"+tt.taskID+"/fail", strings.NewReader(tt.payload))
+			w := httptest.NewRecorder()
+
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("id", tt.taskID)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+			handler.FailTask(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
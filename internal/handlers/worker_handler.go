@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+)
+
+// WorkerHandler tracks registered workers and their liveness. Workers that
+// never register can still claim tasks; they are just not listed, and their
+// leases are only released once they expire.
+type WorkerHandler struct {
+	db *sql.DB
+}
+
+func NewWorkerHandler(db *sql.DB) *WorkerHandler {
+	return &WorkerHandler{
+		db: db,
+	}
+}
+
+// RegisterWorker registers a worker as alive. Registering an ID again, e.g.
+// after a restart or after the worker was marked dead, replaces its details.
+func (h *WorkerHandler) RegisterWorker(w http.ResponseWriter, r *http.Request) {
+	var req models.RegisterWorkerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if req.WorkerID == "" {
+		http.Error(w, "Worker ID is required", http.StatusBadRequest)
+		return
+	}
+	if req.Concurrency < 0 {
+		http.Error(w, "Concurrency must be at least 1", http.StatusBadRequest)
+		return
+	}
+	if req.Concurrency == 0 {
+		req.Concurrency = 1
+	}
+	if req.Queues == nil {
+		req.Queues = []string{}
+	}
+	for _, queue := range req.Queues {
+		if err := store.ValidateQueueName(queue); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	query := `
+		INSERT INTO workers (id, hostname, queues, version, concurrency, status, last_seen_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $7)
+		ON CONFLICT (id) DO UPDATE
+		SET hostname = EXCLUDED.hostname,
+			queues = EXCLUDED.queues,
+			version = EXCLUDED.version,
+			concurrency = EXCLUDED.concurrency,
+			status = EXCLUDED.status,
+			last_seen_at = EXCLUDED.last_seen_at,
+			updated_at = EXCLUDED.updated_at
+		RETURNING ` + store.WorkerColumns
+
+	worker, err := store.ScanWorker(h.db.QueryRowContext(
+		r.Context(),
+		query,
+		req.WorkerID,
+		req.Hostname,
+		pq.Array(req.Queues),
+		req.Version,
+		req.Concurrency,
+		models.WorkerStatusAlive,
+		time.Now(),
+	))
+	if err != nil {
+		http.Error(w, "Failed to register worker", http.StatusInternalServerError)
+		return
+	}
+
+	h.writeWorker(w, r, worker)
+}
+
+// WorkerHeartbeat records that a registered worker is alive. A worker that
+// was marked dead is alive again, but the leases released when it died are
+// not given back.
+func (h *WorkerHandler) WorkerHeartbeat(w http.ResponseWriter, r *http.Request) {
+	query := `
+		UPDATE workers
+		SET status = $1,
+			last_seen_at = $2,
+			updated_at = $2
+		WHERE id = $3
+		RETURNING ` + store.WorkerColumns
+
+	worker, err := store.ScanWorker(h.db.QueryRowContext(r.Context(), query, models.WorkerStatusAlive, time.Now(), workerID(r)))
+
+	if err == sql.ErrNoRows {
+		http.Error(w, "Worker not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to record worker heartbeat", http.StatusInternalServerError)
+		return
+	}
+
+	h.writeWorker(w, r, worker)
+}
+
+func (h *WorkerHandler) GetWorker(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT ` + store.WorkerColumns + `
+		FROM workers
+		WHERE id = $1`
+
+	worker, err := store.ScanWorker(h.db.QueryRowContext(r.Context(), query, workerID(r)))
+
+	if err == sql.ErrNoRows {
+		http.Error(w, "Worker not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to get worker", http.StatusInternalServerError)
+		return
+	}
+
+	h.writeWorker(w, r, worker)
+}
+
+// ListWorkers lists registered workers with the tasks they are running,
+// optionally only those with ?status=alive or ?status=dead
+func (h *WorkerHandler) ListWorkers(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != models.WorkerStatusAlive && status != models.WorkerStatusDead {
+		http.Error(w, "Status must be alive or dead", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		SELECT ` + store.WorkerColumns + `
+		FROM workers
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY id`
+
+	rows, err := h.db.QueryContext(r.Context(), query, status)
+	if err != nil {
+		http.Error(w, "Failed to list workers", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	workers := []models.Worker{}
+	for rows.Next() {
+		worker, err := store.ScanWorker(rows)
+		if err != nil {
+			http.Error(w, "Failed to scan worker", http.StatusInternalServerError)
+			return
+		}
+		workers = append(workers, worker)
+	}
+
+	if err = rows.Err(); err != nil {
+		http.Error(w, "Error iterating workers", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.addLeasedTasks(r.Context(), workers); err != nil {
+		http.Error(w, "Failed to list leased tasks", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workers)
+}
+
+// workerID returns the worker ID in the URL. IDs are chosen by workers, so
+// they may contain characters that had to be escaped.
+func workerID(r *http.Request) string {
+	id := chi.URLParam(r, "id")
+	if unescaped, err := url.PathUnescape(id); err == nil {
+		return unescaped
+	}
+	return id
+}
+
+// writeWorker writes a worker along with its leased tasks as the response
+func (h *WorkerHandler) writeWorker(w http.ResponseWriter, r *http.Request, worker models.Worker) {
+	workers := []models.Worker{worker}
+	if err := h.addLeasedTasks(r.Context(), workers); err != nil {
+		http.Error(w, "Failed to list leased tasks", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workers[0])
+}
+
+// addLeasedTasks fills in the tasks leased to each of workers
+func (h *WorkerHandler) addLeasedTasks(ctx context.Context, workers []models.Worker) error {
+	if len(workers) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(workers))
+	index := make(map[string]int, len(workers))
+	for i, worker := range workers {
+		ids[i] = worker.ID
+		index[worker.ID] = i
+	}
+
+	query := `
+		SELECT lease_owner, id, title, queue, progress, lease_expires_at
+		FROM tasks
+		WHERE status = $1 AND lease_owner = ANY($2)
+		ORDER BY id`
+
+	rows, err := h.db.QueryContext(ctx, query, models.TaskStatusInProgress, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var owner string
+		var task models.LeasedTask
+		if err := rows.Scan(&owner, &task.ID, &task.Title, &task.Queue, &task.Progress, &task.LeaseExpiresAt); err != nil {
+			return err
+		}
+		if i, ok := index[owner]; ok {
+			workers[i].LeasedTasks = append(workers[i].LeasedTasks, task)
+		}
+	}
+	return rows.Err()
+}
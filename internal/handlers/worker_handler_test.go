@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// workerRowColumns are the column names returned by worker queries
+var workerRowColumns = []string{"id", "hostname", "queues", "version", "concurrency", "status", "last_seen_at", "created_at", "updated_at"}
+
+// leasedTaskQuery selects the tasks leased to workers
+const leasedTaskQuery = `SELECT lease_owner, id, title, queue, progress, lease_expires_at FROM tasks WHERE status = \$1 AND lease_owner = ANY\(\$2\) ORDER BY id`
+
+// addWorkerRow appends a worker with the given status to rows
+func addWorkerRow(rows *sqlmock.Rows, id, status string) *sqlmock.Rows {
+	return rows.AddRow(id, "host-1", "{emails,sms}", "1.2.0", 4, status, time.Now(), time.Now(), time.Now())
+}
+
+// leasedTaskRows returns leased task rows, each a lease owner and task ID
+func leasedTaskRows(leases ...interface{}) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"lease_owner", "id", "title", "queue", "progress", "lease_expires_at"})
+	for i := 0; i < len(leases); i += 2 {
+		rows.AddRow(leases[i], leases[i+1], "Test Task", "emails", nil, time.Now().Add(time.Minute))
+	}
+	return rows
+}
+
+func setupTestWorkerHandler(t *testing.T) (*WorkerHandler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+
+	return NewWorkerHandler(db), mock
+}
+
+func TestWorkerHandler_RegisterWorker(t *testing.T) {
+	handler, mock := setupTestWorkerHandler(t)
+
+	insertQuery := `INSERT INTO workers \(id, hostname, queues, version, concurrency, status, last_seen_at, created_at, updated_at\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, \$7, \$7, \$7\) ON CONFLICT \(id\) DO UPDATE (.+) RETURNING ` + regexp.QuoteMeta(store.WorkerColumns)
+
+	tests := []struct {
+		name           string
+		payload        string
+		expectedStatus int
+		mockDB         func()
+	}{
+		{
+			name:           "Worker with details",
+			payload:        `{"worker_id": "worker-1", "hostname": "host-1", "queues": ["emails", "sms"], "version": "1.2.0", "concurrency": 4}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectQuery(insertQuery).
+					WithArgs("worker-1", "host-1", pq.Array([]string{"emails", "sms"}), "1.2.0", 4, "alive", sqlmock.AnyArg()).
+					WillReturnRows(addWorkerRow(sqlmock.NewRows(workerRowColumns), "worker-1", "alive"))
+				mock.ExpectQuery(leasedTaskQuery).
+					WithArgs("in_progress", pq.Array([]string{"worker-1"})).
+					WillReturnRows(leasedTaskRows())
+			},
+		},
+		{
+			name:           "Worker with defaults",
+			payload:        `{"worker_id": "worker-2"}`,
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectQuery(insertQuery).
+					WithArgs("worker-2", "", pq.Array([]string{}), "", 1, "alive", sqlmock.AnyArg()).
+					WillReturnRows(addWorkerRow(sqlmock.NewRows(workerRowColumns), "worker-2", "alive"))
+				mock.ExpectQuery(leasedTaskQuery).
+					WithArgs("in_progress", pq.Array([]string{"worker-2"})).
+					WillReturnRows(leasedTaskRows())
+			},
+		},
+		{
+			name:           "Missing worker ID",
+			payload:        `{"hostname": "host-1"}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid concurrency",
+			payload:        `{"worker_id": "worker-1", "concurrency": -1}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Invalid queue",
+			payload:        `{"worker_id": "worker-1", "queues": ["emails eu"]}`,
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Database error",
+			payload:        `{"worker_id": "worker-1"}`,
+			expectedStatus: http.StatusInternalServerError,
+			mockDB: func() {
+				mock.ExpectQuery(insertQuery).
+					WillReturnError(errors.New("database error"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockDB()
+
+			req := httptest.NewRequest("POST", "/api/v1/workers", strings.NewReader(tt.payload))
+			w := httptest.NewRecorder()
+
+			handler.RegisterWorker(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestWorkerHandler_WorkerHeartbeat(t *testing.T) {
+	handler, mock := setupTestWorkerHandler(t)
+
+	updateQuery := `UPDATE workers SET status = \$1, last_seen_at = \$2, updated_at = \$2 WHERE id = \$3 RETURNING ` + regexp.QuoteMeta(store.WorkerColumns)
+
+	t.Run("Dead worker revived", func(t *testing.T) {
+		mock.ExpectQuery(updateQuery).
+			WithArgs("alive", sqlmock.AnyArg(), "worker-1").
+			WillReturnRows(addWorkerRow(sqlmock.NewRows(workerRowColumns), "worker-1", "alive"))
+		mock.ExpectQuery(leasedTaskQuery).
+			WithArgs("in_progress", pq.Array([]string{"worker-1"})).
+			WillReturnRows(leasedTaskRows("worker-1", 7))
+
+		w := httptest.NewRecorder()
+		handler.WorkerHeartbeat(w, withURLParam(httptest.NewRequest("POST", "/api/v1/workers/worker-1/heartbeat", nil), "id", "worker-1"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response models.Worker
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, "alive", response.Status)
+		if assert.Len(t, response.LeasedTasks, 1) {
+			assert.Equal(t, int64(7), response.LeasedTasks[0].ID)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unregistered worker", func(t *testing.T) {
+		mock.ExpectQuery(updateQuery).
+			WithArgs("alive", sqlmock.AnyArg(), "worker-2").
+			WillReturnRows(sqlmock.NewRows(workerRowColumns))
+
+		w := httptest.NewRecorder()
+		handler.WorkerHeartbeat(w, withURLParam(httptest.NewRequest("POST", "/api/v1/workers/worker-2/heartbeat", nil), "id", "worker-2"))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestWorkerHandler_GetWorker(t *testing.T) {
+	handler, mock := setupTestWorkerHandler(t)
+
+	selectQuery := `SELECT ` + regexp.QuoteMeta(store.WorkerColumns) + ` FROM workers WHERE id = \$1`
+
+	t.Run("Existing worker", func(t *testing.T) {
+		mock.ExpectQuery(selectQuery).
+			WithArgs("worker-1").
+			WillReturnRows(addWorkerRow(sqlmock.NewRows(workerRowColumns), "worker-1", "dead"))
+		mock.ExpectQuery(leasedTaskQuery).
+			WithArgs("in_progress", pq.Array([]string{"worker-1"})).
+			WillReturnRows(leasedTaskRows())
+
+		w := httptest.NewRecorder()
+		handler.GetWorker(w, withURLParam(httptest.NewRequest("GET", "/api/v1/workers/worker-1", nil), "id", "worker-1"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response models.Worker
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, "dead", response.Status)
+		assert.Equal(t, []string{"emails", "sms"}, response.Queues)
+		assert.Equal(t, []models.LeasedTask{}, response.LeasedTasks)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Missing worker", func(t *testing.T) {
+		mock.ExpectQuery(selectQuery).
+			WithArgs("worker-2").
+			WillReturnRows(sqlmock.NewRows(workerRowColumns))
+
+		w := httptest.NewRecorder()
+		handler.GetWorker(w, withURLParam(httptest.NewRequest("GET", "/api/v1/workers/worker-2", nil), "id", "worker-2"))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestWorkerHandler_ListWorkers(t *testing.T) {
+	handler, mock := setupTestWorkerHandler(t)
+
+	listQuery := `SELECT ` + regexp.QuoteMeta(store.WorkerColumns) + ` FROM workers WHERE \(\$1 = '' OR status = \$1\) ORDER BY id`
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedLeases map[string][]int64
+		mockDB         func()
+	}{
+		{
+			name:           "Workers with their leases",
+			expectedStatus: http.StatusOK,
+			expectedLeases: map[string][]int64{"worker-1": {3, 8}, "worker-2": nil},
+			mockDB: func() {
+				mock.ExpectQuery(listQuery).
+					WithArgs("").
+					WillReturnRows(addWorkerRow(addWorkerRow(sqlmock.NewRows(workerRowColumns), "worker-1", "alive"), "worker-2", "dead"))
+				mock.ExpectQuery(leasedTaskQuery).
+					WithArgs("in_progress", pq.Array([]string{"worker-1", "worker-2"})).
+					WillReturnRows(leasedTaskRows("worker-1", 3, "worker-1", 8))
+			},
+		},
+		{
+			name:           "Only live workers",
+			query:          "?status=alive",
+			expectedStatus: http.StatusOK,
+			expectedLeases: map[string][]int64{},
+			mockDB: func() {
+				mock.ExpectQuery(listQuery).
+					WithArgs("alive").
+					WillReturnRows(sqlmock.NewRows(workerRowColumns))
+			},
+		},
+		{
+			name:           "Invalid status",
+			query:          "?status=idle",
+			expectedStatus: http.StatusBadRequest,
+			mockDB:         func() {},
+		},
+		{
+			name:           "Database error",
+			expectedStatus: http.StatusInternalServerError,
+			mockDB: func() {
+				mock.ExpectQuery(listQuery).
+					WillReturnError(errors.New("database error"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockDB()
+
+			req := httptest.NewRequest("GET", "/api/v1/workers"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			handler.ListWorkers(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedLeases != nil {
+				var response []models.Worker
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+				leases := map[string][]int64{}
+				for _, worker := range response {
+					var ids []int64
+					for _, task := range worker.LeasedTasks {
+						ids = append(ids, task.ID)
+					}
+					leases[worker.ID] = ids
+				}
+				assert.Equal(t, tt.expectedLeases, leases)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
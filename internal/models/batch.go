@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Batch groups tasks created together so their progress can be followed.
+// Counts holds the number of tasks in each status, and Progress the
+// percentage of them that are completed, failed, dead or cancelled. Once all
+// of them are, the batch is finished: CallbackTask is created and
+// CallbackURL is notified.
+type Batch struct {
+	ID             int64              `json:"id"`
+	Name           string             `json:"name"`
+	CallbackTask   *CreateTaskRequest `json:"callback_task,omitempty"`
+	CallbackURL    *string            `json:"callback_url,omitempty"`
+	CallbackTaskID *int64             `json:"callback_task_id,omitempty"`
+	Total          int                `json:"total"`
+	Counts         map[string]int     `json:"counts"`
+	Progress       int                `json:"progress"`
+	FinishedAt     *time.Time         `json:"finished_at,omitempty"`
+	CreatedAt      time.Time          `json:"created_at"`
+	UpdatedAt      time.Time          `json:"updated_at"`
+}
+
+// CreateBatchRequest creates a batch together with its tasks. The batch is
+// created only if all of its tasks can be.
+type CreateBatchRequest struct {
+	Name         string              `json:"name"`
+	Tasks        []CreateTaskRequest `json:"tasks" validate:"required"`
+	CallbackTask *CreateTaskRequest  `json:"callback_task,omitempty"`
+	CallbackURL  string              `json:"callback_url,omitempty" validate:"omitempty,url"`
+}
+
+// CreateBatchResponse reports the batch created and the result for each of
+// its tasks
+type CreateBatchResponse struct {
+	ID int64 `json:"id"`
+	TaskBatchResponse
+}
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// Queue holds the settings of a named queue. Unset settings fall back to
+// the server defaults. RateLimit tasks may be claimed per RatePeriodSeconds,
+// which defaults to one second.
+type Queue struct {
+	Name               string    `json:"name"`
+	DefaultMaxAttempts *int      `json:"default_max_attempts,omitempty"`
+	LeaseSeconds       *int      `json:"lease_seconds,omitempty"`
+	MaxInFlight        *int      `json:"max_in_flight,omitempty"`
+	RateLimit          *int      `json:"rate_limit,omitempty"`
+	RatePeriodSeconds  *int      `json:"rate_period_seconds,omitempty"`
+	Paused             bool      `json:"paused"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// CreateQueueRequest creates a named queue
+type CreateQueueRequest struct {
+	Name               string `json:"name" validate:"required"`
+	DefaultMaxAttempts *int   `json:"default_max_attempts,omitempty" validate:"omitempty,min=1"`
+	LeaseSeconds       *int   `json:"lease_seconds,omitempty" validate:"omitempty,min=1"`
+	MaxInFlight        *int   `json:"max_in_flight,omitempty" validate:"omitempty,min=1"`
+	RateLimit          *int   `json:"rate_limit,omitempty" validate:"omitempty,min=1"`
+	RatePeriodSeconds  *int   `json:"rate_period_seconds,omitempty" validate:"omitempty,min=1"`
+	Paused             bool   `json:"paused"`
+}
+
+// UpdateQueueRequest changes the settings of a queue that are set. Setting
+// MaxInFlight, RateLimit or RatePeriodSeconds to 0 removes it.
+type UpdateQueueRequest struct {
+	DefaultMaxAttempts *int  `json:"default_max_attempts,omitempty" validate:"omitempty,min=1"`
+	LeaseSeconds       *int  `json:"lease_seconds,omitempty" validate:"omitempty,min=1"`
+	MaxInFlight        *int  `json:"max_in_flight,omitempty" validate:"omitempty,min=0"`
+	RateLimit          *int  `json:"rate_limit,omitempty" validate:"omitempty,min=0"`
+	RatePeriodSeconds  *int  `json:"rate_period_seconds,omitempty" validate:"omitempty,min=0"`
+	Paused             *bool `json:"paused,omitempty"`
+}
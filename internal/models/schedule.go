@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// Catch-up policies decide what happens to ticks missed while no scheduler
+// was running
+const (
+	// CatchUpSkip drops missed ticks and only enqueues ticks that are on time
+	CatchUpSkip = "skip"
+	// CatchUpOnce enqueues a single task for any number of missed ticks
+	CatchUpOnce = "once"
+	// CatchUpAll enqueues a task for every missed tick
+	CatchUpAll = "all"
+)
+
+// Schedule enqueues a task built from TaskTemplate every time its cron
+// expression fires in Timezone
+type Schedule struct {
+	ID             int64             `json:"id"`
+	Name           string            `json:"name"`
+	CronExpression string            `json:"cron_expression"`
+	Timezone       string            `json:"timezone"`
+	TaskTemplate   CreateTaskRequest `json:"task_template"`
+	CatchUp        string            `json:"catch_up"`
+	Enabled        bool              `json:"enabled"`
+	NextRunAt      *time.Time        `json:"next_run_at,omitempty"`
+	LastRunAt      *time.Time        `json:"last_run_at,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
+
+// CreateScheduleRequest creates a new schedule. Timezone defaults to UTC,
+// CatchUp to skip and Enabled to true.
+type CreateScheduleRequest struct {
+	Name           string            `json:"name" validate:"required"`
+	CronExpression string            `json:"cron_expression" validate:"required"`
+	Timezone       string            `json:"timezone"`
+	TaskTemplate   CreateTaskRequest `json:"task_template" validate:"required"`
+	CatchUp        string            `json:"catch_up" validate:"omitempty,oneof=skip once all"`
+	Enabled        *bool             `json:"enabled,omitempty"`
+}
+
+// UpdateScheduleRequest changes the fields of a schedule that are set
+type UpdateScheduleRequest struct {
+	Name           *string            `json:"name,omitempty"`
+	CronExpression *string            `json:"cron_expression,omitempty"`
+	Timezone       *string            `json:"timezone,omitempty"`
+	TaskTemplate   *CreateTaskRequest `json:"task_template,omitempty"`
+	CatchUp        *string            `json:"catch_up,omitempty" validate:"omitempty,oneof=skip once all"`
+	Enabled        *bool              `json:"enabled,omitempty"`
+}
@@ -1,25 +1,184 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
+// Task statuses
+const (
+	TaskStatusPending    = "pending"
+	TaskStatusInProgress = "in_progress"
+	TaskStatusCompleted  = "completed"
+	TaskStatusFailed     = "failed"
+	TaskStatusDead       = "dead"
+	TaskStatusBlocked    = "blocked"
+	TaskStatusCancelled  = "cancelled"
+)
+
+// Unique key policies, applied when a task is created with a unique key
+// already held by a pending, blocked or in-progress task
+const (
+	// UniquePolicyReject refuses to create the task
+	UniquePolicyReject = "reject"
+	// UniquePolicyReturnExisting returns the task holding the key instead
+	UniquePolicyReturnExisting = "return_existing"
+	// UniquePolicyReplace cancels the pending or blocked task holding the
+	// key and creates the new one, which the cancelled task's dependents then
+	// wait for. A task already in progress is not replaced.
+	UniquePolicyReplace = "replace"
+)
+
 type Task struct {
-	ID          int64     `json:"id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Status      string    `json:"status"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID                int64           `json:"id"`
+	Title             string          `json:"title"`
+	Description       string          `json:"description"`
+	Status            string          `json:"status"`
+	Queue             string          `json:"queue"`
+	Priority          int             `json:"priority"`
+	UniqueKey         *string         `json:"unique_key,omitempty"`
+	BatchID           *int64          `json:"batch_id,omitempty"`
+	LeaseOwner        *string         `json:"lease_owner,omitempty"`
+	LeaseExpiresAt    *time.Time      `json:"lease_expires_at,omitempty"`
+	Attempts          int             `json:"attempts"`
+	MaxAttempts       int             `json:"max_attempts"`
+	LastError         *string         `json:"last_error,omitempty"`
+	Payload           json.RawMessage `json:"payload,omitempty"`
+	Result            json.RawMessage `json:"result,omitempty"`
+	RunAt             time.Time       `json:"run_at"`
+	Progress          *int            `json:"progress,omitempty"`
+	ProgressMessage   *string         `json:"progress_message,omitempty"`
+	CancelRequestedAt *time.Time      `json:"cancel_requested_at,omitempty"`
+	TimeoutSeconds    *int            `json:"timeout_seconds,omitempty"`
+	DeadlineAt        *time.Time      `json:"deadline_at,omitempty"`
+	CreatedAt         time.Time       `json:"created_at"`
+	UpdatedAt         time.Time       `json:"updated_at"`
 }
 
+// CreateTaskRequest creates a new pending task. RunAt (RFC3339) or
+// DelaySeconds postpone the task; at most one of them may be set. Tasks with
+// a higher Priority are claimed first. Queue defaults to "default", and
+// MaxAttempts to the queue's default_max_attempts. Payload is arbitrary JSON
+// input for the worker. At most one pending, blocked or in-progress task may
+// have a given UniqueKey; UniquePolicy (reject by default) decides what happens
+// when it is taken. A task with DependsOn stays blocked until all of those
+// tasks complete, and is cancelled if one of them fails. TimeoutSeconds
+// limits how long each attempt may run, however long its lease is renewed.
 type CreateTaskRequest struct {
-	Title       string `json:"title" validate:"required"`
-	Description string `json:"description"`
+	Title          string          `json:"title" validate:"required"`
+	Description    string          `json:"description"`
+	Payload        json.RawMessage `json:"payload,omitempty"`
+	Queue          string          `json:"queue,omitempty"`
+	Priority       int             `json:"priority,omitempty"`
+	MaxAttempts    *int            `json:"max_attempts,omitempty" validate:"omitempty,min=1"`
+	RunAt          *time.Time      `json:"run_at,omitempty"`
+	DelaySeconds   int             `json:"delay_seconds,omitempty" validate:"omitempty,min=0"`
+	UniqueKey      string          `json:"unique_key,omitempty" validate:"omitempty,max=255"`
+	UniquePolicy   string          `json:"unique_policy,omitempty" validate:"omitempty,oneof=reject return_existing replace"`
+	DependsOn      []int64         `json:"depends_on,omitempty" validate:"omitempty,max=100"`
+	TimeoutSeconds *int            `json:"timeout_seconds,omitempty" validate:"omitempty,min=1"`
 }
 
 type UpdateTaskRequest struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Status      string `json:"status" validate:"oneof=pending in_progress completed"`
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Status      string          `json:"status" validate:"oneof=pending in_progress completed"`
+	Priority    *int            `json:"priority,omitempty"`
+}
+
+// ClaimTaskRequest is sent by a worker to lease the next pending task from
+// one of Queues, or from any queue when Queues is empty. LeaseSeconds is
+// optional and falls back to the queue's lease_seconds, then the server
+// default.
+type ClaimTaskRequest struct {
+	WorkerID     string   `json:"worker_id" validate:"required"`
+	LeaseSeconds int      `json:"lease_seconds"`
+	Queues       []string `json:"queues,omitempty"`
+}
+
+// HeartbeatRequest extends the lease held by WorkerID. Progress is a
+// percentage between 0 and 100; Progress and Message are left unchanged when
+// omitted.
+type HeartbeatRequest struct {
+	WorkerID     string  `json:"worker_id" validate:"required"`
+	LeaseSeconds int     `json:"lease_seconds"`
+	Progress     *int    `json:"progress" validate:"omitempty,min=0,max=100"`
+	Message      *string `json:"message"`
+}
+
+// CompleteTaskRequest marks a leased task as completed with an optional
+// JSON result
+type CompleteTaskRequest struct {
+	WorkerID string          `json:"worker_id" validate:"required"`
+	Result   json.RawMessage `json:"result,omitempty"`
+}
+
+// CompleteTaskBatchRequest marks many tasks leased by WorkerID as completed
+// in one transaction
+type CompleteTaskBatchRequest struct {
+	WorkerID string          `json:"worker_id" validate:"required"`
+	Tasks    []CompletedTask `json:"tasks"`
+}
+
+// CompletedTask is one task of a batch complete, with an optional JSON
+// result
+type CompletedTask struct {
+	ID     int64           `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// FailTaskRequest reports a failed attempt at a leased task. The task is
+// retried with backoff unless Retry is explicitly false.
+type FailTaskRequest struct {
+	WorkerID string `json:"worker_id" validate:"required"`
+	Error    string `json:"error" validate:"required"`
+	Retry    *bool  `json:"retry,omitempty"`
+}
+
+// TaskAttempt is a single failed attempt at running a task
+type TaskAttempt struct {
+	ID        int64     `json:"id"`
+	TaskID    int64     `json:"task_id"`
+	Attempt   int       `json:"attempt"`
+	WorkerID  *string   `json:"worker_id,omitempty"`
+	Error     string    `json:"error"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TaskBatchResult is the outcome of one task of a batch create or complete.
+// Status is the HTTP status the request for that task alone would have got,
+// with the task's ID on success or the reason it failed.
+type TaskBatchResult struct {
+	Status int    `json:"status"`
+	ID     int64  `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// TaskBatchResponse reports the outcome of a batch create or complete, with
+// one result per task in request order
+type TaskBatchResponse struct {
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []TaskBatchResult `json:"results"`
+}
+
+// TaskDependency records that TaskID waits for DependsOnID to complete
+type TaskDependency struct {
+	TaskID      int64 `json:"task_id"`
+	DependsOnID int64 `json:"depends_on_id"`
+}
+
+// TaskGraph is the workflow a task belongs to: every task it depends on or
+// that depends on it, directly or not, and the dependencies between them
+type TaskGraph struct {
+	Tasks        []Task           `json:"tasks"`
+	Dependencies []TaskDependency `json:"dependencies"`
+}
+
+// ReplayDeadLetterRequest selects dead tasks to return to the queue, either
+// by ID or all of them
+type ReplayDeadLetterRequest struct {
+	IDs []int64 `json:"ids"`
+	All bool    `json:"all"`
 }
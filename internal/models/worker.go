@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Worker statuses
+const (
+	WorkerStatusAlive = "alive"
+	WorkerStatusDead  = "dead"
+)
+
+// Worker is a registered worker process. ID is the worker_id it leases tasks
+// with. A worker that misses heartbeats for longer than the server's worker
+// timeout is marked dead and its leases are released.
+type Worker struct {
+	ID          string       `json:"id"`
+	Hostname    string       `json:"hostname"`
+	Queues      []string     `json:"queues"`
+	Version     string       `json:"version"`
+	Concurrency int          `json:"concurrency"`
+	Status      string       `json:"status"`
+	LastSeenAt  time.Time    `json:"last_seen_at"`
+	LeasedTasks []LeasedTask `json:"leased_tasks"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// LeasedTask summarizes a task a worker is running
+type LeasedTask struct {
+	ID             int64      `json:"id"`
+	Title          string     `json:"title"`
+	Queue          string     `json:"queue"`
+	Progress       *int       `json:"progress,omitempty"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+}
+
+// RegisterWorkerRequest registers a worker, or updates the registration of
+// one that restarted under the same ID. Concurrency defaults to 1.
+type RegisterWorkerRequest struct {
+	WorkerID    string   `json:"worker_id" validate:"required"`
+	Hostname    string   `json:"hostname"`
+	Queues      []string `json:"queues"`
+	Version     string   `json:"version"`
+	Concurrency int      `json:"concurrency" validate:"omitempty,min=1"`
+}
@@ -0,0 +1,133 @@
+// Package notify wakes claims waiting for tasks. Whenever a task becomes
+// claimable, a trigger on the tasks table sends a Postgres notification
+// naming its queue, and every server listens for them so that a waiting
+// claim is retried as soon as there may be a task for it.
+package notify
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Channel is the Postgres channel notified with the queue of tasks that
+// became claimable, as named by the notify_tasks_available trigger
+const Channel = "tasks_available"
+
+// Notifier listens for task notifications, fanning them out to waiting
+// claims
+type Notifier struct {
+	mu      sync.Mutex
+	waiters map[*waiter]struct{}
+	done    chan struct{}
+	closing sync.Once
+}
+
+// waiter is a claim waiting for a task on one of queues, or on any queue
+// when queues is empty
+type waiter struct {
+	queues []string
+	ready  chan struct{}
+}
+
+// NewNotifier creates a notifier with no waiting claims
+func NewNotifier() *Notifier {
+	return &Notifier{
+		waiters: make(map[*waiter]struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Wait registers a claim waiting for a task on one of queues, or on any
+// queue when queues is empty. The returned channel is closed on the next
+// notification for one of them, and stop must be called once the claim is
+// done waiting.
+func (n *Notifier) Wait(queues []string) (ready <-chan struct{}, stop func()) {
+	w := &waiter{queues: queues, ready: make(chan struct{})}
+
+	n.mu.Lock()
+	n.waiters[w] = struct{}{}
+	n.mu.Unlock()
+
+	return w.ready, func() {
+		n.mu.Lock()
+		delete(n.waiters, w)
+		n.mu.Unlock()
+	}
+}
+
+// Done is closed once the notifier is closed, when waiting claims should
+// give up
+func (n *Notifier) Done() <-chan struct{} {
+	return n.done
+}
+
+// Close releases waiting claims, e.g. when the server is shutting down
+func (n *Notifier) Close() {
+	n.closing.Do(func() { close(n.done) })
+}
+
+// Listen relays notifications to waiting claims until ctx is cancelled.
+// The listener reconnects by itself when its connection is lost, after which
+// every claim is woken in case it missed a notification.
+func (n *Notifier) Listen(ctx context.Context, dsn string) error {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Error listening for task notifications: %v", err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(Channel); err != nil {
+		return err
+	}
+
+	// Pings detect a dead connection while no notifications arrive
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case notification := <-listener.Notify:
+			if notification == nil {
+				n.wake("")
+			} else {
+				n.wake(notification.Extra)
+			}
+		case <-ping.C:
+			go listener.Ping()
+		}
+	}
+}
+
+// wake releases the claims waiting on queue, or every claim when queue is
+// empty. Each one is released once; it waits again with a new call to Wait.
+func (n *Notifier) wake(queue string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for w := range n.waiters {
+		if queue == "" || w.wants(queue) {
+			close(w.ready)
+			delete(n.waiters, w)
+		}
+	}
+}
+
+// wants reports whether the waiter claims from queue
+func (w *waiter) wants(queue string) bool {
+	if len(w.queues) == 0 {
+		return true
+	}
+	for _, q := range w.queues {
+		if q == queue {
+			return true
+		}
+	}
+	return false
+}
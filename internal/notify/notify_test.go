@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// released reports whether ready has been closed
+func released(ready <-chan struct{}) bool {
+	select {
+	case <-ready:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestNotifier_Wake(t *testing.T) {
+	tests := []struct {
+		name     string
+		queues   []string
+		queue    string
+		expected bool
+	}{
+		{name: "Waiting on the queue", queues: []string{"emails", "reports"}, queue: "reports", expected: true},
+		{name: "Waiting on other queues", queues: []string{"emails"}, queue: "reports", expected: false},
+		{name: "Waiting on any queue", queue: "reports", expected: true},
+		{name: "Woken after reconnecting", queues: []string{"emails"}, queue: "", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewNotifier()
+			ready, stop := n.Wait(tt.queues)
+			defer stop()
+
+			n.wake(tt.queue)
+
+			assert.Equal(t, tt.expected, released(ready))
+		})
+	}
+}
+
+func TestNotifier_WakeOnce(t *testing.T) {
+	n := NewNotifier()
+	ready, stop := n.Wait(nil)
+
+	n.wake("emails")
+	n.wake("emails")
+	stop()
+
+	assert.True(t, released(ready))
+	assert.Empty(t, n.waiters)
+}
+
+func TestNotifier_Stop(t *testing.T) {
+	n := NewNotifier()
+	ready, stop := n.Wait(nil)
+
+	stop()
+	n.wake("emails")
+
+	assert.False(t, released(ready))
+	assert.Empty(t, n.waiters)
+}
+
+func TestNotifier_Close(t *testing.T) {
+	n := NewNotifier()
+	assert.False(t, released(n.Done()))
+
+	n.Close()
+	n.Close()
+
+	assert.True(t, released(n.Done()))
+}
@@ -0,0 +1,277 @@
+package reaper
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+)
+
+// maxWebhookAttempts is how many times a batch's webhook is tried before
+// giving up
+const maxWebhookAttempts = 10
+
+// FinishBatches marks batches finished once none of their tasks is pending,
+// in progress or blocked, and creates their callback tasks. Batches with a
+// callback URL become due for DeliverWebhooks. It returns the number of
+// batches finished.
+func (r *Reaper) FinishBatches(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		finished, err := r.finishBatches(ctx)
+		if err != nil {
+			return total, err
+		}
+		total += finished
+
+		if finished < r.config.BatchSize {
+			return total, nil
+		}
+	}
+}
+
+// finishBatches finishes up to BatchSize batches
+func (r *Reaper) finishBatches(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, callback_task
+		FROM batches b
+		WHERE finished_at IS NULL
+			AND NOT EXISTS (
+				SELECT 1
+				FROM tasks
+				WHERE batch_id = b.id AND status IN ($1, $2, $3)
+			)
+		ORDER BY id
+		LIMIT $4
+		FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query, models.TaskStatusPending, models.TaskStatusInProgress, models.TaskStatusBlocked, r.config.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("error selecting finished batches: %v", err)
+	}
+
+	type finishedBatch struct {
+		id           int64
+		callbackTask []byte
+	}
+	var batches []finishedBatch
+	for rows.Next() {
+		var batch finishedBatch
+		if err := rows.Scan(&batch.id, &batch.callbackTask); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning finished batch: %v", err)
+		}
+		batches = append(batches, batch)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating finished batches: %v", err)
+	}
+
+	now := time.Now()
+	for _, batch := range batches {
+		var callbackTaskID sql.NullInt64
+		if batch.callbackTask != nil {
+			taskID, err := createCallbackTask(ctx, tx, batch.id, batch.callbackTask, now)
+			if err != nil {
+				return 0, err
+			}
+			callbackTaskID = sql.NullInt64{Int64: taskID, Valid: taskID != 0}
+		}
+
+		query := `
+			UPDATE batches
+			SET finished_at = $1,
+				callback_task_id = $2,
+				webhook_next_at = CASE WHEN callback_url IS NOT NULL THEN $1 END,
+				updated_at = $1
+			WHERE id = $3`
+
+		if _, err := tx.ExecContext(ctx, query, now, callbackTaskID, batch.id); err != nil {
+			return 0, fmt.Errorf("error finishing batch %d: %v", batch.id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing finished batches: %v", err)
+	}
+
+	return len(batches), nil
+}
+
+// createCallbackTask creates the callback task of a finished batch and
+// returns its ID. A callback task that cannot be created, because it is
+// invalid or its unique key is taken, is logged and skipped with ID 0.
+func createCallbackTask(ctx context.Context, tx *sql.Tx, batchID int64, template []byte, now time.Time) (int64, error) {
+	var req models.CreateTaskRequest
+	if err := json.Unmarshal(template, &req); err != nil {
+		log.Printf("Skipping callback task of batch %d: %v", batchID, err)
+		return 0, nil
+	}
+
+	task, err := store.NewTaskFromRequest(req, now)
+	if err != nil {
+		log.Printf("Skipping callback task of batch %d: %v", batchID, err)
+		return 0, nil
+	}
+
+	taskID, err := store.InsertTask(ctx, tx, task, now)
+	if err == store.ErrUniqueKeyTaken {
+		log.Printf("Skipping callback task of batch %d: unique key %q is taken", batchID, task.UniqueKey)
+		return 0, nil
+	} else if _, ok := err.(store.ValidationError); ok {
+		log.Printf("Skipping callback task of batch %d: %v", batchID, err)
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("error creating callback task of batch %d: %v", batchID, err)
+	}
+	return taskID, nil
+}
+
+// webhook is a finished batch whose callback URL is due to be notified
+type webhook struct {
+	batchID  int64
+	url      string
+	attempts int
+}
+
+// DeliverWebhooks posts up to BatchSize finished batches to their callback
+// URLs. Failed deliveries are retried with backoff until maxWebhookAttempts
+// is reached. It returns the number of webhooks delivered.
+func (r *Reaper) DeliverWebhooks(ctx context.Context) (int, error) {
+	due, err := r.claimWebhooks(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, hook := range due {
+		batch, err := store.GetBatch(ctx, r.db, hook.batchID)
+		if err != nil {
+			return delivered, fmt.Errorf("error loading batch %d: %v", hook.batchID, err)
+		}
+
+		if err := r.postWebhook(ctx, hook.url, batch); err != nil {
+			log.Printf("Error delivering webhook of batch %d (attempt %d of %d): %v", hook.batchID, hook.attempts, maxWebhookAttempts, err)
+			continue
+		}
+
+		query := `
+			UPDATE batches
+			SET webhook_delivered_at = $1,
+				webhook_next_at = NULL,
+				updated_at = $1
+			WHERE id = $2`
+
+		if _, err := r.db.ExecContext(ctx, query, time.Now(), hook.batchID); err != nil {
+			return delivered, fmt.Errorf("error recording webhook of batch %d: %v", hook.batchID, err)
+		}
+		delivered++
+	}
+
+	return delivered, nil
+}
+
+// claimWebhooks selects the webhooks that are due and schedules their next
+// attempt before they are sent, so that concurrent reapers do not send them
+// too and a crash mid-delivery only delays them
+func (r *Reaper) claimWebhooks(ctx context.Context) ([]webhook, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, callback_url, webhook_attempts
+		FROM batches
+		WHERE webhook_next_at <= $1
+		ORDER BY webhook_next_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED`
+
+	now := time.Now()
+	rows, err := tx.QueryContext(ctx, query, now, r.config.BatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting due webhooks: %v", err)
+	}
+
+	var due []webhook
+	for rows.Next() {
+		var hook webhook
+		if err := rows.Scan(&hook.batchID, &hook.url, &hook.attempts); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning due webhook: %v", err)
+		}
+		due = append(due, hook)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating due webhooks: %v", err)
+	}
+
+	for i := range due {
+		due[i].attempts++
+
+		// The last attempt is not retried
+		var nextAt sql.NullTime
+		if due[i].attempts < maxWebhookAttempts {
+			nextAt = sql.NullTime{Time: now.Add(r.config.Backoff.Delay(due[i].attempts)), Valid: true}
+		}
+
+		query := `
+			UPDATE batches
+			SET webhook_attempts = $1,
+				webhook_next_at = $2
+			WHERE id = $3`
+
+		if _, err := tx.ExecContext(ctx, query, due[i].attempts, nextAt, due[i].batchID); err != nil {
+			return nil, fmt.Errorf("error scheduling webhook of batch %d: %v", due[i].batchID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing due webhooks: %v", err)
+	}
+
+	return due, nil
+}
+
+// postWebhook sends a batch to its callback URL. Any response other than
+// 2xx is an error.
+func (r *Reaper) postWebhook(ctx context.Context, url string, batch models.Batch) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package reaper
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	finishedBatchesQuery = `SELECT id, callback_task FROM batches b WHERE finished_at IS NULL AND NOT EXISTS \( SELECT 1 FROM tasks WHERE batch_id = b.id AND status IN \(\$1, \$2, \$3\) \) ORDER BY id LIMIT \$4 FOR UPDATE SKIP LOCKED`
+	finishBatchQuery     = `UPDATE batches SET finished_at = \$1, callback_task_id = \$2, webhook_next_at = CASE WHEN callback_url IS NOT NULL THEN \$1 END, updated_at = \$1 WHERE id = \$3`
+	dueWebhooksQuery     = `SELECT id, callback_url, webhook_attempts FROM batches WHERE webhook_next_at <= \$1 ORDER BY webhook_next_at LIMIT \$2 FOR UPDATE SKIP LOCKED`
+	scheduleWebhookQuery = `UPDATE batches SET webhook_attempts = \$1, webhook_next_at = \$2 WHERE id = \$3`
+	deliveredQuery       = `UPDATE batches SET webhook_delivered_at = \$1, webhook_next_at = NULL, updated_at = \$1 WHERE id = \$2`
+)
+
+func TestReaper_FinishBatches(t *testing.T) {
+	t.Run("Finishes batches and creates callback tasks", func(t *testing.T) {
+		reaper, mock, _ := setupTestReaper(t, 2)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(finishedBatchesQuery).
+			WithArgs("pending", "in_progress", "blocked", 2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "callback_task"}).
+				AddRow(1, []byte(`{"title": "Send report", "queue": "reports"}`)).
+				AddRow(2, nil))
+		mock.ExpectQuery(`INSERT INTO tasks`).
+			WithArgs("Send report", "", sql.NullString{}, "pending", "reports", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{}, sql.NullInt64{}).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(30))
+		mock.ExpectExec(finishBatchQuery).
+			WithArgs(sqlmock.AnyArg(), sql.NullInt64{Int64: 30, Valid: true}, int64(1)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(finishBatchQuery).
+			WithArgs(sqlmock.AnyArg(), sql.NullInt64{}, int64(2)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		// A full batch means there may be more
+		mock.ExpectBegin()
+		mock.ExpectQuery(finishedBatchesQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "callback_task"}))
+		mock.ExpectCommit()
+
+		finished, err := reaper.FinishBatches(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, finished)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid callback task is skipped", func(t *testing.T) {
+		reaper, mock, _ := setupTestReaper(t, 2)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(finishedBatchesQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "callback_task"}).
+				AddRow(3, []byte(`{"title": "", "queue": "reports"}`)))
+		mock.ExpectExec(finishBatchQuery).
+			WithArgs(sqlmock.AnyArg(), sql.NullInt64{}, int64(3)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		finished, err := reaper.FinishBatches(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, finished)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Database error", func(t *testing.T) {
+		reaper, mock, _ := setupTestReaper(t, 2)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(finishedBatchesQuery).
+			WillReturnError(errors.New("database error"))
+		mock.ExpectRollback()
+
+		_, err := reaper.FinishBatches(context.Background())
+
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestReaper_DeliverWebhooks(t *testing.T) {
+	batchColumns := []string{"id", "name", "callback_task", "callback_url", "callback_task_id", "finished_at", "created_at", "updated_at"}
+
+	tests := []struct {
+		name              string
+		responseStatus    int
+		attempts          int
+		expectedDelivered int
+		expectRetry       bool
+	}{
+		{name: "Delivered", responseStatus: http.StatusNoContent, attempts: 0, expectedDelivered: 1, expectRetry: true},
+		{name: "Failure is retried", responseStatus: http.StatusBadGateway, attempts: 0, expectedDelivered: 0, expectRetry: true},
+		{name: "Last attempt", responseStatus: http.StatusBadGateway, attempts: maxWebhookAttempts - 1, expectedDelivered: 0, expectRetry: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received models.Batch
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				json.NewDecoder(r.Body).Decode(&received)
+				w.WriteHeader(tt.responseStatus)
+			}))
+			defer server.Close()
+
+			reaper, mock, _ := setupTestReaper(t, 2)
+			now := time.Now()
+
+			var nextAt interface{} = sql.NullTime{}
+			if tt.expectRetry {
+				nextAt = sqlmock.AnyArg()
+			}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(dueWebhooksQuery).
+				WithArgs(sqlmock.AnyArg(), 2).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "callback_url", "webhook_attempts"}).AddRow(4, server.URL, tt.attempts))
+			mock.ExpectExec(scheduleWebhookQuery).
+				WithArgs(tt.attempts+1, nextAt, int64(4)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+			mock.ExpectQuery(`SELECT ` + regexp.QuoteMeta(store.BatchColumns) + ` FROM batches WHERE id = \$1`).
+				WithArgs(int64(4)).
+				WillReturnRows(sqlmock.NewRows(batchColumns).AddRow(4, "Import", nil, server.URL, nil, now, now, now))
+			mock.ExpectQuery(`SELECT status, COUNT\(\*\) FROM tasks WHERE batch_id = \$1 GROUP BY status`).
+				WithArgs(int64(4)).
+				WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).AddRow("completed", 3).AddRow("dead", 1))
+			if tt.expectedDelivered > 0 {
+				mock.ExpectExec(deliveredQuery).
+					WithArgs(sqlmock.AnyArg(), int64(4)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			delivered, err := reaper.DeliverWebhooks(context.Background())
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedDelivered, delivered)
+			assert.Equal(t, int64(4), received.ID)
+			assert.Equal(t, 4, received.Total)
+			assert.Equal(t, 100, received.Progress)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package reaper
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache is the subset of Redis operations used by the reaper
+type Cache interface {
+	Del(ctx context.Context, keys ...string) *redis.IntCmd
+}
+
+type Config struct {
+	Interval             time.Duration
+	BatchSize            int
+	Backoff              store.BackoffPolicy
+	IdempotencyRetention time.Duration
+	// WebhookTimeout bounds each delivery of a batch webhook
+	WebhookTimeout time.Duration
+	// WorkerTimeout is how long a registered worker may go without a
+	// heartbeat before it is marked dead
+	WorkerTimeout time.Duration
+	// WorkerRetention is how long dead workers are listed before they are
+	// deleted
+	WorkerRetention time.Duration
+}
+
+// NewConfig creates a new reaper configuration from environment variables
+func NewConfig() *Config {
+	return &Config{
+		Interval:             time.Duration(getEnvInt("REAPER_INTERVAL_SECONDS", 10)) * time.Second,
+		BatchSize:            getEnvInt("REAPER_BATCH_SIZE", 100),
+		Backoff:              store.NewBackoffPolicy(),
+		IdempotencyRetention: time.Duration(getEnvInt("IDEMPOTENCY_KEY_RETENTION_HOURS", 24)) * time.Hour,
+		WebhookTimeout:       time.Duration(getEnvInt("BATCH_WEBHOOK_TIMEOUT_SECONDS", 10)) * time.Second,
+		WorkerTimeout:        time.Duration(getEnvInt("WORKER_TIMEOUT_SECONDS", 30)) * time.Second,
+		WorkerRetention:      time.Duration(getEnvInt("WORKER_RETENTION_HOURS", 24)) * time.Hour,
+	}
+}
+
+// Reaper returns tasks whose lease has expired to the queue so that work
+// abandoned by crashed workers is picked up again, releasing the tasks of
+// registered workers that stop heartbeating without waiting for that. It
+// also purges idempotency keys past their retention, and finishes batches
+// whose tasks are all done.
+type Reaper struct {
+	db     *sql.DB
+	cache  Cache
+	client *http.Client
+	config *Config
+}
+
+func NewReaper(db *sql.DB, cache Cache, config *Config) *Reaper {
+	return &Reaper{
+		db:     db,
+		cache:  cache,
+		client: &http.Client{Timeout: config.WebhookTimeout},
+		config: config,
+	}
+}
+
+// Run reaps expired leases every Interval until ctx is cancelled. Batch
+// webhooks are delivered in a loop of their own, so that slow callback URLs
+// do not hold up reaping.
+func (r *Reaper) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.runWebhooks(ctx)
+	}()
+	defer wg.Wait()
+
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			dead, released, err := r.ReapDeadWorkers(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Error reaping dead workers: %v", err)
+			}
+			if dead > 0 || released > 0 {
+				log.Printf("Marked %d workers dead and released %d of their tasks", dead, released)
+			}
+
+			reaped, err := r.ReapExpired(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Error reaping expired leases: %v", err)
+			}
+			if reaped > 0 {
+				log.Printf("Reaped %d tasks with expired leases", reaped)
+			}
+
+			purged, err := r.PurgeIdempotencyKeys(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Error purging idempotency keys: %v", err)
+			}
+			if purged > 0 {
+				log.Printf("Purged %d expired idempotency keys", purged)
+			}
+
+			finished, err := r.FinishBatches(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Error finishing batches: %v", err)
+			}
+			if finished > 0 {
+				log.Printf("Finished %d batches", finished)
+			}
+
+			if _, err := r.PurgeDeadWorkers(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Error purging dead workers: %v", err)
+			}
+		}
+	}
+}
+
+// runWebhooks delivers due batch webhooks every Interval until ctx is
+// cancelled
+func (r *Reaper) runWebhooks(ctx context.Context) {
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.DeliverWebhooks(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Error delivering batch webhooks: %v", err)
+			}
+		}
+	}
+}
+
+// ReapExpired releases every in_progress task whose lease has expired or
+// that has run past its timeout, even if its worker is still renewing the
+// lease. Each expiry is recorded as a failed attempt: the task goes back to
+// pending with a backoff, or to dead once it has used up its max_attempts,
+// which cancels its blocked dependents. It returns the number of tasks
+// released.
+func (r *Reaper) ReapExpired(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		ids, cancelled, err := r.reapBatch(ctx)
+		if err != nil {
+			return total, err
+		}
+		total += len(ids)
+		r.uncache(ctx, append(ids, cancelled...))
+
+		if len(ids) < r.config.BatchSize {
+			return total, nil
+		}
+	}
+}
+
+// reapBatch releases up to BatchSize expired tasks and returns their IDs,
+// along with the IDs of dependents cancelled because a task died
+func (r *Reaper) reapBatch(ctx context.Context) ([]int64, []int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT ` + store.TaskColumns + `
+		FROM tasks
+		WHERE status = $1 AND (lease_expires_at < $2 OR deadline_at < $2)
+		ORDER BY LEAST(lease_expires_at, deadline_at)
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED`
+
+	now := time.Now()
+	rows, err := tx.QueryContext(ctx, query, models.TaskStatusInProgress, now, r.config.BatchSize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error selecting expired leases: %v", err)
+	}
+
+	var expired []models.Task
+	for rows.Next() {
+		task, err := store.ScanTask(rows)
+		if err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("error scanning expired task: %v", err)
+		}
+		expired = append(expired, task)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating expired tasks: %v", err)
+	}
+
+	ids, cancelled, err := failTasks(ctx, tx, expired, r.config.Backoff, now, func(task models.Task) string {
+		if task.DeadlineAt != nil && task.DeadlineAt.Before(now) && task.TimeoutSeconds != nil {
+			return fmt.Sprintf("timed out after %d seconds", *task.TimeoutSeconds)
+		}
+		return "lease expired"
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("error committing released tasks: %v", err)
+	}
+
+	return ids, cancelled, nil
+}
+
+// failTasks records a failed attempt, with the error given by reason, for
+// each of tasks locked by tx. It returns their IDs, along with the IDs of
+// dependents cancelled because a task died.
+func failTasks(ctx context.Context, tx *sql.Tx, tasks []models.Task, policy store.BackoffPolicy, now time.Time, reason func(models.Task) string) ([]int64, []int64, error) {
+	ids := make([]int64, 0, len(tasks))
+	var cancelled []int64
+	for _, task := range tasks {
+		failure := store.Failure{Error: reason(task), Retry: true}
+		if task.LeaseOwner != nil {
+			failure.WorkerID = *task.LeaseOwner
+		}
+		failed, err := store.FailTask(ctx, tx, task, failure, policy, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, task.ID)
+
+		dependents, err := store.ReleaseDependents(ctx, tx, failed, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		cancelled = append(cancelled, dependents...)
+	}
+	return ids, cancelled, nil
+}
+
+// uncache invalidates cached copies of tasks
+func (r *Reaper) uncache(ctx context.Context, ids []int64) {
+	if len(ids) == 0 {
+		return
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = fmt.Sprintf("task:%d", id)
+	}
+	r.cache.Del(ctx, keys...)
+}
+
+// PurgeIdempotencyKeys deletes idempotency keys first used longer than
+// IdempotencyRetention ago, in batches of BatchSize. It returns the number of
+// keys deleted.
+func (r *Reaper) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE key IN (
+			SELECT key
+			FROM idempotency_keys
+			WHERE created_at < $1
+			LIMIT $2
+		)`
+
+	cutoff := time.Now().Add(-r.config.IdempotencyRetention)
+	var total int64
+	for {
+		result, err := r.db.ExecContext(ctx, query, cutoff, r.config.BatchSize)
+		if err != nil {
+			return total, fmt.Errorf("error purging idempotency keys: %v", err)
+		}
+
+		purged, err := result.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("error getting purged idempotency keys: %v", err)
+		}
+		total += purged
+
+		if purged < int64(r.config.BatchSize) {
+			return total, nil
+		}
+	}
+}
+
+// getEnvInt retrieves a positive integer environment variable with a fallback value
+func getEnvInt(key string, fallback int) int {
+	val, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || val <= 0 {
+		return fallback
+	}
+	return val
+}
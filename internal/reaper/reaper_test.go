@@ -0,0 +1,256 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/queuet/internal/store"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// Mock Redis client
+type redisMock struct {
+	deleted []string
+}
+
+func (m *redisMock) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	m.deleted = append(m.deleted, keys...)
+	return redis.NewIntCmd(ctx)
+}
+
+// taskRowColumns are the column names produced by a store.TaskColumns select
+var taskRowColumns = []string{"id", "title", "description", "status", "queue", "priority", "unique_key", "batch_id", "lease_owner", "lease_expires_at", "attempts", "max_attempts", "last_error", "payload", "result", "run_at", "progress", "progress_message", "cancel_requested_at", "timeout_seconds", "deadline_at", "created_at", "updated_at"}
+
+// addExpiredTaskRow appends an in_progress task whose lease has expired
+func addExpiredTaskRow(rows *sqlmock.Rows, id int64, attempts int) *sqlmock.Rows {
+	return rows.AddRow(id, "Task", "", "in_progress", "default", 0, nil, nil, "worker-1", time.Now().Add(-time.Minute), attempts, 3, nil, nil, nil, time.Now(), nil, nil, nil, nil, nil, time.Now(), time.Now())
+}
+
+var (
+	expiredQuery = `SELECT ` + regexp.QuoteMeta(store.TaskColumns) + ` FROM tasks WHERE status = \$1 AND \(lease_expires_at < \$2 OR deadline_at < \$2\) ORDER BY LEAST\(lease_expires_at, deadline_at\) LIMIT \$3 FOR UPDATE SKIP LOCKED`
+	attemptQuery = `INSERT INTO task_attempts`
+	failQuery    = `UPDATE tasks SET status = \$1, attempts = \$2, last_error = \$3`
+)
+
+func setupTestReaper(t *testing.T, batchSize int) (*Reaper, sqlmock.Sqlmock, *redisMock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+
+	cache := &redisMock{}
+	config := &Config{
+		Interval:             time.Millisecond,
+		BatchSize:            batchSize,
+		Backoff:              store.BackoffPolicy{Base: time.Second, Max: time.Minute},
+		IdempotencyRetention: time.Hour,
+		WebhookTimeout:       time.Second,
+		WorkerTimeout:        30 * time.Second,
+		WorkerRetention:      time.Hour,
+	}
+
+	return NewReaper(db, cache, config), mock, cache
+}
+
+// expectRelease sets up the queries that record a lease expiry as a failed attempt
+func expectRelease(mock sqlmock.Sqlmock, id int64, attempts int, status string) {
+	expectFailure(mock, id, attempts, status, "lease expired")
+}
+
+// expectFailure sets up the queries that record a failed attempt with the
+// given error
+func expectFailure(mock sqlmock.Sqlmock, id int64, attempts int, status, lastError string) {
+	mock.ExpectExec(attemptQuery).
+		WithArgs(id, attempts, sqlmock.AnyArg(), lastError, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(failQuery).
+		WithArgs(status, attempts, lastError, sqlmock.AnyArg(), sqlmock.AnyArg(), id).
+		WillReturnRows(sqlmock.NewRows(taskRowColumns).
+			AddRow(id, "Task", "", status, "default", 0, nil, nil, nil, nil, attempts, 3, lastError, nil, nil, time.Now(), nil, nil, nil, nil, nil, time.Now(), time.Now()))
+}
+
+func TestNewConfig(t *testing.T) {
+	origInterval := os.Getenv("REAPER_INTERVAL_SECONDS")
+	origBatch := os.Getenv("REAPER_BATCH_SIZE")
+	origRetention := os.Getenv("IDEMPOTENCY_KEY_RETENTION_HOURS")
+	origWebhookTimeout := os.Getenv("BATCH_WEBHOOK_TIMEOUT_SECONDS")
+	origWorkerTimeout := os.Getenv("WORKER_TIMEOUT_SECONDS")
+	origWorkerRetention := os.Getenv("WORKER_RETENTION_HOURS")
+
+	defer func() {
+		os.Setenv("REAPER_INTERVAL_SECONDS", origInterval)
+		os.Setenv("REAPER_BATCH_SIZE", origBatch)
+		os.Setenv("IDEMPOTENCY_KEY_RETENTION_HOURS", origRetention)
+		os.Setenv("BATCH_WEBHOOK_TIMEOUT_SECONDS", origWebhookTimeout)
+		os.Setenv("WORKER_TIMEOUT_SECONDS", origWorkerTimeout)
+		os.Setenv("WORKER_RETENTION_HOURS", origWorkerRetention)
+	}()
+
+	os.Setenv("REAPER_INTERVAL_SECONDS", "")
+	os.Setenv("REAPER_BATCH_SIZE", "")
+	os.Setenv("IDEMPOTENCY_KEY_RETENTION_HOURS", "")
+	os.Setenv("BATCH_WEBHOOK_TIMEOUT_SECONDS", "")
+	os.Setenv("WORKER_TIMEOUT_SECONDS", "")
+	os.Setenv("WORKER_RETENTION_HOURS", "")
+	config := NewConfig()
+	assert.Equal(t, 10*time.Second, config.Interval)
+	assert.Equal(t, 100, config.BatchSize)
+	assert.Equal(t, 24*time.Hour, config.IdempotencyRetention)
+	assert.Equal(t, 10*time.Second, config.WebhookTimeout)
+	assert.Equal(t, 30*time.Second, config.WorkerTimeout)
+	assert.Equal(t, 24*time.Hour, config.WorkerRetention)
+
+	os.Setenv("REAPER_INTERVAL_SECONDS", "5")
+	os.Setenv("REAPER_BATCH_SIZE", "50")
+	os.Setenv("IDEMPOTENCY_KEY_RETENTION_HOURS", "48")
+	os.Setenv("BATCH_WEBHOOK_TIMEOUT_SECONDS", "3")
+	os.Setenv("WORKER_TIMEOUT_SECONDS", "60")
+	os.Setenv("WORKER_RETENTION_HOURS", "72")
+	config = NewConfig()
+	assert.Equal(t, 5*time.Second, config.Interval)
+	assert.Equal(t, 50, config.BatchSize)
+	assert.Equal(t, 48*time.Hour, config.IdempotencyRetention)
+	assert.Equal(t, 3*time.Second, config.WebhookTimeout)
+	assert.Equal(t, 60*time.Second, config.WorkerTimeout)
+	assert.Equal(t, 72*time.Hour, config.WorkerRetention)
+}
+
+func TestReaper_ReapExpired(t *testing.T) {
+	t.Run("Releases expired tasks in batches", func(t *testing.T) {
+		reaper, mock, cache := setupTestReaper(t, 2)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(expiredQuery).
+			WithArgs("in_progress", sqlmock.AnyArg(), 2).
+			WillReturnRows(addExpiredTaskRow(addExpiredTaskRow(sqlmock.NewRows(taskRowColumns), 1, 0), 2, 2))
+		expectRelease(mock, 1, 1, "pending")
+		expectRelease(mock, 2, 3, "dead")
+		mock.ExpectQuery(`SELECT id FROM tasks WHERE status = \$1 AND id IN \(SELECT task_id FROM task_dependencies WHERE depends_on_id = \$2\)`).
+			WithArgs("blocked", 2).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+		mock.ExpectQuery(`WITH RECURSIVE downstream AS (.+) UPDATE tasks SET status = \$2`).
+			WithArgs(pq.Array([]int64{7}), "cancelled", sqlmock.AnyArg(), "blocked").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+		mock.ExpectCommit()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(expiredQuery).
+			WithArgs("in_progress", sqlmock.AnyArg(), 2).
+			WillReturnRows(addExpiredTaskRow(sqlmock.NewRows(taskRowColumns), 3, 0))
+		expectRelease(mock, 3, 1, "pending")
+		mock.ExpectCommit()
+
+		reaped, err := reaper.ReapExpired(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 3, reaped)
+		assert.Equal(t, []string{"task:1", "task:2", "task:7", "task:3"}, cache.deleted)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Fails tasks past their timeout", func(t *testing.T) {
+		reaper, mock, cache := setupTestReaper(t, 2)
+
+		// The lease is still being renewed, but the deadline has passed
+		mock.ExpectBegin()
+		mock.ExpectQuery(expiredQuery).
+			WithArgs("in_progress", sqlmock.AnyArg(), 2).
+			WillReturnRows(sqlmock.NewRows(taskRowColumns).
+				AddRow(4, "Task", "", "in_progress", "default", 0, nil, nil, "worker-1", time.Now().Add(time.Minute), 0, 3, nil, nil, nil, time.Now(), nil, nil, nil, 30, time.Now().Add(-time.Second), time.Now(), time.Now()))
+		expectFailure(mock, 4, 1, "pending", "timed out after 30 seconds")
+		mock.ExpectCommit()
+
+		reaped, err := reaper.ReapExpired(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, reaped)
+		assert.Equal(t, []string{"task:4"}, cache.deleted)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Nothing to reap", func(t *testing.T) {
+		reaper, mock, cache := setupTestReaper(t, 2)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(expiredQuery).
+			WillReturnRows(sqlmock.NewRows(taskRowColumns))
+		mock.ExpectCommit()
+
+		reaped, err := reaper.ReapExpired(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, reaped)
+		assert.Empty(t, cache.deleted)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Database error", func(t *testing.T) {
+		reaper, mock, _ := setupTestReaper(t, 2)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(expiredQuery).
+			WillReturnError(errors.New("connection reset"))
+		mock.ExpectRollback()
+
+		_, err := reaper.ReapExpired(context.Background())
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestReaper_PurgeIdempotencyKeys(t *testing.T) {
+	purgeQuery := `DELETE FROM idempotency_keys WHERE key IN \( SELECT key FROM idempotency_keys WHERE created_at < \$1 LIMIT \$2 \)`
+
+	t.Run("Purges expired keys in batches", func(t *testing.T) {
+		reaper, mock, _ := setupTestReaper(t, 2)
+
+		mock.ExpectExec(purgeQuery).
+			WithArgs(sqlmock.AnyArg(), 2).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(purgeQuery).
+			WithArgs(sqlmock.AnyArg(), 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		purged, err := reaper.PurgeIdempotencyKeys(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), purged)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Database error", func(t *testing.T) {
+		reaper, mock, _ := setupTestReaper(t, 2)
+
+		mock.ExpectExec(purgeQuery).
+			WithArgs(sqlmock.AnyArg(), 2).
+			WillReturnError(errors.New("database error"))
+
+		_, err := reaper.PurgeIdempotencyKeys(context.Background())
+
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestReaper_Run(t *testing.T) {
+	reaper, _, _ := setupTestReaper(t, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		reaper.Run(ctx)
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Reaper did not stop after context cancellation")
+	}
+}
@@ -0,0 +1,122 @@
+package reaper
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+)
+
+// ReapDeadWorkers marks registered workers dead once they have gone
+// WorkerTimeout without a heartbeat, then releases the tasks leased to dead
+// workers without waiting for their leases to expire. Each release is
+// recorded as a failed attempt, as for an expired lease. It returns the
+// number of workers marked dead and of tasks released.
+func (r *Reaper) ReapDeadWorkers(ctx context.Context) (int, int, error) {
+	dead, err := r.markDeadWorkers(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	total := 0
+	for {
+		ids, cancelled, err := r.releaseDeadWorkerTasks(ctx)
+		if err != nil {
+			return dead, total, err
+		}
+		total += len(ids)
+		r.uncache(ctx, append(ids, cancelled...))
+
+		if len(ids) < r.config.BatchSize {
+			return dead, total, nil
+		}
+	}
+}
+
+// markDeadWorkers marks live workers not seen for WorkerTimeout as dead and
+// returns how many there were
+func (r *Reaper) markDeadWorkers(ctx context.Context) (int, error) {
+	query := `
+		UPDATE workers
+		SET status = $1,
+			updated_at = $2
+		WHERE status = $3 AND last_seen_at < $4`
+
+	now := time.Now()
+	result, err := r.db.ExecContext(ctx, query, models.WorkerStatusDead, now, models.WorkerStatusAlive, now.Add(-r.config.WorkerTimeout))
+	if err != nil {
+		return 0, fmt.Errorf("error marking dead workers: %v", err)
+	}
+
+	dead, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting dead workers: %v", err)
+	}
+	return int(dead), nil
+}
+
+// releaseDeadWorkerTasks releases up to BatchSize tasks leased to dead
+// workers and returns their IDs, along with the IDs of dependents cancelled
+// because a task died. Tasks are released whenever their worker was marked
+// dead, so a task claimed by a worker that is no longer heartbeating is
+// released on the next run.
+func (r *Reaper) releaseDeadWorkerTasks(ctx context.Context) ([]int64, []int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT ` + store.TaskColumns + `
+		FROM tasks
+		WHERE status = $1 AND lease_owner IN (
+			SELECT id FROM workers WHERE status = $2
+		)
+		ORDER BY id
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query, models.TaskStatusInProgress, models.WorkerStatusDead, r.config.BatchSize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error selecting tasks of dead workers: %v", err)
+	}
+	tasks, err := store.ScanTasks(rows)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error scanning tasks of dead workers: %v", err)
+	}
+
+	ids, cancelled, err := failTasks(ctx, tx, tasks, r.config.Backoff, time.Now(), func(task models.Task) string {
+		return "worker stopped heartbeating"
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("error committing released tasks: %v", err)
+	}
+
+	return ids, cancelled, nil
+}
+
+// PurgeDeadWorkers deletes workers that have been dead for longer than
+// WorkerRetention, and returns how many were deleted
+func (r *Reaper) PurgeDeadWorkers(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM workers
+		WHERE status = $1 AND updated_at < $2`
+
+	result, err := r.db.ExecContext(ctx, query, models.WorkerStatusDead, time.Now().Add(-r.config.WorkerRetention))
+	if err != nil {
+		return 0, fmt.Errorf("error purging dead workers: %v", err)
+	}
+
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting purged workers: %v", err)
+	}
+	return purged, nil
+}
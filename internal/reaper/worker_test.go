@@ -0,0 +1,96 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/queuet/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	markDeadQuery       = `UPDATE workers SET status = \$1, updated_at = \$2 WHERE status = \$3 AND last_seen_at < \$4`
+	deadWorkerTaskQuery = `SELECT ` + regexp.QuoteMeta(store.TaskColumns) + ` FROM tasks WHERE status = \$1 AND lease_owner IN \( SELECT id FROM workers WHERE status = \$2 \) ORDER BY id LIMIT \$3 FOR UPDATE SKIP LOCKED`
+)
+
+func TestReaper_ReapDeadWorkers(t *testing.T) {
+	t.Run("Releases the tasks of dead workers in batches", func(t *testing.T) {
+		reaper, mock, cache := setupTestReaper(t, 2)
+
+		mock.ExpectExec(markDeadQuery).
+			WithArgs("dead", sqlmock.AnyArg(), "alive", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(deadWorkerTaskQuery).
+			WithArgs("in_progress", "dead", 2).
+			WillReturnRows(addExpiredTaskRow(addExpiredTaskRow(sqlmock.NewRows(taskRowColumns), 1, 0), 2, 2))
+		expectFailure(mock, 1, 1, "pending", "worker stopped heartbeating")
+		expectFailure(mock, 2, 3, "dead", "worker stopped heartbeating")
+		mock.ExpectQuery(`SELECT id FROM tasks WHERE status = \$1 AND id IN \(SELECT task_id FROM task_dependencies WHERE depends_on_id = \$2\)`).
+			WithArgs("blocked", 2).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectCommit()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(deadWorkerTaskQuery).
+			WithArgs("in_progress", "dead", 2).
+			WillReturnRows(sqlmock.NewRows(taskRowColumns))
+		mock.ExpectCommit()
+
+		dead, released, err := reaper.ReapDeadWorkers(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, dead)
+		assert.Equal(t, 2, released)
+		assert.Equal(t, []string{"task:1", "task:2"}, cache.deleted)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("No dead workers", func(t *testing.T) {
+		reaper, mock, cache := setupTestReaper(t, 2)
+
+		mock.ExpectExec(markDeadQuery).
+			WithArgs("dead", sqlmock.AnyArg(), "alive", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectQuery(deadWorkerTaskQuery).
+			WithArgs("in_progress", "dead", 2).
+			WillReturnRows(sqlmock.NewRows(taskRowColumns))
+		mock.ExpectCommit()
+
+		dead, released, err := reaper.ReapDeadWorkers(context.Background())
+		assert.NoError(t, err)
+		assert.Zero(t, dead)
+		assert.Zero(t, released)
+		assert.Empty(t, cache.deleted)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Database error", func(t *testing.T) {
+		reaper, mock, _ := setupTestReaper(t, 2)
+
+		mock.ExpectExec(markDeadQuery).
+			WillReturnError(errors.New("database error"))
+
+		_, _, err := reaper.ReapDeadWorkers(context.Background())
+		assert.Error(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestReaper_PurgeDeadWorkers(t *testing.T) {
+	reaper, mock, _ := setupTestReaper(t, 2)
+
+	mock.ExpectExec(`DELETE FROM workers WHERE status = \$1 AND updated_at < \$2`).
+		WithArgs("dead", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	purged, err := reaper.PurgeDeadWorkers(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), purged)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -5,15 +5,63 @@ import (
 	"github.com/queuet/internal/handlers"
 )
 
-func SetupRoutes(r chi.Router, taskHandler *handlers.TaskHandler) {
+func SetupRoutes(r chi.Router, taskHandler *handlers.TaskHandler, scheduleHandler *handlers.ScheduleHandler, queueHandler *handlers.QueueHandler, workerHandler *handlers.WorkerHandler) {
 	r.Route("/api/v1", func(r chi.Router) {
 		// Tasks endpoints
 		r.Route("/tasks", func(r chi.Router) {
 			r.Get("/", taskHandler.ListTasks)
 			r.Post("/", taskHandler.CreateTask)
+			r.Post("/batch", taskHandler.CreateTaskBatch)
+			r.Post("/claim", taskHandler.ClaimTask)
+			r.Post("/complete", taskHandler.CompleteTaskBatch)
 			r.Get("/{id}", taskHandler.GetTask)
 			r.Put("/{id}", taskHandler.UpdateTask)
 			r.Delete("/{id}", taskHandler.DeleteTask)
+			r.Post("/{id}/heartbeat", taskHandler.Heartbeat)
+			r.Post("/{id}/complete", taskHandler.CompleteTask)
+			r.Post("/{id}/fail", taskHandler.FailTask)
+			r.Post("/{id}/cancel", taskHandler.CancelTask)
+			r.Get("/{id}/attempts", taskHandler.ListAttempts)
+			r.Get("/{id}/graph", taskHandler.GetTaskGraph)
+		})
+
+		// Dead letter endpoints
+		r.Route("/dead-letter", func(r chi.Router) {
+			r.Get("/", taskHandler.ListDeadLetter)
+			r.Post("/replay", taskHandler.ReplayDeadLetter)
+		})
+
+		// Batches endpoints
+		r.Route("/batches", func(r chi.Router) {
+			r.Post("/", taskHandler.CreateBatch)
+			r.Get("/{id}", taskHandler.GetBatch)
+		})
+
+		// Queues endpoints
+		r.Route("/queues", func(r chi.Router) {
+			r.Get("/", queueHandler.ListQueues)
+			r.Post("/", queueHandler.CreateQueue)
+			r.Get("/{name}", queueHandler.GetQueue)
+			r.Put("/{name}", queueHandler.UpdateQueue)
+			r.Post("/{name}/pause", queueHandler.PauseQueue)
+			r.Post("/{name}/resume", queueHandler.ResumeQueue)
+		})
+
+		// Workers endpoints
+		r.Route("/workers", func(r chi.Router) {
+			r.Get("/", workerHandler.ListWorkers)
+			r.Post("/", workerHandler.RegisterWorker)
+			r.Get("/{id}", workerHandler.GetWorker)
+			r.Post("/{id}/heartbeat", workerHandler.WorkerHeartbeat)
+		})
+
+		// Schedules endpoints
+		r.Route("/schedules", func(r chi.Router) {
+			r.Get("/", scheduleHandler.ListSchedules)
+			r.Post("/", scheduleHandler.CreateSchedule)
+			r.Get("/{id}", scheduleHandler.GetSchedule)
+			r.Put("/{id}", scheduleHandler.UpdateSchedule)
+			r.Delete("/{id}", scheduleHandler.DeleteSchedule)
 		})
 	})
 }
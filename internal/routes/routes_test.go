@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,6 +16,9 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// taskRowColumns are the column names returned by task queries
+var taskRowColumns = []string{"id", "title", "description", "status", "queue", "priority", "unique_key", "batch_id", "lease_owner", "lease_expires_at", "attempts", "max_attempts", "last_error", "payload", "result", "run_at", "progress", "progress_message", "cancel_requested_at", "timeout_seconds", "deadline_at", "created_at", "updated_at"}
+
 // Mock Redis client
 type redisMock struct {
 	getFunc func(ctx context.Context, key string) *redis.StringCmd
@@ -61,17 +65,18 @@ func TestSetupRoutes(t *testing.T) {
 	}
 
 	// Create task handler with mocks
-	taskHandler := handlers.NewTaskHandler(db, redisClient)
+	taskHandler := handlers.NewTaskHandler(db, redisClient, nil, nil, handlers.NewConfig())
 
 	// Create router and register routes
 	r := chi.NewRouter()
-	SetupRoutes(r, taskHandler)
+	SetupRoutes(r, taskHandler, handlers.NewScheduleHandler(db, handlers.NewConfig()), handlers.NewQueueHandler(db), handlers.NewWorkerHandler(db))
 
 	// Test cases for different routes
 	tests := []struct {
 		name           string
 		method         string
 		path           string
+		body           string
 		expectedStatus int
 		mockDB         func()
 	}{
@@ -81,10 +86,10 @@ func TestSetupRoutes(t *testing.T) {
 			path:           "/api/v1/tasks",
 			expectedStatus: http.StatusOK,
 			mockDB: func() {
-				mock.ExpectQuery(`SELECT id, title, description, status, created_at, updated_at FROM tasks ORDER BY created_at DESC LIMIT \$1 OFFSET \$2`).
+				mock.ExpectQuery(`SELECT (.+) FROM tasks ORDER BY created_at DESC LIMIT \$1 OFFSET \$2`).
 					WithArgs(10, 0).
-					WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "status", "created_at", "updated_at"}).
-						AddRow(1, "Task 1", "Description 1", "pending", time.Now(), time.Now()))
+					WillReturnRows(sqlmock.NewRows(taskRowColumns).
+						AddRow(1, "Task 1", "Description 1", "pending", "default", 0, nil, nil, nil, nil, 0, 3, nil, nil, nil, time.Now(), nil, nil, nil, nil, nil, time.Now(), time.Now()))
 			},
 		},
 		{
@@ -93,20 +98,130 @@ func TestSetupRoutes(t *testing.T) {
 			path:           "/api/v1/tasks/1",
 			expectedStatus: http.StatusNotFound,
 			mockDB: func() {
-				mock.ExpectQuery(`SELECT id, title, description, status, created_at, updated_at FROM tasks WHERE id = \$1`).
+				mock.ExpectQuery(`SELECT (.+) FROM tasks WHERE id = \$1`).
 					WithArgs(1).
 					WillReturnError(sql.ErrNoRows)
 			},
 		},
+		{
+			name:           "POST /tasks/claim",
+			method:         "POST",
+			path:           "/api/v1/tasks/claim",
+			body:           `{"worker_id": "worker-1"}`,
+			expectedStatus: http.StatusNoContent,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT name FROM queues WHERE max_in_flight IS NOT NULL (.+) FOR UPDATE`).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}))
+				mock.ExpectQuery(`WITH candidates AS \( SELECT id, queue, priority, run_at FROM tasks (.+) FOR UPDATE SKIP LOCKED \), (.+) UPDATE tasks SET`).
+					WillReturnRows(sqlmock.NewRows(taskRowColumns))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:           "POST /tasks/complete",
+			method:         "POST",
+			path:           "/api/v1/tasks/complete",
+			body:           `{"worker_id": "worker-1", "tasks": [{"id": 1}]}`,
+			expectedStatus: http.StatusMultiStatus,
+			mockDB: func() {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`UPDATE tasks SET (.+) FROM unnest`).
+					WillReturnRows(sqlmock.NewRows(taskRowColumns))
+				mock.ExpectQuery(`SELECT id FROM tasks WHERE id = ANY\(\$1\)`).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:           "GET /dead-letter",
+			method:         "GET",
+			path:           "/api/v1/dead-letter",
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectQuery(`SELECT (.+) FROM tasks WHERE status = \$1 ORDER BY updated_at DESC LIMIT \$2 OFFSET \$3`).
+					WithArgs("dead", 10, 0).
+					WillReturnRows(sqlmock.NewRows(taskRowColumns))
+			},
+		},
+		{
+			name:           "GET /queues/{name}",
+			method:         "GET",
+			path:           "/api/v1/queues/emails",
+			expectedStatus: http.StatusNotFound,
+			mockDB: func() {
+				mock.ExpectQuery(`SELECT (.+) FROM queues WHERE name = \$1`).
+					WithArgs("emails").
+					WillReturnError(sql.ErrNoRows)
+			},
+		},
+		{
+			name:           "POST /queues/{name}/pause",
+			method:         "POST",
+			path:           "/api/v1/queues/emails/pause",
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectQuery(`INSERT INTO queues (.+) ON CONFLICT \(name\) DO UPDATE`).
+					WithArgs("emails", true, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"name", "default_max_attempts", "lease_seconds", "max_in_flight", "rate_limit", "rate_period_seconds", "paused", "created_at", "updated_at"}).
+						AddRow("emails", nil, nil, nil, nil, nil, true, time.Now(), time.Now()))
+			},
+		},
+		{
+			name:           "GET /workers",
+			method:         "GET",
+			path:           "/api/v1/workers",
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectQuery(`SELECT (.+) FROM workers WHERE \(\$1 = '' OR status = \$1\) ORDER BY id`).
+					WithArgs("").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			},
+		},
+		{
+			name:           "POST /workers/{id}/heartbeat",
+			method:         "POST",
+			path:           "/api/v1/workers/worker-1/heartbeat",
+			expectedStatus: http.StatusNotFound,
+			mockDB: func() {
+				mock.ExpectQuery(`UPDATE workers SET status = \$1, last_seen_at = \$2`).
+					WithArgs("alive", sqlmock.AnyArg(), "worker-1").
+					WillReturnError(sql.ErrNoRows)
+			},
+		},
+		{
+			name:           "GET /schedules",
+			method:         "GET",
+			path:           "/api/v1/schedules",
+			expectedStatus: http.StatusOK,
+			mockDB: func() {
+				mock.ExpectQuery(`SELECT (.+) FROM schedules ORDER BY id LIMIT \$1 OFFSET \$2`).
+					WithArgs(10, 0).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			},
+		},
+		{
+			name:           "DELETE /schedules/{id}",
+			method:         "DELETE",
+			path:           "/api/v1/schedules/1",
+			expectedStatus: http.StatusNotFound,
+			mockDB: func() {
+				mock.ExpectExec(`DELETE FROM schedules WHERE id = \$1`).
+					WithArgs(1).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+		},
 		{
 			name:           "DELETE /tasks/{id}",
 			method:         "DELETE",
 			path:           "/api/v1/tasks/1",
 			expectedStatus: http.StatusNotFound,
 			mockDB: func() {
-				mock.ExpectExec(`DELETE FROM tasks WHERE id = \$1`).
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT (.+) FROM tasks WHERE id = \$1 FOR UPDATE`).
 					WithArgs(1).
-					WillReturnResult(sqlmock.NewResult(0, 0))
+					WillReturnRows(sqlmock.NewRows(taskRowColumns))
+				mock.ExpectRollback()
 			},
 		},
 	}
@@ -115,7 +230,7 @@ func TestSetupRoutes(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.mockDB()
 
-			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
 			w := httptest.NewRecorder()
 
 			r.ServeHTTP(w, req)
@@ -0,0 +1,316 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/queuet/internal/cron"
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+)
+
+// lockKey identifies the Postgres advisory lock held by the leading
+// scheduler
+const lockKey = 0x71756575 // "queu"
+
+// maxCatchUp bounds the ticks enqueued for a single schedule per pass with
+// the all catch-up policy. Remaining ticks are picked up on the next pass.
+const maxCatchUp = 1000
+
+type Config struct {
+	Interval  time.Duration
+	BatchSize int
+	// MisfireGrace is how late a tick may be enqueued before the skip
+	// catch-up policy considers it missed
+	MisfireGrace time.Duration
+}
+
+// NewConfig creates a new scheduler configuration from environment variables
+func NewConfig() *Config {
+	return &Config{
+		Interval:     time.Duration(getEnvInt("SCHEDULER_INTERVAL_SECONDS", 5)) * time.Second,
+		BatchSize:    getEnvInt("SCHEDULER_BATCH_SIZE", 100),
+		MisfireGrace: time.Duration(getEnvInt("SCHEDULER_MISFIRE_GRACE_SECONDS", 60)) * time.Second,
+	}
+}
+
+// Scheduler enqueues tasks for due cron schedules. Every replica runs one,
+// but only the replica holding a Postgres advisory lock enqueues. Schedules
+// are also locked and advanced in the same transaction as their tasks are
+// inserted, so a tick is never enqueued twice even during a leader handover.
+type Scheduler struct {
+	db     *sql.DB
+	config *Config
+}
+
+func NewScheduler(db *sql.DB, config *Config) *Scheduler {
+	return &Scheduler{
+		db:     db,
+		config: config,
+	}
+}
+
+// Run campaigns for leadership and, while leading, enqueues due schedules
+// every Interval until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	// The advisory lock belongs to a single connection, held for as long as
+	// this replica leads
+	var leader *sql.Conn
+	defer func() {
+		if leader != nil {
+			resign(leader)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if leader == nil {
+			conn, err := s.campaign(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Error acquiring scheduler lock: %v", err)
+				}
+				continue
+			}
+			if conn == nil {
+				// Another replica is leading
+				continue
+			}
+			log.Printf("Scheduler acquired leadership")
+			leader = conn
+		}
+
+		// Losing the connection releases the lock, so step down and campaign
+		// again on the next tick
+		if err := leader.PingContext(ctx); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Scheduler lost leadership: %v", err)
+			}
+			leader.Close()
+			leader = nil
+			continue
+		}
+
+		enqueued, err := s.EnqueueDue(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error enqueuing scheduled tasks: %v", err)
+		}
+		if enqueued > 0 {
+			log.Printf("Enqueued %d scheduled tasks", enqueued)
+		}
+	}
+}
+
+// campaign tries to take the scheduler lock. It returns the connection
+// holding the lock, or nil if another replica holds it.
+func (s *Scheduler) campaign(ctx context.Context) (*sql.Conn, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, lockKey).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !acquired {
+		conn.Close()
+		return nil, nil
+	}
+
+	return conn, nil
+}
+
+// resign releases the scheduler lock and returns the connection to the pool
+func resign(conn *sql.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, lockKey); err != nil {
+		// Never hand a connection that may still hold the lock back to the
+		// pool; discarding it ends the session and releases the lock
+		log.Printf("Error releasing scheduler lock: %v", err)
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+	conn.Close()
+}
+
+// EnqueueDue enqueues tasks for every enabled schedule whose next run is due
+// and advances the schedules. It returns the number of tasks enqueued.
+func (s *Scheduler) EnqueueDue(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		processed, enqueued, err := s.enqueueBatch(ctx)
+		total += enqueued
+		if err != nil {
+			return total, err
+		}
+		if processed < s.config.BatchSize {
+			return total, nil
+		}
+	}
+}
+
+// enqueueBatch handles up to BatchSize due schedules. It returns the number
+// of schedules processed and tasks enqueued.
+func (s *Scheduler) enqueueBatch(ctx context.Context) (int, int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT ` + store.ScheduleColumns + `
+		FROM schedules
+		WHERE enabled AND next_run_at <= $1
+		ORDER BY next_run_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED`
+
+	now := time.Now()
+	rows, err := tx.QueryContext(ctx, query, now, s.config.BatchSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error selecting due schedules: %v", err)
+	}
+
+	var due []models.Schedule
+	for rows.Next() {
+		schedule, err := store.ScanSchedule(rows)
+		if err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("error scanning schedule: %v", err)
+		}
+		due = append(due, schedule)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("error iterating schedules: %v", err)
+	}
+
+	enqueued := 0
+	for _, schedule := range due {
+		n, err := s.enqueueSchedule(ctx, tx, schedule, now)
+		if err != nil {
+			return 0, 0, err
+		}
+		enqueued += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("error committing scheduled tasks: %v", err)
+	}
+
+	return len(due), enqueued, nil
+}
+
+// enqueueSchedule inserts the tasks for a due schedule locked by tx and
+// advances its next run
+func (s *Scheduler) enqueueSchedule(ctx context.Context, tx *sql.Tx, schedule models.Schedule, now time.Time) (int, error) {
+	var ticks []time.Time
+	var next time.Time
+
+	cronSchedule, loc, err := store.ParseSchedule(schedule.CronExpression, schedule.Timezone)
+	if err != nil {
+		// Schedules are validated when saved, so this only happens if the
+		// row was edited by hand. Leave next_run_at empty to stop it firing.
+		log.Printf("Disabling schedule %d: %v", schedule.ID, err)
+	} else {
+		ticks, next = dueTicks(cronSchedule, schedule.NextRunAt.In(loc), schedule.CatchUp, s.config.MisfireGrace, now.In(loc))
+	}
+
+	enqueued := 0
+	var lastRun sql.NullTime
+	for _, tick := range ticks {
+		task, err := store.NewTaskFromRequest(schedule.TaskTemplate, tick)
+		if err != nil {
+			log.Printf("Skipping tick of schedule %d: %v", schedule.ID, err)
+			continue
+		}
+		if _, err := store.InsertTask(ctx, tx, task, now); err == store.ErrUniqueKeyTaken {
+			// The task from an earlier tick is still pending, blocked or running
+			log.Printf("Skipping tick of schedule %d: unique key %q is taken", schedule.ID, task.UniqueKey)
+			continue
+		} else if _, ok := err.(store.ValidationError); ok {
+			// e.g. a dependency of the template was deleted since it was saved
+			log.Printf("Skipping tick of schedule %d: %v", schedule.ID, err)
+			continue
+		} else if err != nil {
+			return 0, fmt.Errorf("error enqueuing task for schedule %d: %v", schedule.ID, err)
+		}
+		lastRun = sql.NullTime{Time: tick, Valid: true}
+		enqueued++
+	}
+
+	query := `
+		UPDATE schedules
+		SET next_run_at = $1,
+			last_run_at = COALESCE($2, last_run_at),
+			updated_at = $3
+		WHERE id = $4`
+
+	_, err = tx.ExecContext(ctx, query, sql.NullTime{Time: next, Valid: !next.IsZero()}, lastRun, now, schedule.ID)
+	if err != nil {
+		return 0, fmt.Errorf("error advancing schedule %d: %v", schedule.ID, err)
+	}
+
+	return enqueued, nil
+}
+
+// dueTicks applies a catch-up policy to a schedule whose first pending tick
+// is first. It returns the ticks to enqueue now and the next tick still to
+// come, which is zero if the schedule never fires again.
+func dueTicks(s *cron.Schedule, first time.Time, policy string, grace time.Duration, now time.Time) ([]time.Time, time.Time) {
+	var ticks []time.Time
+
+	switch policy {
+	case models.CatchUpOnce:
+		// However many ticks were missed, run once now
+		return []time.Time{now}, s.Next(now)
+
+	case models.CatchUpAll:
+		t := first
+		for !t.IsZero() && !t.After(now) && len(ticks) < maxCatchUp {
+			ticks = append(ticks, t)
+			t = s.Next(t)
+		}
+		return ticks, t
+
+	default:
+		// Only ticks within the grace period are on time; older ones are
+		// dropped
+		t := first
+		if earliest := now.Add(-grace); t.Before(earliest) {
+			t = s.Next(earliest.Add(-time.Nanosecond))
+		}
+		for !t.IsZero() && !t.After(now) {
+			ticks = append(ticks, t)
+			t = s.Next(t)
+		}
+		return ticks, t
+	}
+}
+
+// getEnvInt retrieves a positive integer environment variable with a fallback value
+func getEnvInt(key string, fallback int) int {
+	val, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || val <= 0 {
+		return fallback
+	}
+	return val
+}
@@ -0,0 +1,259 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/queuet/internal/cron"
+	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// scheduleRowColumns are the column names produced by a store.ScheduleColumns select
+var scheduleRowColumns = []string{"id", "name", "cron_expression", "timezone", "task_template", "catch_up", "enabled", "next_run_at", "last_run_at", "created_at", "updated_at"}
+
+var (
+	dueQuery     = `SELECT ` + regexp.QuoteMeta(store.ScheduleColumns) + ` FROM schedules WHERE enabled AND next_run_at <= \$1 ORDER BY next_run_at LIMIT \$2 FOR UPDATE SKIP LOCKED`
+	insertQuery  = `INSERT INTO tasks \(title, description, payload, status, queue, priority, max_attempts, run_at, created_at, updated_at, unique_key, batch_id, timeout_seconds\)`
+	advanceQuery = `UPDATE schedules SET next_run_at = \$1, last_run_at = COALESCE\(\$2, last_run_at\), updated_at = \$3 WHERE id = \$4`
+)
+
+func setupTestScheduler(t *testing.T, batchSize int) (*Scheduler, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+
+	config := &Config{
+		Interval:     time.Millisecond,
+		BatchSize:    batchSize,
+		MisfireGrace: time.Minute,
+	}
+
+	return NewScheduler(db, config), mock
+}
+
+func mustParse(t *testing.T, expr string) *cron.Schedule {
+	s, err := cron.Parse(expr)
+	if err != nil {
+		t.Fatalf("Failed to parse %q: %v", expr, err)
+	}
+	return s
+}
+
+func TestNewConfig(t *testing.T) {
+	origInterval := os.Getenv("SCHEDULER_INTERVAL_SECONDS")
+	origBatch := os.Getenv("SCHEDULER_BATCH_SIZE")
+	origGrace := os.Getenv("SCHEDULER_MISFIRE_GRACE_SECONDS")
+
+	defer func() {
+		os.Setenv("SCHEDULER_INTERVAL_SECONDS", origInterval)
+		os.Setenv("SCHEDULER_BATCH_SIZE", origBatch)
+		os.Setenv("SCHEDULER_MISFIRE_GRACE_SECONDS", origGrace)
+	}()
+
+	os.Setenv("SCHEDULER_INTERVAL_SECONDS", "")
+	os.Setenv("SCHEDULER_BATCH_SIZE", "")
+	os.Setenv("SCHEDULER_MISFIRE_GRACE_SECONDS", "")
+	config := NewConfig()
+	assert.Equal(t, 5*time.Second, config.Interval)
+	assert.Equal(t, 100, config.BatchSize)
+	assert.Equal(t, time.Minute, config.MisfireGrace)
+
+	os.Setenv("SCHEDULER_INTERVAL_SECONDS", "1")
+	os.Setenv("SCHEDULER_BATCH_SIZE", "10")
+	os.Setenv("SCHEDULER_MISFIRE_GRACE_SECONDS", "300")
+	config = NewConfig()
+	assert.Equal(t, time.Second, config.Interval)
+	assert.Equal(t, 10, config.BatchSize)
+	assert.Equal(t, 5*time.Minute, config.MisfireGrace)
+}
+
+func TestDueTicks(t *testing.T) {
+	hourly := mustParse(t, "0 * * * *")
+	now := time.Date(2024, time.January, 15, 10, 0, 20, 0, time.UTC)
+	hour := func(h int) time.Time {
+		return time.Date(2024, time.January, 15, h, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name          string
+		first         time.Time
+		policy        string
+		expectedTicks []time.Time
+		expectedNext  time.Time
+	}{
+		{
+			name:          "On time",
+			first:         hour(10),
+			policy:        models.CatchUpSkip,
+			expectedTicks: []time.Time{hour(10)},
+			expectedNext:  hour(11),
+		},
+		{
+			name:          "Skip drops missed ticks",
+			first:         hour(7),
+			policy:        models.CatchUpSkip,
+			expectedTicks: []time.Time{hour(10)},
+			expectedNext:  hour(11),
+		},
+		{
+			name:          "Once collapses missed ticks",
+			first:         hour(7),
+			policy:        models.CatchUpOnce,
+			expectedTicks: []time.Time{now},
+			expectedNext:  hour(11),
+		},
+		{
+			name:          "All enqueues every missed tick",
+			first:         hour(7),
+			policy:        models.CatchUpAll,
+			expectedTicks: []time.Time{hour(7), hour(8), hour(9), hour(10)},
+			expectedNext:  hour(11),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ticks, next := dueTicks(hourly, tt.first, tt.policy, time.Minute, now)
+			assert.Equal(t, tt.expectedTicks, ticks)
+			assert.Equal(t, tt.expectedNext, next)
+		})
+	}
+
+	t.Run("Skip drops a tick outside the grace period", func(t *testing.T) {
+		late := now.Add(2 * time.Minute)
+		ticks, next := dueTicks(hourly, hour(10), models.CatchUpSkip, time.Minute, late)
+		assert.Empty(t, ticks)
+		assert.Equal(t, hour(11), next)
+	})
+
+	t.Run("All is bounded per pass", func(t *testing.T) {
+		minutely := mustParse(t, "* * * * *")
+		first := now.Add(-48 * time.Hour).Truncate(time.Minute)
+		ticks, next := dueTicks(minutely, first, models.CatchUpAll, time.Minute, now)
+		assert.Len(t, ticks, maxCatchUp)
+		assert.Equal(t, first.Add(maxCatchUp*time.Minute), next)
+	})
+}
+
+func TestScheduler_EnqueueDue(t *testing.T) {
+	t.Run("Enqueues due schedules and advances them", func(t *testing.T) {
+		scheduler, mock := setupTestScheduler(t, 10)
+
+		due := time.Now().UTC().Add(-time.Second).Truncate(time.Second)
+		mock.ExpectBegin()
+		mock.ExpectQuery(dueQuery).
+			WithArgs(sqlmock.AnyArg(), 10).
+			WillReturnRows(sqlmock.NewRows(scheduleRowColumns).
+				AddRow(1, "nightly", "0 3 * * *", "UTC", []byte(`{"title":"Nightly report","payload":{"format":"pdf"},"max_attempts":5}`), "skip", true, due, nil, time.Now(), time.Now()))
+		mock.ExpectQuery(insertQuery).
+			WithArgs("Nightly report", "", sql.NullString{String: `{"format":"pdf"}`, Valid: true}, "pending", "default", 0, sql.NullInt64{Int64: 5, Valid: true}, 3, due, sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{}, sql.NullInt64{}).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+		mock.ExpectExec(advanceQuery).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		enqueued, err := scheduler.EnqueueDue(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, enqueued)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid cron expression stops the schedule", func(t *testing.T) {
+		scheduler, mock := setupTestScheduler(t, 10)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(dueQuery).
+			WithArgs(sqlmock.AnyArg(), 10).
+			WillReturnRows(sqlmock.NewRows(scheduleRowColumns).
+				AddRow(2, "broken", "not a cron", "UTC", []byte(`{"title":"Task"}`), "skip", true, time.Now(), nil, time.Now(), time.Now()))
+		mock.ExpectExec(advanceQuery).
+			WithArgs(nil, nil, sqlmock.AnyArg(), 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		enqueued, err := scheduler.EnqueueDue(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, enqueued)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Tick with a missing dependency is skipped", func(t *testing.T) {
+		scheduler, mock := setupTestScheduler(t, 10)
+
+		due := time.Now().UTC().Add(-time.Second).Truncate(time.Second)
+		mock.ExpectBegin()
+		mock.ExpectQuery(dueQuery).
+			WithArgs(sqlmock.AnyArg(), 10).
+			WillReturnRows(sqlmock.NewRows(scheduleRowColumns).
+				AddRow(3, "follow-up", "0 3 * * *", "UTC", []byte(`{"title":"Follow up","depends_on":[7]}`), "skip", true, due, nil, time.Now(), time.Now()))
+		mock.ExpectQuery(`SELECT id, status FROM tasks WHERE id = ANY\(\$1\) ORDER BY id FOR SHARE`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "status"}))
+		// The schedule still advances, so it does not block the others
+		mock.ExpectExec(advanceQuery).
+			WithArgs(sqlmock.AnyArg(), nil, sqlmock.AnyArg(), 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		enqueued, err := scheduler.EnqueueDue(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, enqueued)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Nothing due", func(t *testing.T) {
+		scheduler, mock := setupTestScheduler(t, 10)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(dueQuery).
+			WithArgs(sqlmock.AnyArg(), 10).
+			WillReturnRows(sqlmock.NewRows(scheduleRowColumns))
+		mock.ExpectCommit()
+
+		enqueued, err := scheduler.EnqueueDue(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, enqueued)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestScheduler_Campaign(t *testing.T) {
+	t.Run("Acquires the lock", func(t *testing.T) {
+		scheduler, mock := setupTestScheduler(t, 10)
+
+		mock.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).
+			WithArgs(lockKey).
+			WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(true))
+		mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).
+			WithArgs(lockKey).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		conn, err := scheduler.campaign(context.Background())
+		assert.NoError(t, err)
+		if assert.NotNil(t, conn) {
+			resign(conn)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Another replica leads", func(t *testing.T) {
+		scheduler, mock := setupTestScheduler(t, 10)
+
+		mock.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).
+			WithArgs(lockKey).
+			WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(false))
+
+		conn, err := scheduler.campaign(context.Background())
+		assert.NoError(t, err)
+		assert.Nil(t, conn)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+// BackoffPolicy computes how long a failed task waits before its next attempt
+type BackoffPolicy struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// NewBackoffPolicy creates a backoff policy from environment variables
+func NewBackoffPolicy() BackoffPolicy {
+	return BackoffPolicy{
+		Base: time.Duration(getEnvInt("TASK_RETRY_BASE_SECONDS", 5)) * time.Second,
+		Max:  time.Duration(getEnvInt("TASK_RETRY_MAX_SECONDS", 3600)) * time.Second,
+	}
+}
+
+// Delay returns the wait before retrying a task that has failed the given
+// number of times. The delay doubles with every attempt up to Max, and the
+// upper half of it is randomised so that tasks failing together do not all
+// retry at the same moment.
+func (p BackoffPolicy) Delay(attempts int) time.Duration {
+	delay := p.Max
+	if shift := attempts - 1; shift >= 0 && shift < 32 {
+		if d := p.Base << uint(shift); d > 0 && d < p.Max {
+			delay = d
+		}
+	} else if shift < 0 {
+		delay = p.Base
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// getEnvInt retrieves a positive integer environment variable with a fallback value
+func getEnvInt(key string, fallback int) int {
+	val, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || val <= 0 {
+		return fallback
+	}
+	return val
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffPolicy_Delay(t *testing.T) {
+	policy := BackoffPolicy{Base: 10 * time.Second, Max: 5 * time.Minute}
+
+	tests := []struct {
+		name     string
+		attempts int
+		expected time.Duration
+	}{
+		{name: "First failure", attempts: 1, expected: 10 * time.Second},
+		{name: "Second failure", attempts: 2, expected: 20 * time.Second},
+		{name: "Fourth failure", attempts: 4, expected: 80 * time.Second},
+		{name: "Capped at max", attempts: 10, expected: 5 * time.Minute},
+		{name: "Overflow is capped", attempts: 200, expected: 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 50; i++ {
+				delay := policy.Delay(tt.attempts)
+				assert.GreaterOrEqual(t, delay, tt.expected/2)
+				assert.LessOrEqual(t, delay, tt.expected)
+			}
+		})
+	}
+}
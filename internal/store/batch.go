@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/queuet/internal/models"
+)
+
+// BatchColumns lists the columns read by ScanBatch, in scan order
+const BatchColumns = `id, name, callback_task, callback_url, callback_task_id, finished_at, created_at, updated_at`
+
+// ScanBatch reads a single batch selected with BatchColumns. Its task counts
+// are left empty; GetBatch fills them in.
+func ScanBatch(row RowScanner) (models.Batch, error) {
+	var batch models.Batch
+	var callbackTask []byte
+	err := row.Scan(
+		&batch.ID,
+		&batch.Name,
+		&callbackTask,
+		&batch.CallbackURL,
+		&batch.CallbackTaskID,
+		&batch.FinishedAt,
+		&batch.CreatedAt,
+		&batch.UpdatedAt,
+	)
+	if err != nil {
+		return batch, err
+	}
+
+	if callbackTask != nil {
+		batch.CallbackTask = &models.CreateTaskRequest{}
+		if err := json.Unmarshal(callbackTask, batch.CallbackTask); err != nil {
+			return batch, fmt.Errorf("error decoding callback task of batch %d: %v", batch.ID, err)
+		}
+	}
+
+	return batch, nil
+}
+
+// GetBatch reads a batch along with the number of its tasks in each status.
+// It returns sql.ErrNoRows when the batch does not exist.
+func GetBatch(ctx context.Context, q Querier, batchID int64) (models.Batch, error) {
+	query := `SELECT ` + BatchColumns + ` FROM batches WHERE id = $1`
+
+	batch, err := ScanBatch(q.QueryRowContext(ctx, query, batchID))
+	if err != nil {
+		return batch, err
+	}
+
+	query = `
+		SELECT status, COUNT(*)
+		FROM tasks
+		WHERE batch_id = $1
+		GROUP BY status`
+
+	rows, err := q.QueryContext(ctx, query, batchID)
+	if err != nil {
+		return batch, fmt.Errorf("error counting tasks of batch %d: %v", batchID, err)
+	}
+	defer rows.Close()
+
+	batch.Counts = map[string]int{}
+	done := 0
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return batch, err
+		}
+		batch.Counts[status] = count
+		batch.Total += count
+		if IsFinishedStatus(status) {
+			done += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return batch, err
+	}
+
+	batch.Progress = 100
+	if batch.Total > 0 {
+		batch.Progress = done * 100 / batch.Total
+	}
+	return batch, nil
+}
+
+// InsertBatch inserts an empty batch and returns its ID. The callback task
+// is stored as given and validated when the batch finishes.
+func InsertBatch(ctx context.Context, q Querier, req models.CreateBatchRequest, now time.Time) (int64, error) {
+	query := `
+		INSERT INTO batches (name, callback_task, callback_url, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $4)
+		RETURNING id`
+
+	var callbackTask []byte
+	if req.CallbackTask != nil {
+		var err error
+		if callbackTask, err = json.Marshal(req.CallbackTask); err != nil {
+			return 0, err
+		}
+	}
+
+	var batchID int64
+	err := q.QueryRowContext(ctx, query, req.Name, NullJSON(callbackTask), sql.NullString{String: req.CallbackURL, Valid: req.CallbackURL != ""}, now).Scan(&batchID)
+	return batchID, err
+}
+
+// IsFinishedStatus reports whether a task with status is done running for
+// good, successfully or not
+func IsFinishedStatus(status string) bool {
+	return status == models.TaskStatusCompleted || isFailedStatus(status)
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/queuet/internal/models"
+)
+
+// maxDependencies is the most tasks a task may depend on
+const maxDependencies = 100
+
+// dependencyStatus locks the tasks a new task depends on and returns the
+// status the new task starts in: blocked until every parent has completed,
+// or cancelled when a parent has already failed. The locks keep the parents
+// from finishing before the new task's dependencies are recorded, so it
+// cannot miss being released. Errors for missing parents are of type
+// ValidationError.
+func dependencyStatus(ctx context.Context, q Querier, parents []int64) (string, error) {
+	query := `
+		SELECT id, status
+		FROM tasks
+		WHERE id = ANY($1)
+		ORDER BY id
+		FOR SHARE`
+
+	rows, err := q.QueryContext(ctx, query, pq.Array(parents))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	found := make(map[int64]string, len(parents))
+	for rows.Next() {
+		var id int64
+		var status string
+		if err := rows.Scan(&id, &status); err != nil {
+			return "", err
+		}
+		found[id] = status
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	status := models.TaskStatusPending
+	for _, id := range parents {
+		parentStatus, ok := found[id]
+		switch {
+		case !ok:
+			return "", ValidationError(fmt.Sprintf("Dependency %d not found", id))
+		case isFailedStatus(parentStatus):
+			return models.TaskStatusCancelled, nil
+		case parentStatus != models.TaskStatusCompleted:
+			status = models.TaskStatusBlocked
+		}
+	}
+	return status, nil
+}
+
+// insertDependencies records that taskID depends on parents
+func insertDependencies(ctx context.Context, q Querier, taskID int64, parents []int64) error {
+	query := `
+		INSERT INTO task_dependencies (task_id, depends_on_id)
+		SELECT $1, unnest($2::bigint[])`
+
+	_, err := q.ExecContext(ctx, query, taskID, pq.Array(parents))
+	return err
+}
+
+// ReleaseDependents updates the blocked tasks that depend on task after it
+// changed status. Once it completes, dependents whose other parents have
+// completed too become pending. When it fails, is dead lettered or is
+// cancelled, its dependents and theirs in turn are cancelled. It returns the
+// IDs of the tasks updated.
+func ReleaseDependents(ctx context.Context, q Querier, task models.Task, now time.Time) ([]int64, error) {
+	if task.Status != models.TaskStatusCompleted && !isFailedStatus(task.Status) {
+		return nil, nil
+	}
+
+	// Lock the dependents first. Parents finishing concurrently then see
+	// each other's status, so the last of them releases the task.
+	query := `
+		SELECT id
+		FROM tasks
+		WHERE status = $1
+			AND id IN (SELECT task_id FROM task_dependencies WHERE depends_on_id = $2)
+		ORDER BY id
+		FOR UPDATE`
+
+	dependents, err := queryIDs(ctx, q, query, models.TaskStatusBlocked, task.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error locking dependents: %v", err)
+	}
+	if len(dependents) == 0 {
+		return nil, nil
+	}
+
+	if task.Status == models.TaskStatusCompleted {
+		query = `
+			UPDATE tasks
+			SET status = $1,
+				updated_at = $2
+			WHERE id = ANY($3)
+				AND NOT EXISTS (
+					SELECT 1
+					FROM task_dependencies d
+					JOIN tasks parent ON parent.id = d.depends_on_id
+					WHERE d.task_id = tasks.id AND parent.status <> $4
+				)
+			RETURNING id`
+
+		released, err := queryIDs(ctx, q, query, models.TaskStatusPending, now, pq.Array(dependents), models.TaskStatusCompleted)
+		if err != nil {
+			return nil, fmt.Errorf("error releasing dependents: %v", err)
+		}
+		return released, nil
+	}
+
+	// Everything downstream of a blocked task is blocked too
+	query = `
+		WITH RECURSIVE downstream AS (
+			SELECT unnest($1::bigint[]) AS id
+			UNION
+			SELECT d.task_id
+			FROM task_dependencies d
+			JOIN downstream ON d.depends_on_id = downstream.id
+		)
+		UPDATE tasks
+		SET status = $2,
+			updated_at = $3
+		WHERE id IN (SELECT id FROM downstream) AND status = $4
+		RETURNING id`
+
+	cancelled, err := queryIDs(ctx, q, query, pq.Array(dependents), models.TaskStatusCancelled, now, models.TaskStatusBlocked)
+	if err != nil {
+		return nil, fmt.Errorf("error cancelling dependents: %v", err)
+	}
+	return cancelled, nil
+}
+
+// isFailedStatus reports whether a task with status will never complete
+func isFailedStatus(status string) bool {
+	return status == models.TaskStatusFailed || status == models.TaskStatusDead || status == models.TaskStatusCancelled
+}
+
+// queryIDs runs a query selecting a single ID column
+func queryIDs(ctx context.Context, q Querier, query string, args ...interface{}) ([]int64, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
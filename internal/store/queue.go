@@ -0,0 +1,39 @@
+package store
+
+import (
+	"regexp"
+
+	"github.com/queuet/internal/models"
+)
+
+// QueueColumns lists the columns read by ScanQueue, in scan order
+const QueueColumns = `name, default_max_attempts, lease_seconds, max_in_flight, rate_limit, rate_period_seconds, paused, created_at, updated_at`
+
+// queueName restricts queue names to characters that are safe in URLs
+var queueName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,255}$`)
+
+// ScanQueue reads a single queue selected with QueueColumns
+func ScanQueue(row RowScanner) (models.Queue, error) {
+	var queue models.Queue
+	err := row.Scan(
+		&queue.Name,
+		&queue.DefaultMaxAttempts,
+		&queue.LeaseSeconds,
+		&queue.MaxInFlight,
+		&queue.RateLimit,
+		&queue.RatePeriodSeconds,
+		&queue.Paused,
+		&queue.CreatedAt,
+		&queue.UpdatedAt,
+	)
+	return queue, err
+}
+
+// ValidateQueueName checks that name is usable as a queue name. Errors are
+// of type ValidationError.
+func ValidateQueueName(name string) error {
+	if !queueName.MatchString(name) {
+		return ValidationError("Queue name must be 1-255 letters, digits, '.', '_' or '-'")
+	}
+	return nil
+}
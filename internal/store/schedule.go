@@ -0,0 +1,57 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/queuet/internal/cron"
+	"github.com/queuet/internal/models"
+)
+
+// ScheduleColumns lists the columns read by ScanSchedule, in scan order
+const ScheduleColumns = `id, name, cron_expression, timezone, task_template, catch_up, enabled, next_run_at, last_run_at, created_at, updated_at`
+
+// ScanSchedule reads a single schedule selected with ScheduleColumns
+func ScanSchedule(row RowScanner) (models.Schedule, error) {
+	var schedule models.Schedule
+	var template []byte
+	err := row.Scan(
+		&schedule.ID,
+		&schedule.Name,
+		&schedule.CronExpression,
+		&schedule.Timezone,
+		&template,
+		&schedule.CatchUp,
+		&schedule.Enabled,
+		&schedule.NextRunAt,
+		&schedule.LastRunAt,
+		&schedule.CreatedAt,
+		&schedule.UpdatedAt,
+	)
+	if err != nil {
+		return schedule, err
+	}
+
+	if err := json.Unmarshal(template, &schedule.TaskTemplate); err != nil {
+		return schedule, fmt.Errorf("error decoding task template of schedule %d: %v", schedule.ID, err)
+	}
+
+	return schedule, nil
+}
+
+// ParseSchedule parses a schedule's cron expression and time zone. Errors
+// are of type ValidationError.
+func ParseSchedule(expr, timezone string) (*cron.Schedule, *time.Location, error) {
+	s, err := cron.Parse(expr)
+	if err != nil {
+		return nil, nil, ValidationError(fmt.Sprintf("Invalid cron expression: %v", err))
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, nil, ValidationError(fmt.Sprintf("Invalid timezone %q", timezone))
+	}
+
+	return s, loc, nil
+}
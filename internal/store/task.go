@@ -0,0 +1,411 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/queuet/internal/models"
+)
+
+// DefaultMaxAttempts is used when a task is created without max_attempts
+// and its queue does not set default_max_attempts
+const DefaultMaxAttempts = 3
+
+// DefaultQueue is the queue of tasks created without one
+const DefaultQueue = "default"
+
+// TaskColumns lists the columns read by ScanTask, in scan order
+const TaskColumns = `id, title, description, status, queue, priority, unique_key, batch_id, lease_owner, lease_expires_at, attempts, max_attempts, last_error, payload, result, run_at, progress, progress_message, cancel_requested_at, timeout_seconds, deadline_at, created_at, updated_at`
+
+// Querier is implemented by both *sql.DB and *sql.Tx
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// ValidationError reports an invalid request. Its message is suitable for
+// returning to the client.
+type ValidationError string
+
+func (e ValidationError) Error() string {
+	return string(e)
+}
+
+// ErrUniqueKeyTaken is returned by InsertTask when a pending, blocked or
+// in-progress task already has the task's unique key
+var ErrUniqueKeyTaken = errors.New("unique key is held by another task")
+
+// maxUniqueKeyLength is the longest unique key accepted
+const maxUniqueKeyLength = 255
+
+// NewTask is a validated task ready to be inserted
+type NewTask struct {
+	Title       string
+	Description string
+	Payload     json.RawMessage
+	Queue       string
+	Priority    int
+	// MaxAttempts is nil to use the queue's default
+	MaxAttempts *int
+	RunAt       time.Time
+	// UniqueKey is empty for tasks that may be duplicated
+	UniqueKey    string
+	UniquePolicy string
+	// DependsOn lists the tasks that must complete before this one runs,
+	// without duplicates
+	DependsOn []int64
+	// BatchID is the batch the task belongs to, or 0 for none
+	BatchID int64
+	// TimeoutSeconds is nil for tasks that may run as long as they hold
+	// their lease
+	TimeoutSeconds *int
+}
+
+// NewTaskFromRequest validates a create request and resolves its defaults.
+// A delay is relative to now. Errors are of type ValidationError.
+func NewTaskFromRequest(req models.CreateTaskRequest, now time.Time) (NewTask, error) {
+	if req.Title == "" {
+		return NewTask{}, ValidationError("Title is required")
+	}
+
+	task := NewTask{
+		Title:          req.Title,
+		Description:    req.Description,
+		Payload:        req.Payload,
+		Queue:          req.Queue,
+		Priority:       req.Priority,
+		MaxAttempts:    req.MaxAttempts,
+		RunAt:          now,
+		UniqueKey:      req.UniqueKey,
+		TimeoutSeconds: req.TimeoutSeconds,
+	}
+
+	if task.Queue == "" {
+		task.Queue = DefaultQueue
+	} else if err := ValidateQueueName(task.Queue); err != nil {
+		return NewTask{}, err
+	}
+	if req.MaxAttempts != nil && *req.MaxAttempts < 1 {
+		return NewTask{}, ValidationError("Max attempts must be at least 1")
+	}
+	if req.TimeoutSeconds != nil && *req.TimeoutSeconds < 1 {
+		return NewTask{}, ValidationError("Timeout seconds must be at least 1")
+	}
+	if len(req.UniqueKey) > maxUniqueKeyLength {
+		return NewTask{}, ValidationError("Unique key must be at most 255 characters")
+	}
+
+	switch req.UniquePolicy {
+	case "":
+		task.UniquePolicy = models.UniquePolicyReject
+	case models.UniquePolicyReject, models.UniquePolicyReturnExisting, models.UniquePolicyReplace:
+		task.UniquePolicy = req.UniquePolicy
+	default:
+		return NewTask{}, ValidationError("Unique policy must be one of reject, return_existing or replace")
+	}
+	if req.UniquePolicy != "" && req.UniqueKey == "" {
+		return NewTask{}, ValidationError("Unique policy requires a unique key")
+	}
+
+	seen := make(map[int64]bool, len(req.DependsOn))
+	for _, id := range req.DependsOn {
+		if id < 1 {
+			return NewTask{}, ValidationError("Depends on must only list task IDs")
+		}
+		if !seen[id] {
+			seen[id] = true
+			task.DependsOn = append(task.DependsOn, id)
+		}
+	}
+	if len(task.DependsOn) > maxDependencies {
+		return NewTask{}, ValidationError("A task may depend on at most 100 tasks")
+	}
+
+	// Tasks run immediately unless scheduled for later
+	if req.DelaySeconds < 0 {
+		return NewTask{}, ValidationError("Delay seconds must not be negative")
+	}
+	if req.RunAt != nil && req.DelaySeconds > 0 {
+		return NewTask{}, ValidationError("Only one of run_at and delay_seconds may be set")
+	}
+	if req.RunAt != nil {
+		task.RunAt = *req.RunAt
+	} else if req.DelaySeconds > 0 {
+		task.RunAt = now.Add(time.Duration(req.DelaySeconds) * time.Second)
+	}
+
+	return task, nil
+}
+
+// InsertTask inserts a pending task and returns its ID. Without explicit
+// max attempts the task takes its queue's default_max_attempts, falling back
+// to DefaultMaxAttempts. It returns ErrUniqueKeyTaken, without inserting,
+// when another pending, in-progress or blocked task has the same unique key;
+// the unique policy is left to the caller. A task with dependencies is
+// inserted blocked unless they have all completed, and q must be a
+// transaction for them to be recorded reliably.
+func InsertTask(ctx context.Context, q Querier, task NewTask, now time.Time) (int64, error) {
+	query := `
+		INSERT INTO tasks (title, description, payload, status, queue, priority, max_attempts, run_at, created_at, updated_at, unique_key, batch_id, timeout_seconds)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, (SELECT default_max_attempts FROM queues WHERE name = $5), $8), $9, $10, $10, $11, $12, $13)
+		ON CONFLICT (unique_key) WHERE status IN ('pending', 'in_progress', 'blocked') DO NOTHING
+		RETURNING id`
+
+	status := models.TaskStatusPending
+	if len(task.DependsOn) > 0 {
+		var err error
+		if status, err = dependencyStatus(ctx, q, task.DependsOn); err != nil {
+			return 0, err
+		}
+	}
+
+	var taskID int64
+	err := q.QueryRowContext(
+		ctx,
+		query,
+		task.Title,
+		task.Description,
+		NullJSON(task.Payload),
+		status,
+		task.Queue,
+		task.Priority,
+		nullInt(task.MaxAttempts),
+		DefaultMaxAttempts,
+		task.RunAt,
+		now,
+		sql.NullString{String: task.UniqueKey, Valid: task.UniqueKey != ""},
+		sql.NullInt64{Int64: task.BatchID, Valid: task.BatchID != 0},
+		nullInt(task.TimeoutSeconds),
+	).Scan(&taskID)
+
+	if err == sql.ErrNoRows {
+		return 0, ErrUniqueKeyTaken
+	} else if err != nil {
+		return 0, err
+	}
+
+	if len(task.DependsOn) > 0 {
+		if err := insertDependencies(ctx, q, taskID, task.DependsOn); err != nil {
+			return 0, fmt.Errorf("error recording dependencies: %v", err)
+		}
+	}
+	return taskID, nil
+}
+
+// InsertTasks inserts many pending tasks with a single statement and returns
+// their IDs in order. It is meant for large batches, so it does not support
+// unique keys or dependencies; tasks using them must go through InsertTask.
+func InsertTasks(ctx context.Context, q Querier, tasks []NewTask, now time.Time) ([]int64, error) {
+	for _, task := range tasks {
+		if task.UniqueKey != "" || len(task.DependsOn) > 0 {
+			return nil, errors.New("unique keys and dependencies need InsertTask")
+		}
+	}
+
+	// Allocate the IDs up front, so they match the tasks regardless of the
+	// order rows are inserted in
+	ids, err := queryIDs(ctx, q, `SELECT nextval(pg_get_serial_sequence('tasks', 'id')) FROM generate_series(1, $1)`, len(tasks))
+	if err != nil {
+		return nil, fmt.Errorf("error allocating task IDs: %v", err)
+	}
+
+	titles := make([]string, len(tasks))
+	descriptions := make([]string, len(tasks))
+	payloads := make([]sql.NullString, len(tasks))
+	queues := make([]string, len(tasks))
+	priorities := make([]int64, len(tasks))
+	maxAttempts := make([]sql.NullInt64, len(tasks))
+	runAts := make([]string, len(tasks))
+	batchIDs := make([]sql.NullInt64, len(tasks))
+	timeouts := make([]sql.NullInt64, len(tasks))
+	for i, task := range tasks {
+		titles[i] = task.Title
+		descriptions[i] = task.Description
+		payloads[i] = NullJSON(task.Payload)
+		queues[i] = task.Queue
+		priorities[i] = int64(task.Priority)
+		maxAttempts[i] = nullInt(task.MaxAttempts)
+		runAts[i] = task.RunAt.Format(time.RFC3339Nano)
+		batchIDs[i] = sql.NullInt64{Int64: task.BatchID, Valid: task.BatchID != 0}
+		timeouts[i] = nullInt(task.TimeoutSeconds)
+	}
+
+	query := `
+		INSERT INTO tasks (id, title, description, payload, status, queue, priority, max_attempts, run_at, created_at, updated_at, batch_id, timeout_seconds)
+		SELECT t.id, t.title, t.description, t.payload::jsonb, $1, t.queue, t.priority, COALESCE(t.max_attempts, q.default_max_attempts, $2), t.run_at, $3, $3, t.batch_id, t.timeout_seconds
+		FROM unnest($4::bigint[], $5::text[], $6::text[], $7::text[], $8::text[], $9::int[], $10::int[], $11::timestamptz[], $12::bigint[], $13::int[])
+			AS t(id, title, description, payload, queue, priority, max_attempts, run_at, batch_id, timeout_seconds)
+		LEFT JOIN queues q ON q.name = t.queue`
+
+	_, err = q.ExecContext(
+		ctx,
+		query,
+		models.TaskStatusPending,
+		DefaultMaxAttempts,
+		now,
+		pq.Array(ids),
+		pq.Array(titles),
+		pq.Array(descriptions),
+		pq.Array(payloads),
+		pq.Array(queues),
+		pq.Array(priorities),
+		pq.Array(maxAttempts),
+		pq.Array(runAts),
+		pq.Array(batchIDs),
+		pq.Array(timeouts),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting tasks: %v", err)
+	}
+
+	return ids, nil
+}
+
+// nullInt converts an optional int to a nullable query argument
+func nullInt(n *int) sql.NullInt64 {
+	if n == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*n), Valid: true}
+}
+
+// RowScanner is implemented by both *sql.Row and *sql.Rows
+type RowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// ScanTask reads a single task selected with TaskColumns
+func ScanTask(row RowScanner) (models.Task, error) {
+	var task models.Task
+	var payload, result []byte
+	err := row.Scan(
+		&task.ID,
+		&task.Title,
+		&task.Description,
+		&task.Status,
+		&task.Queue,
+		&task.Priority,
+		&task.UniqueKey,
+		&task.BatchID,
+		&task.LeaseOwner,
+		&task.LeaseExpiresAt,
+		&task.Attempts,
+		&task.MaxAttempts,
+		&task.LastError,
+		&payload,
+		&result,
+		&task.RunAt,
+		&task.Progress,
+		&task.ProgressMessage,
+		&task.CancelRequestedAt,
+		&task.TimeoutSeconds,
+		&task.DeadlineAt,
+		&task.CreatedAt,
+		&task.UpdatedAt,
+	)
+	task.Payload = payload
+	task.Result = result
+	return task, err
+}
+
+// ScanTasks reads every task selected with TaskColumns and closes rows
+func ScanTasks(rows *sql.Rows) ([]models.Task, error) {
+	defer rows.Close()
+
+	var tasks []models.Task
+	for rows.Next() {
+		task, err := ScanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+// NullJSON converts an optional JSON document into a query argument
+func NullJSON(doc json.RawMessage) sql.NullString {
+	return sql.NullString{String: string(doc), Valid: len(doc) > 0}
+}
+
+// LockTask selects a task and locks its row until tx ends. It returns
+// sql.ErrNoRows when the task does not exist.
+func LockTask(ctx context.Context, tx *sql.Tx, taskID int64) (models.Task, error) {
+	query := `
+		SELECT ` + TaskColumns + `
+		FROM tasks
+		WHERE id = $1
+		FOR UPDATE`
+
+	return ScanTask(tx.QueryRowContext(ctx, query, taskID))
+}
+
+// Failure describes a failed attempt at running a task
+type Failure struct {
+	WorkerID string
+	Error    string
+	// Retry is false when the failure is permanent and the task should not
+	// be attempted again regardless of its remaining attempts
+	Retry bool
+}
+
+// FailTask records a failed attempt of a task locked by tx. The task is
+// rescheduled as pending after a backoff delay. Once it has used up
+// max_attempts it is moved to the dead letter queue, and a failure that is
+// not retryable marks it failed straight away. A task whose cancellation was
+// requested while it ran is cancelled instead of retried.
+func FailTask(ctx context.Context, tx *sql.Tx, task models.Task, failure Failure, policy BackoffPolicy, now time.Time) (models.Task, error) {
+	attempts := task.Attempts + 1
+
+	// Keep a history of every failed attempt
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO task_attempts (task_id, attempt, worker_id, error, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		task.ID,
+		attempts,
+		sql.NullString{String: failure.WorkerID, Valid: failure.WorkerID != ""},
+		failure.Error,
+		now,
+	)
+	if err != nil {
+		return models.Task{}, fmt.Errorf("error recording attempt: %v", err)
+	}
+
+	status := models.TaskStatusPending
+	runAt := now.Add(policy.Delay(attempts))
+	if task.CancelRequestedAt != nil {
+		status = models.TaskStatusCancelled
+		runAt = task.RunAt
+	} else if !failure.Retry {
+		status = models.TaskStatusFailed
+		runAt = task.RunAt
+	} else if attempts >= task.MaxAttempts {
+		status = models.TaskStatusDead
+		runAt = task.RunAt
+	}
+
+	query := `
+		UPDATE tasks
+		SET status = $1,
+			attempts = $2,
+			last_error = $3,
+			run_at = $4,
+			lease_owner = NULL,
+			lease_expires_at = NULL,
+			updated_at = $5
+		WHERE id = $6
+		RETURNING ` + TaskColumns
+
+	updated, err := ScanTask(tx.QueryRowContext(ctx, query, status, attempts, failure.Error, runAt, now, task.ID))
+	if err != nil {
+		return models.Task{}, fmt.Errorf("error updating failed task: %v", err)
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,162 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/queuet/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// taskRowColumns are the column names produced by a TaskColumns select
+var taskRowColumns = []string{"id", "title", "description", "status", "queue", "priority", "unique_key", "batch_id", "lease_owner", "lease_expires_at", "attempts", "max_attempts", "last_error", "payload", "result", "run_at", "progress", "progress_message", "cancel_requested_at", "timeout_seconds", "deadline_at", "created_at", "updated_at"}
+
+func TestScanTask(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery(`SELECT`).
+		WillReturnRows(sqlmock.NewRows(taskRowColumns).
+			AddRow(1, "Task", "Description", "completed", "reports", 5, nil, nil, nil, nil, 1, 3, "flaky", []byte(`{"n":1}`), []byte(`{"ok":true}`), now, 100, "done", nil, nil, nil, now, now))
+
+	task, err := ScanTask(db.QueryRow(`SELECT ` + TaskColumns + ` FROM tasks`))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), task.ID)
+	assert.Equal(t, "reports", task.Queue)
+	assert.Equal(t, 5, task.Priority)
+	assert.Nil(t, task.LeaseOwner)
+	assert.Equal(t, 3, task.MaxAttempts)
+	if assert.NotNil(t, task.LastError) {
+		assert.Equal(t, "flaky", *task.LastError)
+	}
+	assert.JSONEq(t, `{"n":1}`, string(task.Payload))
+	assert.JSONEq(t, `{"ok":true}`, string(task.Result))
+	if assert.NotNil(t, task.Progress) {
+		assert.Equal(t, 100, *task.Progress)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFailTask(t *testing.T) {
+	policy := BackoffPolicy{Base: time.Minute, Max: time.Hour}
+	failQuery := `UPDATE tasks SET status = \$1, attempts = \$2, last_error = \$3, run_at = \$4, lease_owner = NULL, lease_expires_at = NULL, updated_at = \$5 WHERE id = \$6 RETURNING ` + regexp.QuoteMeta(TaskColumns)
+
+	tests := []struct {
+		name            string
+		attempts        int
+		retry           bool
+		cancelRequested bool
+		expectedStatus  string
+	}{
+		{name: "Retried with backoff", attempts: 0, retry: true, expectedStatus: models.TaskStatusPending},
+		{name: "Out of attempts", attempts: 2, retry: true, expectedStatus: models.TaskStatusDead},
+		{name: "Not retryable", attempts: 0, retry: false, expectedStatus: models.TaskStatusFailed},
+		{name: "Cancellation requested", attempts: 0, retry: true, cancelRequested: true, expectedStatus: models.TaskStatusCancelled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("Failed to create mock DB: %v", err)
+			}
+			defer db.Close()
+
+			now := time.Now()
+			task := models.Task{ID: 7, Attempts: tt.attempts, MaxAttempts: 3, RunAt: now.Add(-time.Hour)}
+			if tt.cancelRequested {
+				task.CancelRequestedAt = &now
+			}
+
+			// Retries are pushed into the future; terminal failures keep their run_at
+			var runAt interface{} = afterTime{now.Add(30 * time.Second)}
+			if tt.expectedStatus != models.TaskStatusPending {
+				runAt = task.RunAt
+			}
+
+			mock.ExpectBegin()
+			mock.ExpectExec(`INSERT INTO task_attempts \(task_id, attempt, worker_id, error, created_at\) VALUES \(\$1, \$2, \$3, \$4, \$5\)`).
+				WithArgs(int64(7), tt.attempts+1, sql.NullString{String: "worker-1", Valid: true}, "boom", now).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery(failQuery).
+				WithArgs(tt.expectedStatus, tt.attempts+1, "boom", runAt, now, int64(7)).
+				WillReturnRows(sqlmock.NewRows(taskRowColumns).
+					AddRow(7, "Task", "", tt.expectedStatus, "default", 0, nil, nil, nil, nil, tt.attempts+1, 3, "boom", nil, nil, now, nil, nil, nil, nil, nil, now, now))
+
+			tx, err := db.Begin()
+			assert.NoError(t, err)
+
+			failure := Failure{WorkerID: "worker-1", Error: "boom", Retry: tt.retry}
+			updated, err := FailTask(context.Background(), tx, task, failure, policy, now)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, updated.Status)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// afterTime matches time arguments later than the given instant
+type afterTime struct {
+	t time.Time
+}
+
+func (a afterTime) Match(v driver.Value) bool {
+	ts, ok := v.(time.Time)
+	return ok && ts.After(a.t)
+}
+
+func TestInsertTasks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	runAt := now.Add(time.Hour)
+	maxAttempts := 5
+	timeout := 30
+	tasks := []NewTask{
+		{Title: "First", Queue: "default", RunAt: now},
+		{Title: "Second", Description: "With payload", Payload: []byte(`{"a":1}`), Queue: "emails", Priority: 2, MaxAttempts: &maxAttempts, RunAt: runAt, BatchID: 4, TimeoutSeconds: &timeout},
+	}
+
+	mock.ExpectQuery(`SELECT nextval\(pg_get_serial_sequence\('tasks', 'id'\)\) FROM generate_series\(1, \$1\)`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(10).AddRow(11))
+	mock.ExpectExec(`INSERT INTO tasks \(id, title, description, payload, status, queue, priority, max_attempts, run_at, created_at, updated_at, batch_id, timeout_seconds\)`).
+		WithArgs(
+			models.TaskStatusPending,
+			DefaultMaxAttempts,
+			now,
+			pq.Array([]int64{10, 11}),
+			pq.Array([]string{"First", "Second"}),
+			pq.Array([]string{"", "With payload"}),
+			pq.Array([]sql.NullString{{}, {String: `{"a":1}`, Valid: true}}),
+			pq.Array([]string{"default", "emails"}),
+			pq.Array([]int64{0, 2}),
+			pq.Array([]sql.NullInt64{{}, {Int64: 5, Valid: true}}),
+			pq.Array([]string{now.Format(time.RFC3339Nano), runAt.Format(time.RFC3339Nano)}),
+			pq.Array([]sql.NullInt64{{}, {Int64: 4, Valid: true}}),
+			pq.Array([]sql.NullInt64{{}, {Int64: 30, Valid: true}}),
+		).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	ids, err := InsertTasks(context.Background(), db, tasks, now)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{10, 11}, ids)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	_, err = InsertTasks(context.Background(), db, []NewTask{{Title: "Sync", UniqueKey: "sync"}}, now)
+	assert.Error(t, err)
+}
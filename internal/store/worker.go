@@ -0,0 +1,27 @@
+package store
+
+import (
+	"github.com/lib/pq"
+	"github.com/queuet/internal/models"
+)
+
+// WorkerColumns lists the columns read by ScanWorker, in scan order
+const WorkerColumns = `id, hostname, queues, version, concurrency, status, last_seen_at, created_at, updated_at`
+
+// ScanWorker reads a single worker selected with WorkerColumns. Its leased
+// tasks are left empty.
+func ScanWorker(row RowScanner) (models.Worker, error) {
+	worker := models.Worker{LeasedTasks: []models.LeasedTask{}}
+	err := row.Scan(
+		&worker.ID,
+		&worker.Hostname,
+		pq.Array(&worker.Queues),
+		&worker.Version,
+		&worker.Concurrency,
+		&worker.Status,
+		&worker.LastSeenAt,
+		&worker.CreatedAt,
+		&worker.UpdatedAt,
+	)
+	return worker, err
+}
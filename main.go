@@ -7,8 +7,10 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
+	_ "time/tzdata" // schedules may use any IANA time zone
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -16,7 +18,10 @@ import (
 	"github.com/queuet/internal/cache"
 	"github.com/queuet/internal/database"
 	"github.com/queuet/internal/handlers"
+	"github.com/queuet/internal/notify"
+	"github.com/queuet/internal/reaper"
 	"github.com/queuet/internal/routes"
+	"github.com/queuet/internal/scheduler"
 )
 
 func main() {
@@ -62,15 +67,53 @@ func main() {
 		}
 	})
 
-	// Initialize task handler and API routes
-	taskHandler := handlers.NewTaskHandler(db, redisClient)
-	routes.SetupRoutes(r, taskHandler)
+	// Claims waiting for tasks are woken by notifications from the database
+	notifier := notify.NewNotifier()
+
+	// Initialize handlers and API routes
+	handlerConfig := handlers.NewConfig()
+	taskHandler := handlers.NewTaskHandler(db, redisClient, cache.NewRateLimiter(redisClient), notifier, handlerConfig)
+	scheduleHandler := handlers.NewScheduleHandler(db, handlerConfig)
+	queueHandler := handlers.NewQueueHandler(db)
+	workerHandler := handlers.NewWorkerHandler(db)
+	routes.SetupRoutes(r, taskHandler, scheduleHandler, queueHandler, workerHandler)
 
 	server := &http.Server{
 		Addr:              fmt.Sprintf(":%s", port),
 		Handler:           r,
 		ReadHeaderTimeout: 5 * time.Second,
 	}
+	// Waiting claims return as soon as shutdown starts instead of holding it up
+	server.RegisterOnShutdown(notifier.Close)
+
+	// Background workers run until shutdown is signalled
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+
+	// Relay task notifications to waiting claims
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		if err := notifier.Listen(backgroundCtx, dbConfig.DSN()); err != nil {
+			log.Printf("Error listening for task notifications: %v", err)
+		}
+	}()
+
+	// Return tasks with expired leases, or leased to dead workers, to the queue
+	taskReaper := reaper.NewReaper(db, redisClient, reaper.NewConfig())
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		taskReaper.Run(backgroundCtx)
+	}()
+
+	// Enqueue tasks for cron schedules; replicas elect a single leader
+	taskScheduler := scheduler.NewScheduler(db, scheduler.NewConfig())
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		taskScheduler.Run(backgroundCtx)
+	}()
 
 	// Server run context
 	serverCtx, serverStopCtx := context.WithCancel(context.Background())
@@ -97,6 +140,11 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
+
+		// Stop background workers and wait for them to finish
+		stopBackground()
+		background.Wait()
+
 		serverStopCtx()
 	}()
 
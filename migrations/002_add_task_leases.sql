@@ -0,0 +1,5 @@
+ALTER TABLE tasks
+    ADD COLUMN lease_owner VARCHAR(255),
+    ADD COLUMN lease_expires_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_tasks_pending_created_at ON tasks(created_at, id) WHERE status = 'pending';
@@ -0,0 +1,4 @@
+ALTER TABLE tasks
+    ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_tasks_in_progress_lease_expires_at ON tasks(lease_expires_at) WHERE status = 'in_progress';
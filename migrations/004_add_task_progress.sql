@@ -0,0 +1,3 @@
+ALTER TABLE tasks
+    ADD COLUMN progress SMALLINT CHECK (progress BETWEEN 0 AND 100),
+    ADD COLUMN progress_message TEXT;
@@ -0,0 +1,4 @@
+-- run_at was added alongside retries; claims now order pending tasks by it
+CREATE INDEX idx_tasks_pending_run_at ON tasks(run_at, id) WHERE status = 'pending';
+
+DROP INDEX IF EXISTS idx_tasks_pending_created_at;
@@ -0,0 +1,16 @@
+CREATE TABLE schedules (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    cron_expression VARCHAR(255) NOT NULL,
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    task_template JSONB NOT NULL,
+    catch_up VARCHAR(16) NOT NULL DEFAULT 'skip' CHECK (catch_up IN ('skip', 'once', 'all')),
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    next_run_at TIMESTAMP WITH TIME ZONE,
+    last_run_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The scheduler polls for enabled schedules that are due
+CREATE INDEX idx_schedules_due ON schedules(next_run_at) WHERE enabled;
@@ -0,0 +1,6 @@
+ALTER TABLE tasks ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
+
+-- Claims take the highest priority pending task, oldest first
+CREATE INDEX idx_tasks_pending_priority ON tasks(priority DESC, run_at, id) WHERE status = 'pending';
+
+DROP INDEX IF EXISTS idx_tasks_pending_run_at;
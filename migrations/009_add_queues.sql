@@ -0,0 +1,16 @@
+CREATE TABLE queues (
+    name VARCHAR(255) PRIMARY KEY,
+    default_max_attempts INTEGER CHECK (default_max_attempts > 0),
+    lease_seconds INTEGER CHECK (lease_seconds > 0),
+    paused BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO queues (name) VALUES ('default');
+
+-- Queues without a row in queues use the server defaults
+ALTER TABLE tasks ADD COLUMN queue VARCHAR(255) NOT NULL DEFAULT 'default';
+
+-- Claims scoped to specific queues
+CREATE INDEX idx_tasks_pending_queue ON tasks(queue, priority DESC, run_at, id) WHERE status = 'pending';
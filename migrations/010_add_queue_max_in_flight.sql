@@ -0,0 +1,5 @@
+-- Queues without max_in_flight have no concurrency limit
+ALTER TABLE queues ADD COLUMN max_in_flight INTEGER CHECK (max_in_flight > 0);
+
+-- In-flight counts checked by claims on limited queues
+CREATE INDEX idx_tasks_in_progress_queue ON tasks(queue) WHERE status = 'in_progress';
@@ -0,0 +1,4 @@
+-- Queues without rate_limit are not rate limited; rate_period_seconds
+-- defaults to one second
+ALTER TABLE queues ADD COLUMN rate_limit INTEGER CHECK (rate_limit > 0);
+ALTER TABLE queues ADD COLUMN rate_period_seconds INTEGER CHECK (rate_period_seconds > 0);
@@ -0,0 +1,11 @@
+-- task_id and status_code are set in the transaction that claims the key
+CREATE TABLE idempotency_keys (
+    key VARCHAR(255) PRIMARY KEY,
+    request_hash VARCHAR(64) NOT NULL,
+    task_id INTEGER,
+    status_code INTEGER,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Purging keys past their retention
+CREATE INDEX idx_idempotency_keys_created_at ON idempotency_keys(created_at);
@@ -0,0 +1,4 @@
+ALTER TABLE tasks ADD COLUMN unique_key VARCHAR(255);
+
+-- Only one pending or in-progress task may hold a unique key at a time
+CREATE UNIQUE INDEX idx_tasks_unique_key ON tasks(unique_key) WHERE status IN ('pending', 'in_progress');
@@ -0,0 +1,13 @@
+CREATE TABLE task_dependencies (
+    task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    depends_on_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    PRIMARY KEY (task_id, depends_on_id)
+);
+
+-- Finding the dependents of a task when it finishes
+CREATE INDEX idx_task_dependencies_depends_on_id ON task_dependencies(depends_on_id);
+
+-- Blocked tasks hold their unique key too, so releasing one never collides
+-- with a pending task
+DROP INDEX idx_tasks_unique_key;
+CREATE UNIQUE INDEX idx_tasks_unique_key ON tasks(unique_key) WHERE status IN ('pending', 'in_progress', 'blocked');
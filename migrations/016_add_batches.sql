@@ -0,0 +1,22 @@
+CREATE TABLE batches (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    callback_task JSONB,
+    callback_url TEXT,
+    callback_task_id INTEGER REFERENCES tasks(id) ON DELETE SET NULL,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    webhook_attempts INTEGER NOT NULL DEFAULT 0,
+    webhook_next_at TIMESTAMP WITH TIME ZONE,
+    webhook_delivered_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE tasks ADD COLUMN batch_id INTEGER REFERENCES batches(id) ON DELETE SET NULL;
+
+-- Counting the tasks of a batch by status
+CREATE INDEX idx_tasks_batch_id ON tasks(batch_id, status) WHERE batch_id IS NOT NULL;
+
+-- The reaper polls for unfinished batches and for webhooks that are due
+CREATE INDEX idx_batches_unfinished ON batches(id) WHERE finished_at IS NULL;
+CREATE INDEX idx_batches_webhook_due ON batches(webhook_next_at) WHERE webhook_next_at IS NOT NULL;
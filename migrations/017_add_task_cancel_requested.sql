@@ -0,0 +1,3 @@
+-- Set when cancelling a task that a worker is running; the worker sees it on
+-- its next heartbeat
+ALTER TABLE tasks ADD COLUMN cancel_requested_at TIMESTAMP WITH TIME ZONE;
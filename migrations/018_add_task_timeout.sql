@@ -0,0 +1,8 @@
+-- timeout_seconds bounds each attempt at a task; deadline_at is set from it
+-- when the task is claimed
+ALTER TABLE tasks
+    ADD COLUMN timeout_seconds INTEGER,
+    ADD COLUMN deadline_at TIMESTAMP WITH TIME ZONE;
+
+-- The reaper polls for running tasks past their deadline
+CREATE INDEX idx_tasks_in_progress_deadline_at ON tasks(deadline_at) WHERE status = 'in_progress';
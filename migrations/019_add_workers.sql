@@ -0,0 +1,19 @@
+-- Workers register under the worker_id they lease tasks with, and are
+-- marked dead once they stop heartbeating
+CREATE TABLE workers (
+    id VARCHAR(255) PRIMARY KEY,
+    hostname VARCHAR(255) NOT NULL DEFAULT '',
+    queues TEXT[] NOT NULL DEFAULT '{}',
+    version VARCHAR(255) NOT NULL DEFAULT '',
+    concurrency INTEGER NOT NULL DEFAULT 1 CHECK (concurrency > 0),
+    status VARCHAR(50) NOT NULL DEFAULT 'alive',
+    last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The reaper polls for live workers that have gone quiet
+CREATE INDEX idx_workers_alive_last_seen_at ON workers(last_seen_at) WHERE status = 'alive';
+
+-- Listing the tasks leased to each worker
+CREATE INDEX idx_tasks_in_progress_lease_owner ON tasks(lease_owner) WHERE status = 'in_progress';
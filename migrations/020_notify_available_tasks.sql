@@ -0,0 +1,22 @@
+-- Wake the claims waiting on a queue whenever a task on it becomes
+-- claimable: when it is created, released by its dependencies, retried,
+-- replayed or enqueued by a schedule. Postgres sends the notification when
+-- the transaction commits, and only once per queue.
+CREATE FUNCTION notify_tasks_available() RETURNS trigger AS $$
+BEGIN
+    IF NEW.run_at > clock_timestamp() THEN
+        RETURN NULL;
+    END IF;
+    IF TG_OP = 'UPDATE' AND OLD.status = 'pending' AND OLD.queue = NEW.queue AND OLD.run_at <= NEW.run_at THEN
+        RETURN NULL;
+    END IF;
+    PERFORM pg_notify('tasks_available', NEW.queue);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER tasks_notify_available
+    AFTER INSERT OR UPDATE OF status, queue, run_at ON tasks
+    FOR EACH ROW
+    WHEN (NEW.status = 'pending')
+    EXECUTE FUNCTION notify_tasks_available();
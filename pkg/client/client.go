@@ -0,0 +1,170 @@
+// Package client is a typed Go client for the Queuet tasks API
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Errors matched by the Error of a response with the same status, e.g.
+// errors.Is(err, client.ErrNotFound)
+var (
+	ErrBadRequest = errors.New("bad request")
+	ErrNotFound   = errors.New("not found")
+	ErrConflict   = errors.New("conflict")
+)
+
+// Error is returned for responses with a 4xx or 5xx status. Message is the
+// plain text error sent by the server.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("queuet: %d %s", e.StatusCode, e.Message)
+}
+
+// Is reports whether target is the sentinel error for e's status
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+// Config configures a Client. Only URL is required.
+type Config struct {
+	// URL is the base URL of the server, e.g. http://localhost:8080
+	URL string
+	// Timeout bounds each attempt at a request, default 10 seconds
+	Timeout time.Duration
+	// MaxRetries is how many times a GET, PUT or DELETE is retried after a
+	// network error, a 429 or a 5xx response. Other requests are not
+	// idempotent and are never retried. 0 disables retries.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, doubling with each
+	// one after it, default 100 milliseconds
+	RetryBackoff time.Duration
+	// HTTPClient sends the requests, default http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// Client calls the tasks API. It is safe for concurrent use.
+type Client struct {
+	config Config
+}
+
+// New creates a Client, filling in the defaults of config
+func New(config Config) *Client {
+	config.URL = strings.TrimRight(config.URL, "/")
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = 100 * time.Millisecond
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	return &Client{config: config}
+}
+
+// do sends a request to path under /api/v1 with in encoded as its JSON body,
+// retrying idempotent methods, and decodes a successful response into out
+// when it has a body. It returns the final response, whose body is closed,
+// or an *Error for 4xx and 5xx responses.
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, in, out interface{}) (*http.Response, error) {
+	return c.doWithTimeout(ctx, c.config.Timeout, method, path, header, in, out)
+}
+
+// doWithTimeout is do with each attempt bounded by timeout instead of
+// Timeout, for requests the server may hold open
+func (c *Client) doWithTimeout(ctx context.Context, timeout time.Duration, method, path string, header http.Header, in, out interface{}) (*http.Response, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return nil, err
+		}
+	}
+
+	retries := 0
+	if method == http.MethodGet || method == http.MethodPut || method == http.MethodDelete {
+		retries = c.config.MaxRetries
+	}
+
+	delay := c.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.attempt(ctx, timeout, method, path, header, body, out)
+		if attempt == retries || !retryable(resp, err) || ctx.Err() != nil {
+			return resp, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// attempt sends a request once, within timeout
+func (c *Client) attempt(ctx context.Context, timeout time.Duration, method, path string, header http.Header, body []byte, out interface{}) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, c.config.URL+"/api/v1"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return resp, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("error decoding response: %v", err)
+		}
+	}
+	return resp, nil
+}
+
+// retryable reports whether a failed attempt may succeed if sent again
+func retryable(resp *http.Response, err error) bool {
+	if resp == nil {
+		return err != nil
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestError_Is(t *testing.T) {
+	tests := []struct {
+		status   int
+		target   error
+		expected bool
+	}{
+		{status: http.StatusBadRequest, target: ErrBadRequest, expected: true},
+		{status: http.StatusNotFound, target: ErrNotFound, expected: true},
+		{status: http.StatusConflict, target: ErrConflict, expected: true},
+		{status: http.StatusConflict, target: ErrNotFound, expected: false},
+		{status: http.StatusInternalServerError, target: ErrBadRequest, expected: false},
+	}
+
+	for _, tt := range tests {
+		var err error = &Error{StatusCode: tt.status, Message: "Task not found"}
+		assert.Equal(t, tt.expected, errors.Is(err, tt.target), "%d is %v", tt.status, tt.target)
+	}
+}
+
+func TestClient_Retries(t *testing.T) {
+	tests := []struct {
+		name             string
+		method           string
+		statuses         []int
+		maxRetries       int
+		expectedAttempts int32
+		expectedStatus   int
+	}{
+		{
+			name:             "GET retried until it succeeds",
+			method:           http.MethodGet,
+			statuses:         []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			maxRetries:       2,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "Retries run out",
+			method:           http.MethodDelete,
+			statuses:         []int{http.StatusBadGateway, http.StatusBadGateway},
+			maxRetries:       1,
+			expectedAttempts: 2,
+			expectedStatus:   http.StatusBadGateway,
+		},
+		{
+			name:             "Client errors are not retried",
+			method:           http.MethodGet,
+			statuses:         []int{http.StatusNotFound},
+			maxRetries:       2,
+			expectedAttempts: 1,
+			expectedStatus:   http.StatusNotFound,
+		},
+		{
+			name:             "POST is not retried",
+			method:           http.MethodPost,
+			statuses:         []int{http.StatusServiceUnavailable},
+			maxRetries:       2,
+			expectedAttempts: 1,
+			expectedStatus:   http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[atomic.AddInt32(&attempts, 1)-1]
+				if status >= 400 {
+					http.Error(w, "upstream down", status)
+					return
+				}
+				w.Write([]byte(`{}`))
+			}))
+			defer server.Close()
+
+			c := New(Config{URL: server.URL, MaxRetries: tt.maxRetries, RetryBackoff: time.Millisecond})
+			_, err := c.do(context.Background(), tt.method, "/tasks", nil, nil, &struct{}{})
+
+			assert.Equal(t, tt.expectedAttempts, atomic.LoadInt32(&attempts))
+			if tt.expectedStatus == 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, &Error{StatusCode: tt.expectedStatus, Message: "upstream down"}, err)
+			}
+		})
+	}
+}
+
+func TestClient_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	c := New(Config{URL: server.URL, Timeout: 10 * time.Millisecond})
+	_, err := c.GetTask(context.Background(), 1)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestClient_WaitForTask(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/tasks/claim", r.URL.Path)
+		assert.Equal(t, "1", r.URL.Query().Get("wait"))
+		// Held past Timeout, which the wait extends
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	c := New(Config{URL: server.URL, Timeout: 10 * time.Millisecond})
+	task, retryAfter, err := c.WaitForTask(context.Background(), ClaimTaskRequest{WorkerID: "worker-1"}, time.Second)
+
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+	assert.Zero(t, retryAfter)
+}
+
+func TestNew(t *testing.T) {
+	c := New(Config{URL: "http://localhost:8080/", MaxRetries: -1})
+
+	assert.Equal(t, "http://localhost:8080", c.config.URL)
+	assert.Equal(t, 10*time.Second, c.config.Timeout)
+	assert.Equal(t, 0, c.config.MaxRetries)
+	assert.Equal(t, 100*time.Millisecond, c.config.RetryBackoff)
+	assert.Equal(t, http.DefaultClient, c.config.HTTPClient)
+}
@@ -0,0 +1,266 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// CreateTask creates a task and returns its ID. Under the return_existing
+// unique policy this is the ID of the task already holding the unique key.
+func (c *Client) CreateTask(ctx context.Context, req CreateTaskRequest) (int64, error) {
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if _, err := c.do(ctx, http.MethodPost, "/tasks", nil, req, &created); err != nil {
+		return 0, err
+	}
+	return created.ID, nil
+}
+
+// CreateTaskIdempotently creates a task under an idempotency key. Retrying
+// with the same key and request returns the task created the first time.
+func (c *Client) CreateTaskIdempotently(ctx context.Context, key string, req CreateTaskRequest) (int64, error) {
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	header := http.Header{"Idempotency-Key": []string{key}}
+	if _, err := c.do(ctx, http.MethodPost, "/tasks", header, req, &created); err != nil {
+		return 0, err
+	}
+	return created.ID, nil
+}
+
+// GetTask gets a task by ID
+func (c *Client) GetTask(ctx context.Context, id int64) (*Task, error) {
+	var task Task
+	if _, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/tasks/%d", id), nil, nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// UpdateTask updates a task and returns it. Empty fields of req are left
+// unchanged.
+func (c *Client) UpdateTask(ctx context.Context, id int64, req UpdateTaskRequest) (*Task, error) {
+	var task Task
+	if _, err := c.do(ctx, http.MethodPut, fmt.Sprintf("/tasks/%d", id), nil, req, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// DeleteTask deletes a task
+func (c *Client) DeleteTask(ctx context.Context, id int64) error {
+	_, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/tasks/%d", id), nil, nil, nil)
+	return err
+}
+
+// ClaimTask leases the next available task. When there is none it returns a
+// nil task, along with how long the server asked to wait before claiming
+// again, or 0 when it did not say.
+func (c *Client) ClaimTask(ctx context.Context, req ClaimTaskRequest) (*Task, time.Duration, error) {
+	return c.WaitForTask(ctx, req, 0)
+}
+
+// WaitForTask is ClaimTask, except that when no task is available the server
+// holds the request for up to wait, in whole seconds, until one is created.
+// The server caps the wait, by default at 30 seconds.
+func (c *Client) WaitForTask(ctx context.Context, req ClaimTaskRequest, wait time.Duration) (*Task, time.Duration, error) {
+	var task Task
+	retryAfter, claimed, err := c.claim(ctx, req, url.Values{}, wait, &task)
+	if err != nil || !claimed {
+		return nil, retryAfter, err
+	}
+	return &task, 0, nil
+}
+
+// ClaimTasks leases up to max available tasks at once, waiting for up to wait
+// as WaitForTask does when there are none. The server caps max, by default
+// at 100.
+func (c *Client) ClaimTasks(ctx context.Context, req ClaimTaskRequest, max int, wait time.Duration) ([]Task, time.Duration, error) {
+	var tasks []Task
+	retryAfter, _, err := c.claim(ctx, req, url.Values{"max": {strconv.Itoa(max)}}, wait, &tasks)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tasks, retryAfter, nil
+}
+
+// claim sends a claim with the query parameters, decoding claimed tasks into
+// out. It reports whether any task was claimed, and otherwise how long the
+// server asked to wait before claiming again.
+func (c *Client) claim(ctx context.Context, req ClaimTaskRequest, query url.Values, wait time.Duration, out interface{}) (time.Duration, bool, error) {
+	if seconds := int(wait / time.Second); seconds > 0 {
+		query.Set("wait", strconv.Itoa(seconds))
+	}
+	path := "/tasks/claim"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	resp, err := c.doWithTimeout(ctx, c.config.Timeout+wait, http.MethodPost, path, nil, req, out)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if resp.StatusCode == http.StatusNoContent {
+		var retryAfter time.Duration
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return retryAfter, false, nil
+	}
+	return 0, true, nil
+}
+
+// Heartbeat extends the lease on a task and returns it, so that the worker
+// can see whether its cancellation was requested
+func (c *Client) Heartbeat(ctx context.Context, id int64, req HeartbeatRequest) (*Task, error) {
+	var task Task
+	if _, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/tasks/%d/heartbeat", id), nil, req, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// CompleteTask marks a leased task as completed
+func (c *Client) CompleteTask(ctx context.Context, id int64, req CompleteTaskRequest) (*Task, error) {
+	var task Task
+	if _, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/tasks/%d/complete", id), nil, req, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// CompleteTasks marks many leased tasks as completed in one request. A task
+// that could not be completed is reported in its result rather than as an
+// error.
+func (c *Client) CompleteTasks(ctx context.Context, req CompleteTaskBatchRequest) (*TaskBatchResponse, error) {
+	var response TaskBatchResponse
+	if _, err := c.do(ctx, http.MethodPost, "/tasks/complete", nil, req, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// FailTask reports a failed attempt at a leased task
+func (c *Client) FailTask(ctx context.Context, id int64, req FailTaskRequest) (*Task, error) {
+	var task Task
+	if _, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/tasks/%d/fail", id), nil, req, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// ListOptions filters the tasks listed by ListTasks. Zero values do not
+// filter.
+type ListOptions struct {
+	Queue       string
+	BatchID     int64
+	Scheduled   bool
+	Priority    *int
+	MinPriority *int
+	// Sort is created_at (the default) or priority
+	Sort string
+	// PageSize is how many tasks are fetched per request, default 100
+	PageSize int
+}
+
+// query encodes the options as query parameters
+func (o ListOptions) query() url.Values {
+	query := url.Values{}
+	if o.Queue != "" {
+		query.Set("queue", o.Queue)
+	}
+	if o.BatchID != 0 {
+		query.Set("batch_id", strconv.FormatInt(o.BatchID, 10))
+	}
+	if o.Scheduled {
+		query.Set("scheduled", "true")
+	}
+	if o.Priority != nil {
+		query.Set("priority", strconv.Itoa(*o.Priority))
+	}
+	if o.MinPriority != nil {
+		query.Set("min_priority", strconv.Itoa(*o.MinPriority))
+	}
+	if o.Sort != "" {
+		query.Set("sort", o.Sort)
+	}
+	return query
+}
+
+// ListTasks iterates over the tasks matching opts, fetching a page at a time:
+//
+//	it := c.ListTasks(ctx, client.ListOptions{Queue: "emails"})
+//	for it.Next() {
+//		task := it.Task()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+func (c *Client) ListTasks(ctx context.Context, opts ListOptions) *TaskIterator {
+	size := opts.PageSize
+	if size <= 0 {
+		size = 100
+	}
+
+	query := opts.query()
+	query.Set("size", strconv.Itoa(size))
+	return &TaskIterator{client: c, ctx: ctx, query: query, size: size}
+}
+
+// TaskIterator pages through a task list. Tasks created while iterating may
+// shift pages, so a task can be seen twice or missed.
+type TaskIterator struct {
+	client *Client
+	ctx    context.Context
+	query  url.Values
+	size   int
+	page   int
+	tasks  []Task
+	index  int
+	done   bool
+	err    error
+}
+
+// Next advances to the next task, fetching the next page when needed. It
+// returns false once the tasks are exhausted or a request fails.
+func (it *TaskIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.index+1 < len(it.tasks) {
+		it.index++
+		return true
+	}
+	if it.done {
+		return false
+	}
+
+	it.page++
+	it.query.Set("page", strconv.Itoa(it.page))
+
+	var tasks []Task
+	if _, err := it.client.do(it.ctx, http.MethodGet, "/tasks?"+it.query.Encode(), nil, nil, &tasks); err != nil {
+		it.err = err
+		return false
+	}
+
+	it.tasks, it.index = tasks, 0
+	it.done = len(tasks) < it.size
+	return len(tasks) > 0
+}
+
+// Task returns the current task
+func (it *TaskIterator) Task() *Task {
+	return &it.tasks[it.index]
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *TaskIterator) Err() error {
+	return it.err
+}
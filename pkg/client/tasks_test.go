@@ -0,0 +1,259 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/queuet/internal/handlers"
+	"github.com/queuet/internal/routes"
+	"github.com/queuet/internal/store"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// taskRowColumns are the column names returned by task queries
+var taskRowColumns = []string{"id", "title", "description", "status", "queue", "priority", "unique_key", "batch_id", "lease_owner", "lease_expires_at", "attempts", "max_attempts", "last_error", "payload", "result", "run_at", "progress", "progress_message", "cancel_requested_at", "timeout_seconds", "deadline_at", "created_at", "updated_at"}
+
+// cacheMock is a Redis client that never has a cached task
+type cacheMock struct{}
+
+func (cacheMock) Get(ctx context.Context, key string) *redis.StringCmd {
+	cmd := redis.NewStringCmd(ctx)
+	cmd.SetErr(redis.Nil)
+	return cmd
+}
+
+func (cacheMock) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	return redis.NewStatusCmd(ctx)
+}
+
+func (cacheMock) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	return redis.NewIntCmd(ctx)
+}
+
+// setupTestClient returns a client of the real routes backed by a mock
+// database
+func setupTestClient(t *testing.T) (*Client, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	r := chi.NewRouter()
+	taskHandler := handlers.NewTaskHandler(db, cacheMock{}, nil, nil, handlers.NewConfig())
+	routes.SetupRoutes(r, taskHandler, handlers.NewScheduleHandler(db, handlers.NewConfig()), handlers.NewQueueHandler(db), handlers.NewWorkerHandler(db))
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return New(Config{URL: server.URL}), mock
+}
+
+// addTaskRow appends a pending task
+func addTaskRow(rows *sqlmock.Rows, id int64, title string) *sqlmock.Rows {
+	return rows.AddRow(id, title, "", "pending", "default", 0, nil, nil, nil, nil, 0, 3, nil, nil, nil, time.Now(), nil, nil, nil, nil, nil, time.Now(), time.Now())
+}
+
+func TestClient_CreateTask(t *testing.T) {
+	c, mock := setupTestClient(t)
+
+	mock.ExpectQuery(`INSERT INTO tasks`).
+		WithArgs("Send Email", "", sql.NullString{}, "pending", "default", 0, sql.NullInt64{}, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{}, sql.NullInt64{}).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+
+	id, err := c.CreateTask(context.Background(), CreateTaskRequest{Title: "Send Email"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), id)
+
+	_, err = c.CreateTask(context.Background(), CreateTaskRequest{})
+	assert.ErrorIs(t, err, ErrBadRequest)
+	assert.EqualError(t, err, "queuet: 400 Title is required")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClient_GetTask(t *testing.T) {
+	c, mock := setupTestClient(t)
+	selectQuery := `SELECT ` + regexp.QuoteMeta(store.TaskColumns) + ` FROM tasks WHERE id = \$1`
+
+	mock.ExpectQuery(selectQuery).
+		WithArgs(1).
+		WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Send Email"))
+	mock.ExpectQuery(selectQuery).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(taskRowColumns))
+
+	task, err := c.GetTask(context.Background(), 1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Send Email", task.Title)
+	}
+
+	_, err = c.GetTask(context.Background(), 2)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClient_UpdateTask(t *testing.T) {
+	c, mock := setupTestClient(t)
+	priority := 5
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE tasks SET title = COALESCE\(\$1, title\)`).
+		WithArgs(sql.NullString{String: "Resend Email", Valid: true}, sql.NullString{}, sql.NullString{}, sql.NullString{}, sql.NullInt64{Int64: 5, Valid: true}, sqlmock.AnyArg(), 1).
+		WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "Resend Email"))
+	mock.ExpectCommit()
+
+	task, err := c.UpdateTask(context.Background(), 1, UpdateTaskRequest{Title: "Resend Email", Priority: &priority})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Resend Email", task.Title)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClient_DeleteTask(t *testing.T) {
+	c, mock := setupTestClient(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT (.+) FROM tasks WHERE id = \$1 FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "First"))
+	mock.ExpectQuery(`SELECT id FROM tasks WHERE status = \$1 AND id IN`).
+		WithArgs("blocked", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectExec(`DELETE FROM tasks WHERE id = \$1`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT (.+) FROM tasks WHERE id = \$1 FOR UPDATE`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(taskRowColumns))
+	mock.ExpectRollback()
+
+	assert.NoError(t, c.DeleteTask(context.Background(), 1))
+	assert.ErrorIs(t, c.DeleteTask(context.Background(), 2), ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClient_ListTasks(t *testing.T) {
+	listQuery := `SELECT ` + regexp.QuoteMeta(store.TaskColumns) + ` FROM tasks WHERE queue = \$1 ORDER BY created_at DESC LIMIT \$2 OFFSET \$3`
+
+	t.Run("Pages through every task", func(t *testing.T) {
+		c, mock := setupTestClient(t)
+
+		mock.ExpectQuery(listQuery).
+			WithArgs("emails", 2, 0).
+			WillReturnRows(addTaskRow(addTaskRow(sqlmock.NewRows(taskRowColumns), 3, "Third"), 2, "Second"))
+		mock.ExpectQuery(listQuery).
+			WithArgs("emails", 2, 2).
+			WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "First"))
+
+		it := c.ListTasks(context.Background(), ListOptions{Queue: "emails", PageSize: 2})
+		var ids []int64
+		for it.Next() {
+			ids = append(ids, it.Task().ID)
+		}
+
+		assert.NoError(t, it.Err())
+		assert.Equal(t, []int64{3, 2, 1}, ids)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Full last page", func(t *testing.T) {
+		c, mock := setupTestClient(t)
+
+		mock.ExpectQuery(listQuery).
+			WithArgs("emails", 1, 0).
+			WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "First"))
+		mock.ExpectQuery(listQuery).
+			WithArgs("emails", 1, 1).
+			WillReturnRows(sqlmock.NewRows(taskRowColumns))
+
+		it := c.ListTasks(context.Background(), ListOptions{Queue: "emails", PageSize: 1})
+		count := 0
+		for it.Next() {
+			count++
+		}
+
+		assert.NoError(t, it.Err())
+		assert.Equal(t, 1, count)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid filter", func(t *testing.T) {
+		c, _ := setupTestClient(t)
+
+		it := c.ListTasks(context.Background(), ListOptions{Sort: "title"})
+
+		assert.False(t, it.Next())
+		assert.ErrorIs(t, it.Err(), ErrBadRequest)
+	})
+}
+
+func TestClient_ClaimTask(t *testing.T) {
+	c, mock := setupTestClient(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT name FROM queues WHERE max_in_flight IS NOT NULL`).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	mock.ExpectQuery(`UPDATE tasks SET status = \$1, lease_owner = \$2`).
+		WillReturnRows(sqlmock.NewRows(taskRowColumns))
+	mock.ExpectRollback()
+
+	task, wait, err := c.ClaimTask(context.Background(), ClaimTaskRequest{WorkerID: "worker-1"})
+
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+	assert.Zero(t, wait)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClient_ClaimTasks(t *testing.T) {
+	c, mock := setupTestClient(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT name FROM queues WHERE max_in_flight IS NOT NULL`).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	mock.ExpectQuery(`UPDATE tasks SET status = \$1, lease_owner = \$2`).
+		WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "pending", sqlmock.AnyArg(), sqlmock.AnyArg(), 2, sqlmock.AnyArg()).
+		WillReturnRows(addTaskRow(addTaskRow(sqlmock.NewRows(taskRowColumns), 1, "First"), 2, "Second"))
+	mock.ExpectExec(`UPDATE workers SET status = \$1`).
+		WithArgs("alive", sqlmock.AnyArg(), "worker-1", "dead").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	tasks, wait, err := c.ClaimTasks(context.Background(), ClaimTaskRequest{WorkerID: "worker-1"}, 2, 0)
+
+	assert.NoError(t, err)
+	assert.Len(t, tasks, 2)
+	assert.Zero(t, wait)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClient_CompleteTasks(t *testing.T) {
+	c, mock := setupTestClient(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE tasks SET status = \$1, result = completed.task_result::jsonb`).
+		WillReturnRows(sqlmock.NewRows(taskRowColumns))
+	mock.ExpectQuery(`SELECT id FROM tasks WHERE id = ANY\(\$1\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+
+	response, err := c.CompleteTasks(context.Background(), CompleteTaskBatchRequest{
+		WorkerID: "worker-1",
+		Tasks:    []CompletedTask{{ID: 1}},
+	})
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, response.Failed)
+		assert.Equal(t, []TaskBatchResult{{Status: http.StatusNotFound, ID: 1, Error: "Task not found"}}, response.Results)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
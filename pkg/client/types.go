@@ -0,0 +1,46 @@
+package client
+
+import "github.com/queuet/internal/models"
+
+// Request and response types of the tasks API. They are the server's own
+// types, aliased here so that code outside this module can use them.
+type (
+	Task                     = models.Task
+	CreateTaskRequest        = models.CreateTaskRequest
+	UpdateTaskRequest        = models.UpdateTaskRequest
+	ClaimTaskRequest         = models.ClaimTaskRequest
+	HeartbeatRequest         = models.HeartbeatRequest
+	CompleteTaskRequest      = models.CompleteTaskRequest
+	CompleteTaskBatchRequest = models.CompleteTaskBatchRequest
+	CompletedTask            = models.CompletedTask
+	FailTaskRequest          = models.FailTaskRequest
+	TaskBatchResult          = models.TaskBatchResult
+	TaskBatchResponse        = models.TaskBatchResponse
+	Worker                   = models.Worker
+	LeasedTask               = models.LeasedTask
+	RegisterWorkerRequest    = models.RegisterWorkerRequest
+)
+
+// Task statuses
+const (
+	TaskStatusPending    = models.TaskStatusPending
+	TaskStatusInProgress = models.TaskStatusInProgress
+	TaskStatusCompleted  = models.TaskStatusCompleted
+	TaskStatusFailed     = models.TaskStatusFailed
+	TaskStatusDead       = models.TaskStatusDead
+	TaskStatusBlocked    = models.TaskStatusBlocked
+	TaskStatusCancelled  = models.TaskStatusCancelled
+)
+
+// Unique key policies of CreateTaskRequest
+const (
+	UniquePolicyReject         = models.UniquePolicyReject
+	UniquePolicyReturnExisting = models.UniquePolicyReturnExisting
+	UniquePolicyReplace        = models.UniquePolicyReplace
+)
+
+// Worker statuses, e.g. for ListWorkers
+const (
+	WorkerStatusAlive = models.WorkerStatusAlive
+	WorkerStatusDead  = models.WorkerStatusDead
+)
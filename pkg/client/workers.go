@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// RegisterWorker registers a worker as alive, replacing any earlier
+// registration under the same ID
+func (c *Client) RegisterWorker(ctx context.Context, req RegisterWorkerRequest) (*Worker, error) {
+	var worker Worker
+	if _, err := c.do(ctx, http.MethodPost, "/workers", nil, req, &worker); err != nil {
+		return nil, err
+	}
+	return &worker, nil
+}
+
+// WorkerHeartbeat records that a registered worker is alive. It fails with
+// ErrNotFound when the worker is not registered.
+func (c *Client) WorkerHeartbeat(ctx context.Context, id string) (*Worker, error) {
+	var worker Worker
+	if _, err := c.do(ctx, http.MethodPost, "/workers/"+url.PathEscape(id)+"/heartbeat", nil, nil, &worker); err != nil {
+		return nil, err
+	}
+	return &worker, nil
+}
+
+// GetWorker gets a registered worker along with the tasks it is running
+func (c *Client) GetWorker(ctx context.Context, id string) (*Worker, error) {
+	var worker Worker
+	if _, err := c.do(ctx, http.MethodGet, "/workers/"+url.PathEscape(id), nil, nil, &worker); err != nil {
+		return nil, err
+	}
+	return &worker, nil
+}
+
+// ListWorkers lists registered workers, only those with status unless it is
+// empty
+func (c *Client) ListWorkers(ctx context.Context, status string) ([]Worker, error) {
+	path := "/workers"
+	if status != "" {
+		path += "?" + url.Values{"status": {status}}.Encode()
+	}
+
+	var workers []Worker
+	if _, err := c.do(ctx, http.MethodGet, path, nil, nil, &workers); err != nil {
+		return nil, err
+	}
+	return workers, nil
+}
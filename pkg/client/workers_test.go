@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// workerRowColumns are the column names returned by worker queries
+var workerRowColumns = []string{"id", "hostname", "queues", "version", "concurrency", "status", "last_seen_at", "created_at", "updated_at"}
+
+// leasedTaskQuery selects the tasks leased to workers
+const leasedTaskQuery = `SELECT lease_owner, id, title, queue, progress, lease_expires_at FROM tasks WHERE status = \$1 AND lease_owner = ANY\(\$2\)`
+
+// addWorkerRow appends a live worker
+func addWorkerRow(rows *sqlmock.Rows, id string) *sqlmock.Rows {
+	return rows.AddRow(id, "host-1", "{emails}", "1.0.0", 2, "alive", time.Now(), time.Now(), time.Now())
+}
+
+func TestClient_RegisterWorker(t *testing.T) {
+	c, mock := setupTestClient(t)
+
+	mock.ExpectQuery(`INSERT INTO workers`).
+		WithArgs("worker-1", "host-1", pq.Array([]string{"emails"}), "1.0.0", 2, "alive", sqlmock.AnyArg()).
+		WillReturnRows(addWorkerRow(sqlmock.NewRows(workerRowColumns), "worker-1"))
+	mock.ExpectQuery(leasedTaskQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"lease_owner", "id", "title", "queue", "progress", "lease_expires_at"}))
+
+	worker, err := c.RegisterWorker(context.Background(), RegisterWorkerRequest{
+		WorkerID:    "worker-1",
+		Hostname:    "host-1",
+		Queues:      []string{"emails"},
+		Version:     "1.0.0",
+		Concurrency: 2,
+	})
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, "alive", worker.Status)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClient_WorkerHeartbeat(t *testing.T) {
+	c, mock := setupTestClient(t)
+
+	mock.ExpectQuery(`UPDATE workers SET status = \$1, last_seen_at = \$2`).
+		WithArgs("alive", sqlmock.AnyArg(), "host/1").
+		WillReturnRows(sqlmock.NewRows(workerRowColumns))
+
+	_, err := c.WorkerHeartbeat(context.Background(), "host/1")
+
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClient_ListWorkers(t *testing.T) {
+	c, mock := setupTestClient(t)
+
+	mock.ExpectQuery(`SELECT (.+) FROM workers`).
+		WithArgs("alive").
+		WillReturnRows(addWorkerRow(sqlmock.NewRows(workerRowColumns), "worker-1"))
+	mock.ExpectQuery(leasedTaskQuery).
+		WithArgs("in_progress", pq.Array([]string{"worker-1"})).
+		WillReturnRows(sqlmock.NewRows([]string{"lease_owner", "id", "title", "queue", "progress", "lease_expires_at"}).
+			AddRow("worker-1", 4, "Send Email", "emails", 50, time.Now()))
+
+	workers, err := c.ListWorkers(context.Background(), "alive")
+
+	if assert.NoError(t, err) && assert.Len(t, workers, 1) && assert.Len(t, workers[0].LeasedTasks, 1) {
+		task := workers[0].LeasedTasks[0]
+		assert.Equal(t, int64(4), task.ID)
+		if assert.NotNil(t, task.Progress) {
+			assert.Equal(t, 50, *task.Progress)
+		}
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
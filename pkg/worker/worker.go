@@ -0,0 +1,406 @@
+// Package worker runs task handlers against the Queuet claim API. A Worker
+// registers itself with the server, claims tasks from the queues it has
+// handlers for, keeps their leases alive with heartbeats while they run, and
+// reports each one as completed or failed.
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/queuet/pkg/client"
+)
+
+// Task is a claimed task, as passed to a Handler
+type Task = client.Task
+
+// Handler runs a claimed task and returns its result, which is stored as
+// JSON when the task is completed. Returning an error fails the attempt, and
+// the task is retried unless the error is wrapped with Permanent. The context
+// is cancelled when the task's cancellation is requested or its lease is
+// lost.
+type Handler func(ctx context.Context, task *Task) (interface{}, error)
+
+// Config configures a Worker. Only URL is required.
+type Config struct {
+	// URL is the base URL of the server, e.g. http://localhost:8080
+	URL string
+	// WorkerID identifies the worker's leases, and defaults to the host
+	// name and process ID
+	WorkerID string
+	// Concurrency is the number of tasks run at once, default 1
+	Concurrency int
+	// Version is reported when the worker registers, e.g. the version of the
+	// service running it
+	Version string
+	// LeaseSeconds is requested on claims and heartbeats; 0 leaves it to
+	// the queue's lease_seconds or the server default
+	LeaseSeconds int
+	// HeartbeatInterval is how often leases are extended, default a third
+	// of LeaseSeconds or 10 seconds
+	HeartbeatInterval time.Duration
+	// LivenessInterval is how often the worker reports that it is alive,
+	// default 10 seconds. It must stay well below the server's worker
+	// timeout, after which the worker is marked dead and its tasks are
+	// released.
+	LivenessInterval time.Duration
+	// ClaimWait is how long each claim waits on the server for a task to be
+	// created, default 30 seconds
+	ClaimWait time.Duration
+	// PollInterval is the least time between claims when no task is
+	// available and the server does not say when to retry, and the delay
+	// after a failed claim, default 1 second
+	PollInterval time.Duration
+	// DrainTimeout bounds how long Run waits for running tasks once its
+	// context is cancelled, after which their contexts are cancelled too.
+	// 0 waits until they finish.
+	DrainTimeout time.Duration
+	// Client sends the requests, default http.DefaultClient
+	Client *http.Client
+}
+
+// Worker claims and runs tasks with the handlers registered for their queues
+type Worker struct {
+	config   Config
+	client   *client.Client
+	handlers map[string]Handler
+}
+
+// permanentError marks a failure that should not be retried
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that the task is failed without being retried
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// New creates a Worker, filling in the defaults of config
+func New(config Config) *Worker {
+	if config.WorkerID == "" {
+		host, _ := os.Hostname()
+		config.WorkerID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = 10 * time.Second
+		if config.LeaseSeconds > 0 {
+			config.HeartbeatInterval = time.Duration(config.LeaseSeconds) * time.Second / 3
+		}
+	}
+	if config.LivenessInterval <= 0 {
+		config.LivenessInterval = 10 * time.Second
+	}
+	if config.ClaimWait <= 0 {
+		config.ClaimWait = 30 * time.Second
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+
+	return &Worker{
+		config:   config,
+		client:   client.New(client.Config{URL: config.URL, HTTPClient: config.Client}),
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Handle registers the handler for tasks on queue. It must not be called
+// while the worker is running.
+func (w *Worker) Handle(queue string, handler Handler) {
+	w.handlers[queue] = handler
+}
+
+// Run registers the worker, then claims and runs tasks until ctx is
+// cancelled, with at most Concurrency tasks running at once. It then stops
+// claiming and waits for running tasks to be reported before returning. The
+// worker reports that it is alive until it returns.
+func (w *Worker) Run(ctx context.Context) error {
+	if len(w.handlers) == 0 {
+		return errors.New("no handlers registered")
+	}
+
+	queues := make([]string, 0, len(w.handlers))
+	for queue := range w.handlers {
+		queues = append(queues, queue)
+	}
+	sort.Strings(queues)
+
+	// A worker that fails to register still runs tasks, and registers again
+	// on its next liveness report
+	if err := w.register(ctx, queues); err != nil {
+		log.Printf("Error registering worker: %v", err)
+	}
+	livenessCtx, stopLiveness := context.WithCancel(context.WithoutCancel(ctx))
+	livenessDone := make(chan struct{})
+	go func() {
+		defer close(livenessDone)
+		w.reportLiveness(livenessCtx, queues)
+	}()
+	defer func() {
+		stopLiveness()
+		<-livenessDone
+	}()
+
+	// Running tasks outlive ctx so that they can drain
+	taskCtx, cancelTasks := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelTasks()
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, w.config.Concurrency)
+	for {
+		select {
+		case <-ctx.Done():
+		case slots <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		claimed := time.Now()
+		task, retryAfter, err := w.client.WaitForTask(ctx, client.ClaimTaskRequest{
+			WorkerID:     w.config.WorkerID,
+			LeaseSeconds: w.config.LeaseSeconds,
+			Queues:       queues,
+		}, w.config.ClaimWait)
+		if err != nil {
+			<-slots
+			if ctx.Err() == nil {
+				log.Printf("Error claiming task: %v", err)
+			}
+			sleep(ctx, w.config.PollInterval)
+			continue
+		}
+		if task == nil {
+			<-slots
+			// A server that did not wait, e.g. while shutting down, is not
+			// polled more often than PollInterval
+			if retryAfter == 0 {
+				retryAfter = w.config.PollInterval - time.Since(claimed)
+			}
+			if retryAfter > 0 {
+				sleep(ctx, retryAfter)
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			w.runTask(taskCtx, task)
+		}()
+	}
+
+	w.drain(&wg, cancelTasks)
+	return nil
+}
+
+// register registers the worker with the queues it claims from
+func (w *Worker) register(ctx context.Context, queues []string) error {
+	host, _ := os.Hostname()
+	_, err := w.client.RegisterWorker(ctx, client.RegisterWorkerRequest{
+		WorkerID:    w.config.WorkerID,
+		Hostname:    host,
+		Queues:      queues,
+		Version:     w.config.Version,
+		Concurrency: w.config.Concurrency,
+	})
+	return err
+}
+
+// reportLiveness sends a worker heartbeat every LivenessInterval until ctx is
+// cancelled, registering again when the server does not know the worker
+func (w *Worker) reportLiveness(ctx context.Context, queues []string) {
+	ticker := time.NewTicker(w.config.LivenessInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		_, err := w.client.WorkerHeartbeat(ctx, w.config.WorkerID)
+		if errors.Is(err, client.ErrNotFound) {
+			err = w.register(ctx, queues)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error reporting worker liveness: %v", err)
+		}
+	}
+}
+
+// drain waits for running tasks, cancelling them after DrainTimeout
+func (w *Worker) drain(wg *sync.WaitGroup, cancelTasks context.CancelFunc) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	if w.config.DrainTimeout > 0 {
+		select {
+		case <-done:
+			return
+		case <-time.After(w.config.DrainTimeout):
+			cancelTasks()
+		}
+	}
+	<-done
+}
+
+// runTask runs the handler of a claimed task while heartbeating its lease,
+// then reports the outcome
+func (w *Worker) runTask(ctx context.Context, task *Task) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	heartbeatDone := make(chan struct{})
+	lost := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		w.heartbeat(ctx, task.ID, cancel, lost)
+	}()
+
+	result, err := w.handle(ctx, task)
+	cancel()
+	<-heartbeatDone
+
+	select {
+	case <-lost:
+		// Someone else may hold the task now, so there is nothing to report
+		log.Printf("Lease on task %d was lost before it finished", task.ID)
+		return
+	default:
+	}
+
+	// Reports are sent even while draining
+	reportCtx := context.WithoutCancel(ctx)
+	if err != nil {
+		if err := w.fail(reportCtx, task.ID, err); err != nil {
+			log.Printf("Error reporting failure of task %d: %v", task.ID, err)
+		}
+		return
+	}
+	if err := w.complete(reportCtx, task.ID, result); err != nil {
+		log.Printf("Error completing task %d: %v", task.ID, err)
+	}
+}
+
+// handle calls the handler of the task's queue, turning a panic into an
+// error
+func (w *Worker) handle(ctx context.Context, task *Task) (result interface{}, err error) {
+	handler, ok := w.handlers[task.Queue]
+	if !ok {
+		return nil, fmt.Errorf("no handler for queue %s", task.Queue)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Handler panicked on task %d: %v", task.ID, r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, task)
+}
+
+// heartbeat extends the lease on a task every HeartbeatInterval until ctx is
+// cancelled. The task's context is cancelled once its cancellation is
+// requested, or once the lease is lost, which also closes lost.
+func (w *Worker) heartbeat(ctx context.Context, taskID int64, cancel context.CancelFunc, lost chan<- struct{}) {
+	ticker := time.NewTicker(w.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		task, err := w.client.Heartbeat(ctx, taskID, client.HeartbeatRequest{
+			WorkerID:     w.config.WorkerID,
+			LeaseSeconds: w.config.LeaseSeconds,
+		})
+		if leaseLost(err) {
+			close(lost)
+			cancel()
+			return
+		} else if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error extending lease on task %d: %v", taskID, err)
+			}
+			continue
+		}
+
+		if task.CancelRequestedAt != nil {
+			cancel()
+			return
+		}
+	}
+}
+
+// complete reports a task as completed with its result
+func (w *Worker) complete(ctx context.Context, taskID int64, result interface{}) error {
+	req := client.CompleteTaskRequest{WorkerID: w.config.WorkerID}
+	if result != nil {
+		encoded, err := json.Marshal(result)
+		if err != nil {
+			return w.fail(ctx, taskID, Permanent(fmt.Errorf("error encoding result: %v", err)))
+		}
+		req.Result = encoded
+	}
+
+	_, err := w.client.CompleteTask(ctx, taskID, req)
+	return err
+}
+
+// fail reports a failed attempt at a task
+func (w *Worker) fail(ctx context.Context, taskID int64, failure error) error {
+	req := client.FailTaskRequest{WorkerID: w.config.WorkerID, Error: failure.Error()}
+	if req.Error == "" {
+		req.Error = "unknown error"
+	}
+
+	var permanent permanentError
+	if errors.As(failure, &permanent) {
+		retry := false
+		req.Retry = &retry
+	}
+
+	_, err := w.client.FailTask(ctx, taskID, req)
+	return err
+}
+
+// leaseLost reports whether err means the task is no longer leased to the
+// worker, because the lease expired or the task was deleted
+func leaseLost(err error) bool {
+	return errors.Is(err, client.ErrNotFound) || errors.Is(err, client.ErrConflict)
+}
+
+// sleep waits for d or until ctx is cancelled
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,325 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/lib/pq"
+	"github.com/queuet/internal/handlers"
+	"github.com/queuet/internal/routes"
+	"github.com/queuet/pkg/client"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// taskRowColumns are the column names returned by task queries
+var taskRowColumns = []string{"id", "title", "description", "status", "queue", "priority", "unique_key", "batch_id", "lease_owner", "lease_expires_at", "attempts", "max_attempts", "last_error", "payload", "result", "run_at", "progress", "progress_message", "cancel_requested_at", "timeout_seconds", "deadline_at", "created_at", "updated_at"}
+
+var (
+	lockQueuesQuery  = `SELECT name FROM queues WHERE max_in_flight IS NOT NULL`
+	claimQuery       = `UPDATE tasks SET status = \$1, lease_owner = \$2`
+	heartbeatQuery   = `UPDATE tasks SET lease_expires_at`
+	completeQuery    = `UPDATE tasks SET status = \$1, result = \$2`
+	lockTaskQuery    = `SELECT (.+) FROM tasks WHERE id = \$1 FOR UPDATE`
+	attemptQuery     = `INSERT INTO task_attempts`
+	failQuery        = `UPDATE tasks SET status = \$1, attempts = \$2, last_error = \$3`
+	dependentsQuery  = `SELECT id FROM tasks WHERE status = \$1 AND id IN`
+	registerQuery    = `INSERT INTO workers`
+	livenessQuery    = `UPDATE workers SET status = \$1, last_seen_at = \$2`
+	reviveQuery      = `UPDATE workers SET (.+) WHERE id = \$3 AND status = \$4`
+	leasedTasksQuery = `SELECT lease_owner, id, title, queue, progress, lease_expires_at FROM tasks`
+)
+
+// workerRowColumns are the column names returned by worker queries
+var workerRowColumns = []string{"id", "hostname", "queues", "version", "concurrency", "status", "last_seen_at", "created_at", "updated_at"}
+
+// cacheMock is a no-op Redis client
+type cacheMock struct{}
+
+func (cacheMock) Get(ctx context.Context, key string) *redis.StringCmd {
+	cmd := redis.NewStringCmd(ctx)
+	cmd.SetErr(redis.Nil)
+	return cmd
+}
+
+func (cacheMock) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	return redis.NewStatusCmd(ctx)
+}
+
+func (cacheMock) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	return redis.NewIntCmd(ctx)
+}
+
+// setupTestServer serves the real routes backed by a mock database
+func setupTestServer(t *testing.T) (*httptest.Server, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	r := chi.NewRouter()
+	taskHandler := handlers.NewTaskHandler(db, cacheMock{}, nil, nil, handlers.NewConfig())
+	routes.SetupRoutes(r, taskHandler, handlers.NewScheduleHandler(db, handlers.NewConfig()), handlers.NewQueueHandler(db), handlers.NewWorkerHandler(db))
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server, mock
+}
+
+// addTaskRow appends task 1 on the emails queue
+func addTaskRow(rows *sqlmock.Rows, status string, cancelRequestedAt interface{}) *sqlmock.Rows {
+	var owner, expires interface{}
+	if status == client.TaskStatusInProgress {
+		owner, expires = "worker-1", time.Now().Add(time.Minute)
+	}
+	return rows.AddRow(1, "Send Email", "", status, "emails", 0, nil, nil, owner, expires, 0, 3, nil, nil, nil, time.Now(), nil, nil, cancelRequestedAt, nil, nil, time.Now(), time.Now())
+}
+
+// expectRegister sets up the registration of worker-1
+func expectRegister(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(registerQuery).
+		WithArgs("worker-1", sqlmock.AnyArg(), pq.Array([]string{"emails"}), "1.0.0", 1, "alive", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(workerRowColumns).
+			AddRow("worker-1", "host-1", "{emails}", "1.0.0", 1, "alive", time.Now(), time.Now(), time.Now()))
+	mock.ExpectQuery(leasedTasksQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"lease_owner", "id", "title", "queue", "progress", "lease_expires_at"}))
+}
+
+// expectClaim sets up a claim of task 1 from the emails queue
+func expectClaim(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockQueuesQuery).
+		WithArgs(pq.Array([]string{"emails"}), "pending", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	mock.ExpectQuery(claimQuery).
+		WithArgs("in_progress", "worker-1", sqlmock.AnyArg(), sql.NullInt64{}, sqlmock.AnyArg(), sqlmock.AnyArg(), "pending", pq.Array([]string{"emails"}), pq.Array([]string(nil)), 1, pq.Array([]string(nil))).
+		WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), "in_progress", nil))
+	mock.ExpectExec(reviveQuery).
+		WithArgs("alive", sqlmock.AnyArg(), "worker-1", "dead").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+}
+
+// expectFail sets up the failure of task 1, ending in status
+func expectFail(mock sqlmock.Sqlmock, message, status string, cancelRequestedAt interface{}) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockTaskQuery).
+		WithArgs(1).
+		WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), "in_progress", cancelRequestedAt))
+	mock.ExpectExec(attemptQuery).
+		WithArgs(1, 1, sql.NullString{String: "worker-1", Valid: true}, message, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(failQuery).
+		WithArgs(status, 1, message, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), status, cancelRequestedAt))
+	if status != client.TaskStatusPending {
+		mock.ExpectQuery(dependentsQuery).
+			WithArgs("blocked", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	}
+	mock.ExpectCommit()
+}
+
+func TestWorker_Run(t *testing.T) {
+	tests := []struct {
+		name      string
+		heartbeat time.Duration
+		handler   func(stop context.CancelFunc) Handler
+		mockDB    func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name: "Task completed with its result",
+			handler: func(stop context.CancelFunc) Handler {
+				return func(ctx context.Context, task *Task) (interface{}, error) {
+					// The task is still reported once the worker stops
+					stop()
+					return map[string]bool{"sent": true}, nil
+				}
+			},
+			mockDB: func(mock sqlmock.Sqlmock) {
+				expectClaim(mock)
+				mock.ExpectBegin()
+				mock.ExpectQuery(completeQuery).
+					WithArgs("completed", sql.NullString{String: `{"sent":true}`, Valid: true}, sqlmock.AnyArg(), 1, "in_progress", "worker-1").
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), "completed", nil))
+				mock.ExpectQuery(dependentsQuery).
+					WithArgs("blocked", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "Error is retried",
+			handler: func(stop context.CancelFunc) Handler {
+				return func(ctx context.Context, task *Task) (interface{}, error) {
+					stop()
+					return nil, errors.New("mail server unavailable")
+				}
+			},
+			mockDB: func(mock sqlmock.Sqlmock) {
+				expectClaim(mock)
+				expectFail(mock, "mail server unavailable", "pending", nil)
+			},
+		},
+		{
+			name: "Permanent error is not retried",
+			handler: func(stop context.CancelFunc) Handler {
+				return func(ctx context.Context, task *Task) (interface{}, error) {
+					stop()
+					return nil, Permanent(errors.New("invalid address"))
+				}
+			},
+			mockDB: func(mock sqlmock.Sqlmock) {
+				expectClaim(mock)
+				expectFail(mock, "invalid address", "failed", nil)
+			},
+		},
+		{
+			name: "Panic is reported as a failure",
+			handler: func(stop context.CancelFunc) Handler {
+				return func(ctx context.Context, task *Task) (interface{}, error) {
+					stop()
+					panic("nil template")
+				}
+			},
+			mockDB: func(mock sqlmock.Sqlmock) {
+				expectClaim(mock)
+				expectFail(mock, "panic: nil template", "pending", nil)
+			},
+		},
+		{
+			name:      "Cancellation requested on heartbeat",
+			heartbeat: 10 * time.Millisecond,
+			handler: func(stop context.CancelFunc) Handler {
+				return func(ctx context.Context, task *Task) (interface{}, error) {
+					<-ctx.Done()
+					stop()
+					return nil, ctx.Err()
+				}
+			},
+			mockDB: func(mock sqlmock.Sqlmock) {
+				requested := time.Now()
+				expectClaim(mock)
+				mock.ExpectQuery(heartbeatQuery).
+					WillReturnRows(addTaskRow(sqlmock.NewRows(taskRowColumns), "in_progress", requested))
+				expectFail(mock, "context canceled", "cancelled", requested)
+			},
+		},
+		{
+			name:      "Lost lease is not reported",
+			heartbeat: 10 * time.Millisecond,
+			handler: func(stop context.CancelFunc) Handler {
+				return func(ctx context.Context, task *Task) (interface{}, error) {
+					<-ctx.Done()
+					stop()
+					return nil, ctx.Err()
+				}
+			},
+			mockDB: func(mock sqlmock.Sqlmock) {
+				expectClaim(mock)
+				mock.ExpectQuery(heartbeatQuery).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM tasks WHERE id = \$1\)`).
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, mock := setupTestServer(t)
+			expectRegister(mock)
+			tt.mockDB(mock)
+
+			ctx, stop := context.WithCancel(context.Background())
+			defer stop()
+
+			// Heartbeats are only sent when a test expects them
+			heartbeat := tt.heartbeat
+			if heartbeat == 0 {
+				heartbeat = time.Hour
+			}
+
+			w := New(Config{
+				URL:               server.URL,
+				WorkerID:          "worker-1",
+				Version:           "1.0.0",
+				HeartbeatInterval: heartbeat,
+				LivenessInterval:  time.Hour,
+			})
+			w.Handle("emails", tt.handler(stop))
+
+			done := make(chan error, 1)
+			go func() { done <- w.Run(ctx) }()
+
+			select {
+			case err := <-done:
+				assert.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("Worker did not stop")
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestWorker_Liveness(t *testing.T) {
+	server, mock := setupTestServer(t)
+	// Claims fail without expectations, so only the order of these matters
+	expectRegister(mock)
+	mock.ExpectQuery(livenessQuery).
+		WithArgs("alive", sqlmock.AnyArg(), "worker-1").
+		WillReturnRows(sqlmock.NewRows(workerRowColumns))
+	// Unknown to the server, e.g. after being purged, so it registers again
+	expectRegister(mock)
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	w := New(Config{
+		URL:              server.URL,
+		WorkerID:         "worker-1",
+		Version:          "1.0.0",
+		LivenessInterval: 10 * time.Millisecond,
+		PollInterval:     time.Hour,
+	})
+	w.Handle("emails", func(ctx context.Context, task *Task) (interface{}, error) {
+		return nil, nil
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for mock.ExpectationsWereMet() != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+
+	assert.NoError(t, <-done)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWorker_RunWithoutHandlers(t *testing.T) {
+	w := New(Config{URL: "http://localhost:8080"})
+	assert.Error(t, w.Run(context.Background()))
+}
+
+func TestNew(t *testing.T) {
+	w := New(Config{URL: "http://localhost:8080", LeaseSeconds: 60})
+
+	assert.NotEmpty(t, w.config.WorkerID)
+	assert.Equal(t, 1, w.config.Concurrency)
+	assert.Equal(t, 20*time.Second, w.config.HeartbeatInterval)
+	assert.Equal(t, 10*time.Second, w.config.LivenessInterval)
+	assert.Equal(t, 30*time.Second, w.config.ClaimWait)
+	assert.Equal(t, time.Second, w.config.PollInterval)
+}
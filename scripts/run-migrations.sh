@@ -28,4 +28,7 @@ fi
 
 # Run the migrations
 echo "Running migrations..."
-PGPASSWORD=$DB_PASSWORD psql -h "$DB_HOST" -U "$DB_USER" -d "$DB_NAME" -f /app/migrations/001_create_tasks_table.sql 
\ No newline at end of file
+for migration in /app/migrations/*.sql; do
+    echo "Applying $(basename "$migration")..."
+    PGPASSWORD=$DB_PASSWORD psql -v ON_ERROR_STOP=1 -h "$DB_HOST" -U "$DB_USER" -d "$DB_NAME" -f "$migration"
+done 
\ No newline at end of file
@@ -2,19 +2,24 @@ package e2e
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/queuet/internal/cache"
 	"github.com/queuet/internal/database"
 	"github.com/queuet/internal/handlers"
 	"github.com/queuet/internal/models"
+	"github.com/queuet/internal/notify"
+	"github.com/queuet/internal/reaper"
 	"github.com/queuet/internal/routes"
+	"github.com/queuet/pkg/client"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -26,6 +31,8 @@ type E2ETestSuite struct {
 	server      *httptest.Server
 	db          *sql.DB
 	redisClient *redis.Client
+	client      *client.Client
+	stopListen  context.CancelFunc
 }
 
 func TestE2ESuite(t *testing.T) {
@@ -55,17 +62,27 @@ func (s *E2ETestSuite) SetupSuite() {
 		s.T().Fatalf("Failed to connect to Redis: %v", err)
 	}
 
+	// Relay task notifications to waiting claims
+	notifier := notify.NewNotifier()
+	var listenCtx context.Context
+	listenCtx, s.stopListen = context.WithCancel(context.Background())
+	go notifier.Listen(listenCtx, dbConfig.DSN())
+
 	// Initialize handler with real dependencies
-	taskHandler := handlers.NewTaskHandler(s.db, s.redisClient)
+	taskHandler := handlers.NewTaskHandler(s.db, s.redisClient, cache.NewRateLimiter(s.redisClient), notifier, handlers.NewConfig())
 
 	// Setup routes with the configured handler
-	routes.SetupRoutes(s.router, taskHandler)
+	routes.SetupRoutes(s.router, taskHandler, handlers.NewScheduleHandler(s.db, handlers.NewConfig()), handlers.NewQueueHandler(s.db), handlers.NewWorkerHandler(s.db))
 
 	// Create test server
 	s.server = httptest.NewServer(s.router)
+	s.client = client.New(client.Config{URL: s.server.URL})
 }
 
 func (s *E2ETestSuite) TearDownSuite() {
+	if s.stopListen != nil {
+		s.stopListen()
+	}
 	if s.db != nil {
 		s.db.Close()
 	}
@@ -79,126 +96,801 @@ func (s *E2ETestSuite) TearDownSuite() {
 
 func (s *E2ETestSuite) TestCompleteTaskFlow() {
 	t := s.T()
-	baseURL := s.server.URL
+	ctx := context.Background()
 
 	// 1. Create a new task
 	createPayload := models.CreateTaskRequest{
 		Title:       "Test E2E Task",
 		Description: "This is an E2E test task",
 	}
-	createBody, _ := json.Marshal(createPayload)
-
-	createResp, err := http.Post(
-		fmt.Sprintf("%s/api/v1/tasks", baseURL),
-		"application/json",
-		bytes.NewBuffer(createBody),
-	)
-	assert.NoError(t, err)
-	assert.Equal(t, http.StatusCreated, createResp.StatusCode)
-
-	// Get the task ID from the response
-	var createResult struct {
-		ID int64 `json:"id"`
-	}
-	err = json.NewDecoder(createResp.Body).Decode(&createResult)
-	assert.NoError(t, err)
-	createResp.Body.Close()
+	taskID, err := s.client.CreateTask(ctx, createPayload)
+	s.Require().NoError(err)
 
 	// 2. Get the created task
-	getResp, err := http.Get(fmt.Sprintf("%s/api/v1/tasks/%d", baseURL, createResult.ID))
-	assert.NoError(t, err)
-	assert.Equal(t, http.StatusOK, getResp.StatusCode)
-
-	var getResult models.Task
-	err = json.NewDecoder(getResp.Body).Decode(&getResult)
-	assert.NoError(t, err)
-	assert.Equal(t, createPayload.Title, getResult.Title)
-	assert.Equal(t, createPayload.Description, getResult.Description)
-	getResp.Body.Close()
+	task, err := s.client.GetTask(ctx, taskID)
+	s.Require().NoError(err)
+	assert.Equal(t, createPayload.Title, task.Title)
+	assert.Equal(t, createPayload.Description, task.Description)
 
 	// 3. Update the task
 	updatePayload := models.UpdateTaskRequest{
 		Title:  "Updated E2E Task",
 		Status: "completed",
 	}
-	updateBody, _ := json.Marshal(updatePayload)
-
-	req, _ := http.NewRequest(
-		http.MethodPut,
-		fmt.Sprintf("%s/api/v1/tasks/%d", baseURL, createResult.ID),
-		bytes.NewBuffer(updateBody),
-	)
-	req.Header.Set("Content-Type", "application/json")
-
-	updateResp, err := http.DefaultClient.Do(req)
-	assert.NoError(t, err)
-	assert.Equal(t, http.StatusOK, updateResp.StatusCode)
-	updateResp.Body.Close()
+	_, err = s.client.UpdateTask(ctx, taskID, updatePayload)
+	s.Require().NoError(err)
 
 	// 4. Verify the update
-	getResp, err = http.Get(fmt.Sprintf("%s/api/v1/tasks/%d", baseURL, createResult.ID))
-	assert.NoError(t, err)
-	assert.Equal(t, http.StatusOK, getResp.StatusCode)
-
-	err = json.NewDecoder(getResp.Body).Decode(&getResult)
-	assert.NoError(t, err)
-	assert.Equal(t, updatePayload.Title, getResult.Title)
-	assert.Equal(t, updatePayload.Status, getResult.Status)
-	getResp.Body.Close()
+	task, err = s.client.GetTask(ctx, taskID)
+	s.Require().NoError(err)
+	assert.Equal(t, updatePayload.Title, task.Title)
+	assert.Equal(t, updatePayload.Status, task.Status)
 
 	// 5. List all tasks
-	listResp, err := http.Get(fmt.Sprintf("%s/api/v1/tasks", baseURL))
-	assert.NoError(t, err)
-	assert.Equal(t, http.StatusOK, listResp.StatusCode)
-
-	var listResult []models.Task
-	err = json.NewDecoder(listResp.Body).Decode(&listResult)
-	assert.NoError(t, err)
-	assert.NotEmpty(t, listResult)
-	listResp.Body.Close()
+	it := s.client.ListTasks(ctx, client.ListOptions{})
+	assert.True(t, it.Next())
+	assert.NoError(t, it.Err())
 
 	// 6. Delete the task
-	req, _ = http.NewRequest(
-		http.MethodDelete,
-		fmt.Sprintf("%s/api/v1/tasks/%d", baseURL, createResult.ID),
-		nil,
-	)
-
-	deleteResp, err := http.DefaultClient.Do(req)
-	assert.NoError(t, err)
-	assert.Equal(t, http.StatusNoContent, deleteResp.StatusCode)
-	deleteResp.Body.Close()
+	assert.NoError(t, s.client.DeleteTask(ctx, taskID))
 
 	// 7. Verify deletion
-	getResp, err = http.Get(fmt.Sprintf("%s/api/v1/tasks/%d", baseURL, createResult.ID))
-	assert.NoError(t, err)
-	assert.Equal(t, http.StatusNotFound, getResp.StatusCode)
-	getResp.Body.Close()
+	_, err = s.client.GetTask(ctx, taskID)
+	assert.ErrorIs(t, err, client.ErrNotFound)
 }
 
 func (s *E2ETestSuite) TestTaskValidation() {
 	t := s.T()
-	baseURL := s.server.URL
 
 	// Test creating task with empty title
-	createPayload := models.CreateTaskRequest{
+	_, err := s.client.CreateTask(context.Background(), models.CreateTaskRequest{
 		Title:       "",
 		Description: "This should fail validation",
+	})
+	assert.ErrorIs(t, err, client.ErrBadRequest)
+
+	// Test invalid task ID
+	getResp, err := http.Get(fmt.Sprintf("%s/api/v1/tasks/invalid", s.server.URL))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, getResp.StatusCode)
+	getResp.Body.Close()
+}
+
+// createTask creates a task through the API and returns its ID
+func (s *E2ETestSuite) createTask(req models.CreateTaskRequest) int64 {
+	taskID, err := s.client.CreateTask(context.Background(), req)
+	s.Require().NoError(err)
+	return taskID
+}
+
+// claimTask claims tasks as workerID until taskID is handed out. Older
+// pending tasks are claimed first, so other tasks may be leased on the way.
+func (s *E2ETestSuite) claimTask(workerID string, taskID int64) models.Task {
+	for {
+		claimed, _, err := s.client.ClaimTask(context.Background(), models.ClaimTaskRequest{WorkerID: workerID, LeaseSeconds: 60})
+		s.Require().NoError(err)
+		if claimed == nil {
+			s.T().Fatalf("Task %d was never claimed", taskID)
+		}
+
+		if claimed.ID == taskID {
+			return *claimed
+		}
 	}
-	createBody, _ := json.Marshal(createPayload)
+}
 
-	createResp, err := http.Post(
-		fmt.Sprintf("%s/api/v1/tasks", baseURL),
+// postTaskAction sends a JSON body to one of the task action endpoints
+func (s *E2ETestSuite) postTaskAction(taskID int64, action string, body interface{}) (*http.Response, models.Task) {
+	payload, _ := json.Marshal(body)
+	resp, err := http.Post(
+		fmt.Sprintf("%s/api/v1/tasks/%d/%s", s.server.URL, taskID, action),
 		"application/json",
-		bytes.NewBuffer(createBody),
+		bytes.NewBuffer(payload),
 	)
-	assert.NoError(t, err)
-	assert.Equal(t, http.StatusBadRequest, createResp.StatusCode)
-	createResp.Body.Close()
+	s.Require().NoError(err)
+	defer resp.Body.Close()
 
-	// Test invalid task ID
-	getResp, err := http.Get(fmt.Sprintf("%s/api/v1/tasks/invalid", baseURL))
-	assert.NoError(t, err)
-	assert.Equal(t, http.StatusBadRequest, getResp.StatusCode)
+	var task models.Task
+	if resp.StatusCode == http.StatusOK {
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&task))
+	}
+	return resp, task
+}
+
+func (s *E2ETestSuite) TestClaimTask() {
+	t := s.T()
+
+	taskID := s.createTask(models.CreateTaskRequest{Title: "Claimable E2E Task"})
+	claimed := s.claimTask("e2e-worker", taskID)
+
+	assert.Equal(t, models.TaskStatusInProgress, claimed.Status)
+	if assert.NotNil(t, claimed.LeaseOwner) {
+		assert.Equal(t, "e2e-worker", *claimed.LeaseOwner)
+	}
+	assert.NotNil(t, claimed.LeaseExpiresAt)
+}
+
+func (s *E2ETestSuite) TestClaimTaskWait() {
+	t := s.T()
+	ctx := context.Background()
+
+	// Give the listener time to start before anything is notified
+	time.Sleep(500 * time.Millisecond)
+
+	queue := fmt.Sprintf("e2e-wait-%d", time.Now().UnixNano())
+	req := models.ClaimTaskRequest{WorkerID: "e2e-worker", Queues: []string{queue}}
+
+	type claim struct {
+		task *models.Task
+		err  error
+	}
+	claimed := make(chan claim, 1)
+	go func() {
+		task, _, err := s.client.WaitForTask(ctx, req, 10*time.Second)
+		claimed <- claim{task: task, err: err}
+	}()
+
+	// The claim is woken by the task's notification well before the
+	// periodic re-check
+	time.Sleep(200 * time.Millisecond)
+	taskID := s.createTask(models.CreateTaskRequest{Title: "Awaited E2E Task", Queue: queue})
+
+	select {
+	case result := <-claimed:
+		s.Require().NoError(result.err)
+		if assert.NotNil(t, result.task) {
+			assert.Equal(t, taskID, result.task.ID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Waiting claim was not woken by the new task")
+	}
+
+	// With nothing to claim the wait runs out
+	start := time.Now()
+	task, _, err := s.client.WaitForTask(ctx, req, time.Second)
+	s.Require().NoError(err)
+	assert.Nil(t, task)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+}
+
+func (s *E2ETestSuite) TestClaimTaskWaitReleased() {
+	t := s.T()
+	ctx := context.Background()
+
+	// Give the listener time to start before anything is notified
+	time.Sleep(500 * time.Millisecond)
+
+	queue := fmt.Sprintf("e2e-released-%d", time.Now().UnixNano())
+	parentID := s.createTask(models.CreateTaskRequest{Title: "Parent E2E Task"})
+	childID := s.createTask(models.CreateTaskRequest{Title: "Child E2E Task", Queue: queue, DependsOn: []int64{parentID}})
+
+	claimed := make(chan *models.Task, 1)
+	go func() {
+		task, _, err := s.client.WaitForTask(ctx, models.ClaimTaskRequest{WorkerID: "e2e-worker", Queues: []string{queue}}, 10*time.Second)
+		assert.NoError(t, err)
+		claimed <- task
+	}()
+
+	// Releasing the child wakes the claim as creating it would have
+	time.Sleep(200 * time.Millisecond)
+	s.claimTask("e2e-worker", parentID)
+	resp, _ := s.postTaskAction(parentID, "complete", models.CompleteTaskRequest{WorkerID: "e2e-worker"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	select {
+	case task := <-claimed:
+		if assert.NotNil(t, task) {
+			assert.Equal(t, childID, task.ID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Waiting claim was not woken by the released task")
+	}
+}
+
+func (s *E2ETestSuite) TestClaimTaskBatch() {
+	t := s.T()
+	ctx := context.Background()
+
+	queue := fmt.Sprintf("e2e-batch-%d", time.Now().UnixNano())
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		ids = append(ids, s.createTask(models.CreateTaskRequest{Title: "Batched E2E Task", Queue: queue}))
+	}
+
+	req := models.ClaimTaskRequest{WorkerID: "e2e-worker", Queues: []string{queue}}
+	tasks, _, err := s.client.ClaimTasks(ctx, req, 2, 0)
+	s.Require().NoError(err)
+	s.Require().Len(tasks, 2)
+	assert.Equal(t, ids[:2], []int64{tasks[0].ID, tasks[1].ID})
+
+	// The last task is left for the next claim
+	rest, _, err := s.client.ClaimTasks(ctx, req, 2, 0)
+	s.Require().NoError(err)
+	s.Require().Len(rest, 1)
+	assert.Equal(t, ids[2], rest[0].ID)
+
+	// A missing task is reported without failing the others
+	response, err := s.client.CompleteTasks(ctx, models.CompleteTaskBatchRequest{
+		WorkerID: "e2e-worker",
+		Tasks: []models.CompletedTask{
+			{ID: tasks[0].ID, Result: json.RawMessage(`{"ok": true}`)},
+			{ID: tasks[1].ID},
+			{ID: -1},
+		},
+	})
+	s.Require().NoError(err)
+	assert.Equal(t, 2, response.Succeeded)
+	assert.Equal(t, http.StatusNotFound, response.Results[2].Status)
+
+	completed, err := s.client.GetTask(ctx, tasks[0].ID)
+	s.Require().NoError(err)
+	assert.Equal(t, "completed", completed.Status)
+	assert.JSONEq(t, `{"ok": true}`, string(completed.Result))
+}
+
+func (s *E2ETestSuite) TestClaimTaskPriority() {
+	t := s.T()
+
+	lowID := s.createTask(models.CreateTaskRequest{Title: "Bulk E2E Task", Priority: 999})
+	highID := s.createTask(models.CreateTaskRequest{Title: "Escalation E2E Task", Priority: 1000})
+
+	// The escalation was created last but is handed out first
+	claimBody, _ := json.Marshal(models.ClaimTaskRequest{WorkerID: "e2e-worker"})
+	for _, expectedID := range []int64{highID, lowID} {
+		claimResp, err := http.Post(
+			fmt.Sprintf("%s/api/v1/tasks/claim", s.server.URL),
+			"application/json",
+			bytes.NewBuffer(claimBody),
+		)
+		s.Require().NoError(err)
+		s.Require().Equal(http.StatusOK, claimResp.StatusCode)
+
+		var claimed models.Task
+		err = json.NewDecoder(claimResp.Body).Decode(&claimed)
+		claimResp.Body.Close()
+		s.Require().NoError(err)
+		assert.Equal(t, expectedID, claimed.ID)
+	}
+}
+
+func (s *E2ETestSuite) TestClaimTaskFromQueue() {
+	t := s.T()
+
+	// A queue of its own keeps other tests' tasks out of the way
+	queue := fmt.Sprintf("e2e-%d", time.Now().UnixNano())
+	s.createTask(models.CreateTaskRequest{Title: "Default Queue E2E Task", Priority: 1000})
+	taskID := s.createTask(models.CreateTaskRequest{Title: "Queued E2E Task", Queue: queue})
+
+	claimBody, _ := json.Marshal(models.ClaimTaskRequest{WorkerID: "e2e-worker", Queues: []string{queue}})
+	claim := func() *http.Response {
+		claimResp, err := http.Post(
+			fmt.Sprintf("%s/api/v1/tasks/claim", s.server.URL),
+			"application/json",
+			bytes.NewBuffer(claimBody),
+		)
+		s.Require().NoError(err)
+		return claimResp
+	}
+
+	claimResp := claim()
+	s.Require().Equal(http.StatusOK, claimResp.StatusCode)
+	var claimed models.Task
+	err := json.NewDecoder(claimResp.Body).Decode(&claimed)
+	claimResp.Body.Close()
+	s.Require().NoError(err)
+	assert.Equal(t, taskID, claimed.ID)
+	assert.Equal(t, queue, claimed.Queue)
+
+	// The higher priority task on the default queue is not handed out
+	claimResp = claim()
+	claimResp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, claimResp.StatusCode)
+}
+
+func (s *E2ETestSuite) TestClaimTaskConcurrencyLimit() {
+	t := s.T()
+
+	queue := fmt.Sprintf("e2e-%d", time.Now().UnixNano())
+	maxInFlight := 1
+	queueBody, _ := json.Marshal(models.CreateQueueRequest{Name: queue, MaxInFlight: &maxInFlight})
+	queueResp, err := http.Post(
+		fmt.Sprintf("%s/api/v1/queues", s.server.URL),
+		"application/json",
+		bytes.NewBuffer(queueBody),
+	)
+	s.Require().NoError(err)
+	queueResp.Body.Close()
+	s.Require().Equal(http.StatusCreated, queueResp.StatusCode)
+
+	firstID := s.createTask(models.CreateTaskRequest{Title: "First Limited E2E Task", Queue: queue})
+	s.createTask(models.CreateTaskRequest{Title: "Second Limited E2E Task", Queue: queue})
+
+	claimBody, _ := json.Marshal(models.ClaimTaskRequest{WorkerID: "e2e-worker", Queues: []string{queue}})
+	claim := func() *http.Response {
+		claimResp, err := http.Post(
+			fmt.Sprintf("%s/api/v1/tasks/claim", s.server.URL),
+			"application/json",
+			bytes.NewBuffer(claimBody),
+		)
+		s.Require().NoError(err)
+		return claimResp
+	}
+
+	claimResp := claim()
+	s.Require().Equal(http.StatusOK, claimResp.StatusCode)
+	var claimed models.Task
+	err = json.NewDecoder(claimResp.Body).Decode(&claimed)
+	claimResp.Body.Close()
+	s.Require().NoError(err)
+	assert.Equal(t, firstID, claimed.ID)
+
+	// The second task waits until the first one is done
+	claimResp = claim()
+	claimResp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, claimResp.StatusCode)
+	assert.NotEmpty(t, claimResp.Header.Get("Retry-After"))
+
+	resp, _ := s.postTaskAction(firstID, "complete", models.CompleteTaskRequest{WorkerID: "e2e-worker"})
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+	claimResp = claim()
+	claimResp.Body.Close()
+	assert.Equal(t, http.StatusOK, claimResp.StatusCode)
+}
+
+func (s *E2ETestSuite) TestClaimTaskRateLimit() {
+	t := s.T()
+
+	queue := fmt.Sprintf("e2e-%d", time.Now().UnixNano())
+	rateLimit, ratePeriod := 1, 60
+	queueBody, _ := json.Marshal(models.CreateQueueRequest{Name: queue, RateLimit: &rateLimit, RatePeriodSeconds: &ratePeriod})
+	queueResp, err := http.Post(
+		fmt.Sprintf("%s/api/v1/queues", s.server.URL),
+		"application/json",
+		bytes.NewBuffer(queueBody),
+	)
+	s.Require().NoError(err)
+	queueResp.Body.Close()
+	s.Require().Equal(http.StatusCreated, queueResp.StatusCode)
+
+	s.createTask(models.CreateTaskRequest{Title: "First Rate Limited E2E Task", Queue: queue})
+	s.createTask(models.CreateTaskRequest{Title: "Second Rate Limited E2E Task", Queue: queue})
+
+	claimBody, _ := json.Marshal(models.ClaimTaskRequest{WorkerID: "e2e-worker", Queues: []string{queue}})
+	claim := func() *http.Response {
+		claimResp, err := http.Post(
+			fmt.Sprintf("%s/api/v1/tasks/claim", s.server.URL),
+			"application/json",
+			bytes.NewBuffer(claimBody),
+		)
+		s.Require().NoError(err)
+		return claimResp
+	}
+
+	claimResp := claim()
+	claimResp.Body.Close()
+	assert.Equal(t, http.StatusOK, claimResp.StatusCode)
+
+	// The bucket only refills after a minute
+	claimResp = claim()
+	claimResp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, claimResp.StatusCode)
+	assert.NotEmpty(t, claimResp.Header.Get("Retry-After"))
+}
+
+func (s *E2ETestSuite) TestCompleteTask() {
+	t := s.T()
+
+	taskID := s.createTask(models.CreateTaskRequest{
+		Title:   "Completable E2E Task",
+		Payload: json.RawMessage(`{"items": [1, 2, 3]}`),
+	})
+	claimed := s.claimTask("e2e-worker", taskID)
+	assert.JSONEq(t, `{"items": [1, 2, 3]}`, string(claimed.Payload))
+
+	// Another worker cannot complete the task
+	resp, _ := s.postTaskAction(taskID, "complete", models.CompleteTaskRequest{WorkerID: "someone-else"})
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp, task := s.postTaskAction(taskID, "complete", models.CompleteTaskRequest{
+		WorkerID: "e2e-worker",
+		Result:   json.RawMessage(`{"processed": true}`),
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, models.TaskStatusCompleted, task.Status)
+	assert.JSONEq(t, `{"processed": true}`, string(task.Result))
+}
+
+func (s *E2ETestSuite) TestCreateTaskIdempotently() {
+	t := s.T()
+
+	key := fmt.Sprintf("e2e-%d", time.Now().UnixNano())
+	post := func(req models.CreateTaskRequest) (*http.Response, int64) {
+		body, _ := json.Marshal(req)
+		httpReq, err := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/tasks", s.server.URL), bytes.NewBuffer(body))
+		s.Require().NoError(err)
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("Idempotency-Key", key)
+
+		resp, err := http.DefaultClient.Do(httpReq)
+		s.Require().NoError(err)
+		defer resp.Body.Close()
+
+		var result struct {
+			ID int64 `json:"id"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp, result.ID
+	}
+
+	resp, firstID := post(models.CreateTaskRequest{Title: "Idempotent E2E Task"})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Idempotent-Replayed"))
+
+	// A retry gets the same task back
+	resp, secondID := post(models.CreateTaskRequest{Title: "Idempotent E2E Task"})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("Idempotent-Replayed"))
+	assert.Equal(t, firstID, secondID)
+
+	// Reusing the key for another request is rejected
+	resp, _ = post(models.CreateTaskRequest{Title: "Another E2E Task"})
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+}
+
+func (s *E2ETestSuite) TestCreateUniqueTask() {
+	t := s.T()
+
+	uniqueKey := fmt.Sprintf("e2e-unique-%d", time.Now().UnixNano())
+	post := func(policy string) (*http.Response, int64) {
+		body, _ := json.Marshal(models.CreateTaskRequest{Title: "Unique E2E Task", UniqueKey: uniqueKey, UniquePolicy: policy})
+		resp, err := http.Post(fmt.Sprintf("%s/api/v1/tasks", s.server.URL), "application/json", bytes.NewBuffer(body))
+		s.Require().NoError(err)
+		defer resp.Body.Close()
+
+		var result struct {
+			ID int64 `json:"id"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp, result.ID
+	}
+
+	resp, firstID := post("")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, _ = post(models.UniquePolicyReject)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp, existingID := post(models.UniquePolicyReturnExisting)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, firstID, existingID)
+
+	// Replacing cancels the pending task
+	resp, replacementID := post(models.UniquePolicyReplace)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.NotEqual(t, firstID, replacementID)
+	assert.Equal(t, models.TaskStatusCancelled, s.getTask(firstID).Status)
+}
+
+// getTask fetches a task through the API
+func (s *E2ETestSuite) getTask(taskID int64) models.Task {
+	task, err := s.client.GetTask(context.Background(), taskID)
+	s.Require().NoError(err)
+	return *task
+}
+
+func (s *E2ETestSuite) TestTaskDependencies() {
+	t := s.T()
+
+	extractID := s.createTask(models.CreateTaskRequest{Title: "Extract E2E Task"})
+	transformID := s.createTask(models.CreateTaskRequest{Title: "Transform E2E Task", DependsOn: []int64{extractID}})
+	loadID := s.createTask(models.CreateTaskRequest{Title: "Load E2E Task", DependsOn: []int64{transformID}})
+	assert.Equal(t, models.TaskStatusBlocked, s.getTask(transformID).Status)
+
+	// Completing a dependency releases the next task
+	s.claimTask("e2e-worker", extractID)
+	resp, _ := s.postTaskAction(extractID, "complete", models.CompleteTaskRequest{WorkerID: "e2e-worker"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, models.TaskStatusPending, s.getTask(transformID).Status)
+	assert.Equal(t, models.TaskStatusBlocked, s.getTask(loadID).Status)
+
+	graphResp, err := http.Get(fmt.Sprintf("%s/api/v1/tasks/%d/graph", s.server.URL, loadID))
+	s.Require().NoError(err)
+	defer graphResp.Body.Close()
+	var graph models.TaskGraph
+	s.Require().NoError(json.NewDecoder(graphResp.Body).Decode(&graph))
+	assert.Len(t, graph.Tasks, 3)
+	assert.Len(t, graph.Dependencies, 2)
+
+	// A failed dependency cancels what is downstream of it
+	s.claimTask("e2e-worker", transformID)
+	retry := false
+	resp, _ = s.postTaskAction(transformID, "fail", models.FailTaskRequest{WorkerID: "e2e-worker", Error: "bad input", Retry: &retry})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, models.TaskStatusCancelled, s.getTask(loadID).Status)
+}
+
+func (s *E2ETestSuite) TestCreateTaskBatch() {
+	t := s.T()
+
+	post := func(url, contentType, body string) (*http.Response, models.TaskBatchResponse) {
+		resp, err := http.Post(url, contentType, bytes.NewBufferString(body))
+		s.Require().NoError(err)
+		defer resp.Body.Close()
+
+		var result models.TaskBatchResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp, result
+	}
+	url := fmt.Sprintf("%s/api/v1/tasks/batch", s.server.URL)
+
+	resp, result := post(url, "application/json", `[{"title": "Batch E2E Task 1"}, {"title": "Batch E2E Task 2", "priority": 3}]`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	s.Require().Len(result.Results, 2)
+	assert.Equal(t, "Batch E2E Task 2", s.getTask(result.Results[1].ID).Title)
+
+	// Invalid tasks are skipped by default
+	resp, result = post(url, "application/x-ndjson", "{\"title\": \"Batch E2E Task 3\"}\n{\"title\": \"\"}\n")
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Equal(t, 1, result.Succeeded)
+	assert.Equal(t, http.StatusBadRequest, result.Results[1].Status)
+
+	// and fail the whole batch when it is atomic
+	resp, result = post(url+"?atomic=true", "application/json", `[{"title": "Batch E2E Task 4"}, {"title": ""}]`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, 0, result.Succeeded)
+}
+
+func (s *E2ETestSuite) TestBatchCompletion() {
+	t := s.T()
+
+	hooks := make(chan models.Batch, 1)
+	hookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch models.Batch
+		json.NewDecoder(r.Body).Decode(&batch)
+		hooks <- batch
+	}))
+	defer hookServer.Close()
+
+	body, _ := json.Marshal(models.CreateBatchRequest{
+		Name:         "E2E Batch",
+		Tasks:        []models.CreateTaskRequest{{Title: "Batch E2E Member 1"}, {Title: "Batch E2E Member 2"}},
+		CallbackTask: &models.CreateTaskRequest{Title: "Batch E2E Callback"},
+		CallbackURL:  hookServer.URL,
+	})
+	resp, err := http.Post(fmt.Sprintf("%s/api/v1/batches", s.server.URL), "application/json", bytes.NewBuffer(body))
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	s.Require().Equal(http.StatusCreated, resp.StatusCode)
+
+	var created models.CreateBatchResponse
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&created))
+	s.Require().Len(created.Results, 2)
+
+	getBatch := func() models.Batch {
+		resp, err := http.Get(fmt.Sprintf("%s/api/v1/batches/%d", s.server.URL, created.ID))
+		s.Require().NoError(err)
+		defer resp.Body.Close()
+
+		var batch models.Batch
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&batch))
+		return batch
+	}
+
+	for _, result := range created.Results {
+		s.claimTask("e2e-worker", result.ID)
+		resp, _ := s.postTaskAction(result.ID, "complete", models.CompleteTaskRequest{WorkerID: "e2e-worker"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.Equal(t, 100, getBatch().Progress)
+
+	// The reaper finishes the batch and fires its callbacks
+	taskReaper := reaper.NewReaper(s.db, s.redisClient, reaper.NewConfig())
+	_, err = taskReaper.FinishBatches(context.Background())
+	s.Require().NoError(err)
+	_, err = taskReaper.DeliverWebhooks(context.Background())
+	s.Require().NoError(err)
+
+	batch := getBatch()
+	s.Require().NotNil(batch.FinishedAt)
+	s.Require().NotNil(batch.CallbackTaskID)
+	assert.Equal(t, "Batch E2E Callback", s.getTask(*batch.CallbackTaskID).Title)
+
+	select {
+	case hook := <-hooks:
+		assert.Equal(t, created.ID, hook.ID)
+		assert.Equal(t, 2, hook.Counts[models.TaskStatusCompleted])
+	case <-time.After(5 * time.Second):
+		t.Fatal("Batch webhook was not delivered")
+	}
+}
+
+func (s *E2ETestSuite) TestCancelTask() {
+	t := s.T()
+
+	// A pending task is cancelled straight away
+	pendingID := s.createTask(models.CreateTaskRequest{Title: "Cancelled E2E Task"})
+	resp, task := s.postTaskAction(pendingID, "cancel", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, models.TaskStatusCancelled, task.Status)
+
+	// A running task is flagged, and cancelled once its worker gives up
+	runningID := s.createTask(models.CreateTaskRequest{Title: "Running Cancelled E2E Task"})
+	s.claimTask("e2e-worker", runningID)
+	resp, _ = s.postTaskAction(runningID, "cancel", nil)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	resp, task = s.postTaskAction(runningID, "heartbeat", models.HeartbeatRequest{WorkerID: "e2e-worker"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotNil(t, task.CancelRequestedAt)
+
+	resp, task = s.postTaskAction(runningID, "fail", models.FailTaskRequest{WorkerID: "e2e-worker", Error: "cancelled"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, models.TaskStatusCancelled, task.Status)
+
+	resp, _ = s.postTaskAction(runningID, "cancel", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func (s *E2ETestSuite) TestTaskTimeout() {
+	t := s.T()
+
+	timeout := 1
+	taskID := s.createTask(models.CreateTaskRequest{Title: "Timed Out E2E Task", TimeoutSeconds: &timeout})
+	s.claimTask("e2e-worker", taskID)
+
+	// Heartbeats keep the lease alive, but not past the deadline
+	time.Sleep(1500 * time.Millisecond)
+	resp, _ := s.postTaskAction(taskID, "heartbeat", models.HeartbeatRequest{WorkerID: "e2e-worker"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	taskReaper := reaper.NewReaper(s.db, s.redisClient, reaper.NewConfig())
+	_, err := taskReaper.ReapExpired(context.Background())
+	s.Require().NoError(err)
+
+	task := s.getTask(taskID)
+	assert.Equal(t, models.TaskStatusPending, task.Status)
+	assert.Equal(t, 1, task.Attempts)
+	s.Require().NotNil(task.LastError)
+	assert.Equal(t, "timed out after 1 seconds", *task.LastError)
+}
+
+func (s *E2ETestSuite) TestDeadWorker() {
+	t := s.T()
+	ctx := context.Background()
+
+	workerID := fmt.Sprintf("e2e-worker-%d", time.Now().UnixNano())
+	_, err := s.client.RegisterWorker(ctx, models.RegisterWorkerRequest{WorkerID: workerID, Hostname: "e2e", Concurrency: 2})
+	s.Require().NoError(err)
+
+	taskID := s.createTask(models.CreateTaskRequest{Title: "Abandoned E2E Task"})
+	s.claimTask(workerID, taskID)
+
+	worker, err := s.client.GetWorker(ctx, workerID)
+	s.Require().NoError(err)
+	assert.Equal(t, models.WorkerStatusAlive, worker.Status)
+	var leased []int64
+	for _, task := range worker.LeasedTasks {
+		leased = append(leased, task.ID)
+	}
+	assert.Contains(t, leased, taskID)
+
+	// The worker stops heartbeating long before its 60 second lease expires
+	time.Sleep(1500 * time.Millisecond)
+	config := reaper.NewConfig()
+	config.WorkerTimeout = time.Second
+	dead, released, err := reaper.NewReaper(s.db, s.redisClient, config).ReapDeadWorkers(ctx)
+	s.Require().NoError(err)
+	assert.GreaterOrEqual(t, dead, 1)
+	assert.GreaterOrEqual(t, released, 1)
+
+	worker, err = s.client.GetWorker(ctx, workerID)
+	s.Require().NoError(err)
+	assert.Equal(t, models.WorkerStatusDead, worker.Status)
+	assert.Empty(t, worker.LeasedTasks)
+
+	task := s.getTask(taskID)
+	assert.Equal(t, models.TaskStatusPending, task.Status)
+	s.Require().NotNil(task.LastError)
+	assert.Equal(t, "worker stopped heartbeating", *task.LastError)
+
+	// Heartbeating again revives the worker without giving the task back
+	worker, err = s.client.WorkerHeartbeat(ctx, workerID)
+	s.Require().NoError(err)
+	assert.Equal(t, models.WorkerStatusAlive, worker.Status)
+	assert.Empty(t, worker.LeasedTasks)
+
+	// So does leasing a task, which the reaper then leaves alone
+	time.Sleep(1500 * time.Millisecond)
+	_, _, err = reaper.NewReaper(s.db, s.redisClient, config).ReapDeadWorkers(ctx)
+	s.Require().NoError(err)
+	worker, err = s.client.GetWorker(ctx, workerID)
+	s.Require().NoError(err)
+	assert.Equal(t, models.WorkerStatusDead, worker.Status)
+
+	nextID := s.createTask(models.CreateTaskRequest{Title: "Revived E2E Task"})
+	s.claimTask(workerID, nextID)
+	_, _, err = reaper.NewReaper(s.db, s.redisClient, config).ReapDeadWorkers(ctx)
+	s.Require().NoError(err)
+
+	worker, err = s.client.GetWorker(ctx, workerID)
+	s.Require().NoError(err)
+	assert.Equal(t, models.WorkerStatusAlive, worker.Status)
+	assert.Equal(t, models.TaskStatusInProgress, s.getTask(nextID).Status)
+}
+
+func (s *E2ETestSuite) TestFailTask() {
+	t := s.T()
+
+	maxAttempts := 2
+	taskID := s.createTask(models.CreateTaskRequest{Title: "Failing E2E Task", MaxAttempts: &maxAttempts})
+	s.claimTask("e2e-worker", taskID)
+
+	// The first failure is retried later
+	resp, task := s.postTaskAction(taskID, "fail", models.FailTaskRequest{WorkerID: "e2e-worker", Error: "first failure"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, models.TaskStatusPending, task.Status)
+	assert.Equal(t, 1, task.Attempts)
+	assert.True(t, task.RunAt.After(task.UpdatedAt))
+
+	// The lease was released, so the worker can no longer report on the task
+	resp, _ = s.postTaskAction(taskID, "fail", models.FailTaskRequest{WorkerID: "e2e-worker", Error: "second failure"})
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
+
+func (s *E2ETestSuite) TestDeadLetterReplay() {
+	t := s.T()
+
+	maxAttempts := 1
+	taskID := s.createTask(models.CreateTaskRequest{Title: "Poison E2E Task", MaxAttempts: &maxAttempts})
+	s.claimTask("e2e-worker", taskID)
+
+	// Exhausting the only attempt moves the task to the dead letter queue
+	resp, task := s.postTaskAction(taskID, "fail", models.FailTaskRequest{WorkerID: "e2e-worker", Error: "cannot parse input"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, models.TaskStatusDead, task.Status)
+
+	listResp, err := http.Get(fmt.Sprintf("%s/api/v1/dead-letter?size=100", s.server.URL))
+	s.Require().NoError(err)
+	var dead []models.Task
+	s.Require().NoError(json.NewDecoder(listResp.Body).Decode(&dead))
+	listResp.Body.Close()
+
+	found := false
+	for _, d := range dead {
+		if d.ID == taskID {
+			found = true
+			if assert.NotNil(t, d.LastError) {
+				assert.Equal(t, "cannot parse input", *d.LastError)
+			}
+		}
+	}
+	assert.True(t, found, "dead task should be listed in the dead letter queue")
+
+	// Replaying returns it to pending with a fresh set of attempts
+	replayBody, _ := json.Marshal(models.ReplayDeadLetterRequest{IDs: []int64{taskID}})
+	replayResp, err := http.Post(
+		fmt.Sprintf("%s/api/v1/dead-letter/replay", s.server.URL),
+		"application/json",
+		bytes.NewBuffer(replayBody),
+	)
+	s.Require().NoError(err)
+	assert.Equal(t, http.StatusOK, replayResp.StatusCode)
+	replayResp.Body.Close()
+
+	getResp, err := http.Get(fmt.Sprintf("%s/api/v1/tasks/%d", s.server.URL, taskID))
+	s.Require().NoError(err)
+	var replayed models.Task
+	s.Require().NoError(json.NewDecoder(getResp.Body).Decode(&replayed))
 	getResp.Body.Close()
+	assert.Equal(t, models.TaskStatusPending, replayed.Status)
+	assert.Equal(t, 0, replayed.Attempts)
+
+	// The attempt history survives the replay
+	attemptsResp, err := http.Get(fmt.Sprintf("%s/api/v1/tasks/%d/attempts", s.server.URL, taskID))
+	s.Require().NoError(err)
+	var attempts []models.TaskAttempt
+	s.Require().NoError(json.NewDecoder(attemptsResp.Body).Decode(&attempts))
+	attemptsResp.Body.Close()
+	assert.Len(t, attempts, 1)
 }
@@ -1,10 +1,8 @@
 package e2e
 
 import (
-	"bytes"
+	"context"
 	"database/sql"
-	"encoding/json"
-	"fmt"
 	"net/http"
 	"os"
 	"strconv"
@@ -17,6 +15,7 @@ import (
 	"github.com/queuet/internal/handlers"
 	"github.com/queuet/internal/models"
 	"github.com/queuet/internal/routes"
+	"github.com/queuet/pkg/client"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/suite"
 )
@@ -53,11 +52,11 @@ func (s *TaskE2ETestSuite) SetupSuite() {
 	s.cache = redisClient
 
 	// Initialize task handler
-	s.taskHandler = handlers.NewTaskHandler(s.db, s.cache)
+	s.taskHandler = handlers.NewTaskHandler(s.db, s.cache, cache.NewRateLimiter(redisClient), nil, handlers.NewConfig())
 
 	// Start the server
 	r := chi.NewRouter()
-	routes.SetupRoutes(r, s.taskHandler)
+	routes.SetupRoutes(r, s.taskHandler, handlers.NewScheduleHandler(s.db, handlers.NewConfig()), handlers.NewQueueHandler(s.db), handlers.NewWorkerHandler(s.db))
 
 	s.server = &http.Server{
 		Addr:    ":8080",
@@ -87,129 +86,61 @@ func (s *TaskE2ETestSuite) TearDownSuite() {
 }
 
 func (s *TaskE2ETestSuite) TestCompleteTaskFlow() {
+	ctx := context.Background()
+	c := client.New(client.Config{URL: "http://localhost:8080"})
+
 	// Create a task
-	task := models.Task{
+	req := models.CreateTaskRequest{
 		Title:       "Test Task",
 		Description: "Test Description",
 	}
-
-	taskJSON, err := json.Marshal(task)
-	s.Require().NoError(err)
-
-	resp, err := http.Post("http://localhost:8080/api/v1/tasks", "application/json", bytes.NewBuffer(taskJSON))
-	s.Require().NoError(err)
-	s.Equal(http.StatusCreated, resp.StatusCode)
-
-	var createdTask models.Task
-	err = json.NewDecoder(resp.Body).Decode(&createdTask)
+	taskID, err := c.CreateTask(ctx, req)
 	s.Require().NoError(err)
-	resp.Body.Close()
 
 	// Get the task
-	resp, err = http.Get(fmt.Sprintf("http://localhost:8080/api/v1/tasks/%d", createdTask.ID))
-	s.Require().NoError(err)
-	s.Equal(http.StatusOK, resp.StatusCode)
-
-	var retrievedTask models.Task
-	err = json.NewDecoder(resp.Body).Decode(&retrievedTask)
+	retrievedTask, err := c.GetTask(ctx, taskID)
 	s.Require().NoError(err)
-	resp.Body.Close()
 
-	s.Equal(createdTask.ID, retrievedTask.ID)
-	s.Equal(task.Title, retrievedTask.Title)
-	s.Equal(task.Description, retrievedTask.Description)
+	s.Equal(taskID, retrievedTask.ID)
+	s.Equal(req.Title, retrievedTask.Title)
+	s.Equal(req.Description, retrievedTask.Description)
 
 	// Update the task
-	retrievedTask.Status = "completed"
-	taskJSON, err = json.Marshal(retrievedTask)
-	s.Require().NoError(err)
-
-	req, err := http.NewRequest("PUT", fmt.Sprintf("http://localhost:8080/api/v1/tasks/%d", retrievedTask.ID), bytes.NewBuffer(taskJSON))
+	updatedTask, err := c.UpdateTask(ctx, taskID, models.UpdateTaskRequest{Status: "completed"})
 	s.Require().NoError(err)
-	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err = client.Do(req)
-	s.Require().NoError(err)
-	s.Equal(http.StatusOK, resp.StatusCode)
-
-	var updatedTask models.Task
-	err = json.NewDecoder(resp.Body).Decode(&updatedTask)
-	s.Require().NoError(err)
-	resp.Body.Close()
-
-	s.Equal(retrievedTask.ID, updatedTask.ID)
+	s.Equal(taskID, updatedTask.ID)
 	s.Equal("completed", updatedTask.Status)
 
 	// Delete the task
-	req, err = http.NewRequest("DELETE", fmt.Sprintf("http://localhost:8080/api/v1/tasks/%d", updatedTask.ID), nil)
-	s.Require().NoError(err)
-
-	resp, err = client.Do(req)
-	s.Require().NoError(err)
-	s.Equal(http.StatusNoContent, resp.StatusCode)
-	resp.Body.Close()
+	s.Require().NoError(c.DeleteTask(ctx, taskID))
 
 	// Verify task is deleted
-	resp, err = http.Get(fmt.Sprintf("http://localhost:8080/api/v1/tasks/%d", updatedTask.ID))
-	s.Require().NoError(err)
-	s.Equal(http.StatusNotFound, resp.StatusCode)
-	resp.Body.Close()
+	_, err = c.GetTask(ctx, taskID)
+	s.ErrorIs(err, client.ErrNotFound)
 }
 
 func (s *TaskE2ETestSuite) TestTaskValidation() {
+	ctx := context.Background()
+	c := client.New(client.Config{URL: "http://localhost:8080"})
+
 	// Test empty title
-	task := models.CreateTaskRequest{
+	_, err := c.CreateTask(ctx, models.CreateTaskRequest{
 		Title:       "",
 		Description: "Test Description",
-	}
-
-	taskJSON, err := json.Marshal(task)
-	s.Require().NoError(err)
-
-	resp, err := http.Post("http://localhost:8080/api/v1/tasks", "application/json", bytes.NewBuffer(taskJSON))
-	s.Require().NoError(err)
-	s.Equal(http.StatusBadRequest, resp.StatusCode)
-	resp.Body.Close()
+	})
+	s.ErrorIs(err, client.ErrBadRequest)
 
 	// Test invalid status
-	task = models.CreateTaskRequest{
+	taskID, err := c.CreateTask(ctx, models.CreateTaskRequest{
 		Title:       "Test Task",
 		Description: "Test Description",
-	}
-
-	taskJSON, err = json.Marshal(task)
+	})
 	s.Require().NoError(err)
 
-	resp, err = http.Post("http://localhost:8080/api/v1/tasks", "application/json", bytes.NewBuffer(taskJSON))
-	s.Require().NoError(err)
-	s.Equal(http.StatusCreated, resp.StatusCode)
-
-	// Get the task ID from the response
-	var createResp struct {
-		ID int64 `json:"id"`
-	}
-	err = json.NewDecoder(resp.Body).Decode(&createResp)
-	s.Require().NoError(err)
-	resp.Body.Close()
-
 	// Try to update with invalid status
-	updateReq := models.UpdateTaskRequest{
-		Status: "invalid",
-	}
-
-	taskJSON, err = json.Marshal(updateReq)
-	s.Require().NoError(err)
-
-	req, err := http.NewRequest("PUT", fmt.Sprintf("http://localhost:8080/api/v1/tasks/%d", createResp.ID), bytes.NewBuffer(taskJSON))
-	s.Require().NoError(err)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err = client.Do(req)
-	s.Require().NoError(err)
-	s.Equal(http.StatusBadRequest, resp.StatusCode)
-	resp.Body.Close()
+	_, err = c.UpdateTask(ctx, taskID, models.UpdateTaskRequest{Status: "invalid"})
+	s.ErrorIs(err, client.ErrBadRequest)
 }
 
 func TestTaskE2E(t *testing.T) {